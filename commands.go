@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"oauth-basic/src/auth"
+	"oauth-basic/src/config"
+	"oauth-basic/src/keys"
+)
+
+const usage = `Usage:
+  oauth2-server                   start the server
+  oauth2-server revoke-key <kid>  revoke a compromised signing key
+  oauth2-server hash-secret       hash a client secret read from stdin
+`
+
+// runCommand runs a maintenance command instead of the server.
+func runCommand(cfg config.Config, args []string) {
+	switch args[0] {
+	case "revoke-key":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		revokeKey(cfg, args[1])
+	case "hash-secret":
+		hashSecret()
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// revokeKey revokes kid in the shared key storage of KEY_SECRET or
+// KEY_DIRECTORY, or adds it to the revocation file, KEY_REVOCATION_FILE or its
+// default next to the keys. It also works while the server is down; running
+// servers revoke the key within a minute and switch to a fresh key if it was
+// signing.
+func revokeKey(cfg config.Config, kid string) {
+	if err := keys.RevokeKey(cfg.Keys, kid, time.Now()); err != nil {
+		log.Fatalf("Error revoking key: %v", err)
+	}
+	fmt.Printf("Revoked signing key %s\n", kid)
+}
+
+// hashSecret prints the PBKDF2 hash of the client secret on the first line of
+// stdin, for use as client_secret in the clients file or CLIENT_SECRET.
+func hashSecret() {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		log.Fatalf("Error reading secret: %v", err)
+	}
+	secret := strings.TrimRight(line, "\r\n")
+	if secret == "" {
+		log.Fatalf("No secret given on stdin")
+	}
+	hash, err := auth.HashSecret(secret)
+	if err != nil {
+		log.Fatalf("Error hashing secret: %v", err)
+	}
+	fmt.Println(hash)
+}
@@ -25,7 +25,7 @@ const docTemplate = `{
     "paths": {
         "/.well-known/jwks.json": {
             "get": {
-                "description": "Returns the RSA public signing keys in JWK format, which can be used to verify JWT signatures.",
+                "description": "Returns the public signing keys (RSA, EC and Ed25519) in JWK format, which can be used to verify JWT signatures.\nThe response carries an ETag, Last-Modified and a Cache-Control max-age short enough that caches pick up a pre-published key before it signs. Conditional requests with If-None-Match or If-Modified-Since are answered with 304.",
                 "produces": [
                     "application/json"
                 ],
@@ -33,6 +33,14 @@ const docTemplate = `{
                     "keys"
                 ],
                 "summary": "Retrieve Public Signing Keys",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ETag of a cached copy",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -41,8 +49,236 @@ const docTemplate = `{
                             "additionalProperties": true
                         }
                     },
+                    "304": {
+                        "description": "Not modified",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
-                        "description": "Error getting keys\" or \"Error encoding keys",
+                        "description": "Error getting keys",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/keys": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Lists every signing key with its lifecycle status: pending, active, retiring or revoked.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List Signing Keys",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/handlers.KeyInfo"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Generates a pending key for an algorithm that already has an active key. The key is published in the JWKS right away and signs once it is promoted, or when rotation is enabled once it is due.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Generate Signing Key",
+                "parameters": [
+                    {
+                        "description": "Algorithm of the new key",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.GenerateKeyRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.KeyInfo"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Key cannot be generated",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/keys/{kid}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Removes a retiring key from the JWKS. This is refused while tokens signed by the key may still be valid, see safe_to_unpublish_at, unless force is set.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Unpublish Signing Key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key ID",
+                        "name": "kid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Unpublish even if tokens signed by the key may still be valid",
+                        "name": "force",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.KeyInfo"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown key",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Key cannot be unpublished yet",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/keys/{kid}/promote": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Makes a pending key the active key of its algorithm. The previously active key retires and stays published until its tokens have expired. A key can only be promoted once it has been published for the JWKS max-age.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Promote Signing Key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key ID",
+                        "name": "kid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.KeyInfo"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown key",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Key is not pending or not published long enough",
                         "schema": {
                             "type": "string"
                         }
@@ -50,6 +286,142 @@ const docTemplate = `{
                 }
             }
         },
+        "/admin/keys/{kid}/retire": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Retires a pending key. Retiring keys stay published until the tokens they signed have expired. The active key cannot be retired, promote its successor instead.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Retire Signing Key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key ID",
+                        "name": "kid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.KeyInfo"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown key",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Key cannot be retired",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/keys/{kid}/revoke": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Removes a compromised key from the JWKS immediately, tokens it signed are no longer accepted. Revoking the active key switches issuance to a freshly generated key in the same step. Revocations are kept in the revocation file and survive restarts.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Revoke Signing Key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key ID",
+                        "name": "kid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.KeyInfo"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown key",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Key cannot be revoked",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/health": {
+            "get": {
+                "description": "Reports that the server is up, together with the active crypto policy: the allowed token signing algorithms, the minimum key strength and RSA key size, and whether client secrets have to be hashed.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Health Check",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.HealthResponse"
+                        }
+                    }
+                }
+            }
+        },
         "/introspect": {
             "get": {
                 "security": [
@@ -81,16 +453,56 @@ const docTemplate = `{
                 }
             }
         },
-        "/token": {
+        "/keys.pem": {
             "get": {
+                "description": "Returns the published public signing keys as PEM encoded SubjectPublicKeyInfo blocks, each preceded by its kid and alg. With kid, only that key is returned.",
+                "produces": [
+                    "application/x-pem-file"
+                ],
+                "tags": [
+                    "keys"
+                ],
+                "summary": "Retrieve Public Signing Keys as PEM",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key ID",
+                        "name": "kid",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "PEM encoded public keys",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown key",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error getting keys",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/token": {
+            "post": {
                 "security": [
                     {
                         "BasicAuth": []
                     }
                 ],
-                "description": "Validates client credentials and returns a JWT token. Use Basic Auth with 'testuser' and 'testpassword' as credentials.\nUse Basic Auth with 'testuser' and 'testpassword' as credentials.",
+                "description": "Issues an access token with the client credentials grant (RFC 6749 section 4.4). Authenticate the client with Basic Auth, e.g. 'testuser' and 'testpassword', with client_id and client_secret in the form body if the client may use client_secret_post, or with a client_assertion (RFC 7523) if it may use private_key_jwt, or with its TLS client certificate and client_id (RFC 8705), and send grant_type=client_credentials as form body.\nTokens requested with a TLS client certificate carry its thumbprint as cnf claim.\nTokens requested with a DPoP proof (RFC 9449) are bound to its key with cnf.jkt and have the token_type DPoP.\nErrors are returned as JSON with error and error_description (RFC 6749 section 5.2).",
                 "consumes": [
-                    "application/json"
+                    "application/x-www-form-urlencoded"
                 ],
                 "produces": [
                     "application/json"
@@ -99,6 +511,51 @@ const docTemplate = `{
                     "token"
                 ],
                 "summary": "Generate JWT Token",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "DPoP proof binding the token to the client's key",
+                        "name": "DPoP",
+                        "in": "header"
+                    },
+                    {
+                        "enum": [
+                            "client_credentials"
+                        ],
+                        "type": "string",
+                        "description": "Grant type",
+                        "name": "grant_type",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client id for client_secret_post and TLS client authentication",
+                        "name": "client_id",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client secret for client_secret_post",
+                        "name": "client_secret",
+                        "in": "formData"
+                    },
+                    {
+                        "enum": [
+                            "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+                        ],
+                        "type": "string",
+                        "description": "Client assertion type for private_key_jwt",
+                        "name": "client_assertion_type",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "JWT signed by the client for private_key_jwt",
+                        "name": "client_assertion",
+                        "in": "formData"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -106,16 +563,28 @@ const docTemplate = `{
                             "$ref": "#/definitions/handlers.TokenResponse"
                         }
                     },
+                    "400": {
+                        "description": "invalid_request, e.g. for two authentication methods, unsupported_grant_type or invalid_dpop_proof",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.TokenErrorResponse"
+                        }
+                    },
                     "401": {
-                        "description": "Unauthorized",
+                        "description": "invalid_client",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/handlers.TokenErrorResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "invalid_request",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.TokenErrorResponse"
                         }
                     },
                     "500": {
-                        "description": "Error generating token",
+                        "description": "server_error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/handlers.TokenErrorResponse"
                         }
                     }
                 }
@@ -123,12 +592,67 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "cryptopolicy.Policy": {
+            "type": "object",
+            "properties": {
+                "algorithms": {
+                    "description": "Algorithms are the JWS algorithms tokens may be signed and verified\nwith, all supported ones if empty.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "hashed_client_secrets": {
+                    "description": "HashedClientSecrets only accepts client secrets hashed with PBKDF2, see auth.HashSecret.",
+                    "type": "boolean"
+                },
+                "min_key_strength": {
+                    "description": "MinKeyStrength is the security strength in bits every signing key needs\nat least, see keys.Strength.",
+                    "type": "integer"
+                },
+                "min_rsa_bits": {
+                    "description": "MinRSABits is the modulus size RSA keys need at least.",
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.GenerateKeyRequest": {
+            "type": "object",
+            "properties": {
+                "alg": {
+                    "description": "Alg is the algorithm of the new key, empty for the default algorithm.",
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.HealthResponse": {
+            "type": "object",
+            "properties": {
+                "crypto_policy": {
+                    "$ref": "#/definitions/cryptopolicy.Policy"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
         "handlers.IntrospectionResponse": {
             "type": "object",
             "properties": {
                 "active": {
                     "type": "boolean"
                 },
+                "cnf": {
+                    "description": "Confirmation is the key the token is bound to, e.g. the thumbprint of a client certificate.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/jwt.Confirmation"
+                        }
+                    ]
+                },
                 "exp": {
                     "type": "integer"
                 },
@@ -143,6 +667,62 @@ const docTemplate = `{
                 },
                 "sub": {
                     "type": "string"
+                },
+                "token_type": {
+                    "description": "TokenType is DPoP for tokens bound to a DPoP key, which resource servers\nmust only accept together with a proof (RFC 9449, section 7).",
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.KeyInfo": {
+            "type": "object",
+            "properties": {
+                "activated_at": {
+                    "type": "string"
+                },
+                "alg": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "issued_tokens": {
+                    "description": "IssuedTokens counts the tokens the key signed since the server started.",
+                    "type": "integer"
+                },
+                "kid": {
+                    "type": "string"
+                },
+                "last_issued_at": {
+                    "type": "string"
+                },
+                "max_token_lifetime": {
+                    "description": "MaxTokenLifetime is the longest lifetime of a token the key signed, in seconds.",
+                    "type": "integer"
+                },
+                "retired_at": {
+                    "type": "string"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "safe_to_unpublish_at": {
+                    "description": "SafeToUnpublishAt is when every token the key signed has expired. It is\nonly set for retiring keys.",
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.TokenErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "error_description": {
+                    "type": "string"
                 }
             }
         },
@@ -159,6 +739,19 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "jwt.Confirmation": {
+            "type": "object",
+            "properties": {
+                "jkt": {
+                    "description": "JKT is the JWK thumbprint of the DPoP key the token is bound to (RFC 9449, section 6).",
+                    "type": "string"
+                },
+                "x5t#S256": {
+                    "description": "X5tS256 is the SHA-256 thumbprint of the client certificate the token\nis bound to (RFC 8705, section 3.1).",
+                    "type": "string"
+                }
+            }
         }
     },
     "securityDefinitions": {
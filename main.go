@@ -22,42 +22,90 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net/http"
 	_ "oauth-basic/docs"
+	"oauth-basic/src/auth"
 	"oauth-basic/src/config"
 	"oauth-basic/src/handlers"
 	"oauth-basic/src/keys"
 	. "oauth-basic/src/utils"
+	"os"
 
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprint(w, "Server is up")
-}
-
 func main() {
 	// Load configuration (e.g., port, key paths)
 	cfg := config.Load()
-	keys.InitializeKeys()
+	if len(os.Args) > 1 {
+		runCommand(cfg, os.Args[1:])
+		return
+	}
+	cfg.CryptoPolicy.Activate()
+	cfg.Keys.TokenLifetime = handlers.TokenLifetime
+	ring, err := keys.LoadKeys(cfg.Keys)
+	if err != nil {
+		log.Fatalf("Error loading signing key: %v", err)
+	}
+	go ring.Run(context.Background())
+
+	auth.SetSigningAlgorithms(ring.Algorithms())
+	auth.SetTokenEndpoint(cfg.TokenURL)
+	if cfg.ClientsFile != "" {
+		if err := auth.LoadClients(cfg.ClientsFile); err != nil {
+			log.Fatalf("Error loading clients: %v", err)
+		}
+	}
+	if cfg.TLSClientCAFile != "" {
+		if err := auth.LoadClientCAs(cfg.TLSClientCAFile); err != nil {
+			log.Fatalf("Error loading client CAs: %v", err)
+		}
+	}
+	if err := auth.CheckEnvClient(); err != nil {
+		log.Fatalf("Error loading clients: %v", err)
+	}
 
 	// Initialize logger
 	Logger.Println("Starting OAuth2 Server...")
+	Logger.Printf("Crypto policy: %s", cfg.CryptoPolicy.Name)
 
 	/* * because its a small project with small number of endpoint so i keep it in main
 	but usually i would use Gorilla Mux, Chi, or the built-in http.ServeMux and register the routes in a separate file.
 	*/
 	mux := http.NewServeMux()
-	mux.HandleFunc("/token", handlers.TokenHandler)
-	mux.HandleFunc("/.well-known/jwks.json", handlers.KeysHandler)
-	mux.HandleFunc("/introspect", handlers.IntrospectionHandler)
-	mux.HandleFunc("/health", healthHandler)
+	mux.Handle("/token", &handlers.TokenHandler{Keys: ring, URL: cfg.TokenURL})
+	mux.Handle("/.well-known/jwks.json", &handlers.KeysHandler{Keys: ring})
+	mux.Handle("/keys.pem", &handlers.PEMHandler{Keys: ring})
+	mux.Handle("/introspect", &handlers.IntrospectionHandler{Keys: ring})
+	admin := &handlers.AdminHandler{Keys: ring}
+	mux.HandleFunc("GET /admin/keys", admin.ListKeys)
+	mux.HandleFunc("POST /admin/keys", admin.GenerateKey)
+	mux.HandleFunc("POST /admin/keys/{kid}/promote", admin.PromoteKey)
+	mux.HandleFunc("POST /admin/keys/{kid}/retire", admin.RetireKey)
+	mux.HandleFunc("POST /admin/keys/{kid}/revoke", admin.RevokeKey)
+	mux.HandleFunc("DELETE /admin/keys/{kid}", admin.UnpublishKey)
+	mux.Handle("/health", &handlers.HealthHandler{Policy: cfg.CryptoPolicy})
 	mux.HandleFunc("/docs/", httpSwagger.WrapHandler)
 
 	// Start HTTP server
 	addr := fmt.Sprintf(":%s", cfg.Port)
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		// Client certificates are requested but verified per client, since
+		// self-signed ones are allowed for self_signed_tls_client_auth.
+		server := &http.Server{
+			Addr:      addr,
+			Handler:   mux,
+			TLSConfig: &tls.Config{ClientAuth: tls.RequestClientCert, MinVersion: tls.VersionTLS12},
+		}
+		Logger.Println("Server running with TLS on", addr)
+		if err := server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+			log.Fatalf("Error starting server: %v", err)
+		}
+	}
 	Logger.Println("Server running on", addr)
 	if err := http.ListenAndServe(addr, mux); err != nil {
 		log.Fatalf("Error starting server: %v", err)
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"oauth-basic/src/jwt"
+)
+
+// Client authentication methods at the token endpoint, named as in RFC 7591
+// section 2.
+const (
+	// AuthMethodClientSecretBasic sends the client credentials as Basic Auth.
+	AuthMethodClientSecretBasic = "client_secret_basic"
+	// AuthMethodClientSecretPost sends client_id and client_secret in the form body.
+	AuthMethodClientSecretPost = "client_secret_post"
+	// AuthMethodPrivateKeyJWT sends a JWT signed with a key of the client as
+	// client_assertion (RFC 7523, section 2.2).
+	AuthMethodPrivateKeyJWT = "private_key_jwt"
+	// AuthMethodTLSClientAuth presents a certificate issued by one of the
+	// client CAs for the registered subject (RFC 8705, section 2.1).
+	AuthMethodTLSClientAuth = "tls_client_auth"
+	// AuthMethodSelfSignedTLSClientAuth presents a certificate registered in
+	// the client's JWK set (RFC 8705, section 2.2).
+	AuthMethodSelfSignedTLSClientAuth = "self_signed_tls_client_auth"
+)
+
+// ClientAssertionTypeJWTBearer is the client_assertion_type of private_key_jwt.
+const ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// MaxAssertionLifetime is how far in the future the exp of a client assertion
+// may be. It bounds how long used assertions have to be remembered.
+const MaxAssertionLifetime = time.Hour
+
+// DefaultAuthMethods are the methods a client may use unless it declares its own.
+var DefaultAuthMethods = []string{AuthMethodClientSecretBasic}
+
+// authMethods are the supported client authentication methods.
+var authMethods = []string{
+	AuthMethodClientSecretBasic,
+	AuthMethodClientSecretPost,
+	AuthMethodPrivateKeyJWT,
+	AuthMethodTLSClientAuth,
+	AuthMethodSelfSignedTLSClientAuth,
+}
+
+// assertionReplays remembers the client assertions that were used.
+var assertionReplays = &jwt.ReplayCache{}
+
+var (
+	tokenEndpointMu sync.RWMutex
+	// tokenEndpoint is the public URL of the token endpoint, the audience of
+	// client assertions.
+	tokenEndpoint string
+)
+
+// SetTokenEndpoint sets the public URL of the token endpoint. Client
+// assertions are only accepted if they are addressed to it, and clients that
+// may use private_key_jwt can only be loaded once it is set.
+func SetTokenEndpoint(url string) {
+	tokenEndpointMu.Lock()
+	defer tokenEndpointMu.Unlock()
+	tokenEndpoint = url
+}
+
+// checkTokenEndpoint returns an error if c may use private_key_jwt before
+// SetTokenEndpoint configured the audience of its assertions.
+func checkTokenEndpoint(c Client) error {
+	tokenEndpointMu.RLock()
+	defer tokenEndpointMu.RUnlock()
+	if tokenEndpoint == "" && c.AllowsAuthMethod(AuthMethodPrivateKeyJWT) {
+		return fmt.Errorf("%s requires TOKEN_ENDPOINT_URL", AuthMethodPrivateKeyJWT)
+	}
+	return nil
+}
+
+var (
+	// ErrInvalidClient means the client is unknown, its credentials are
+	// missing or wrong, or it used a method it may not use.
+	ErrInvalidClient = errors.New("client authentication failed")
+	// ErrMultipleAuthMethods means the request authenticates the client with
+	// more than one method, which RFC 6749 section 2.3 forbids.
+	ErrMultipleAuthMethods = errors.New("more than one client authentication method used")
+)
+
+// AuthenticateClient authenticates the client of a token request with the
+// method the request uses: client_secret_basic, client_secret_post,
+// private_key_jwt or, if it sends none of their credentials, the TLS client
+// certificate for tls_client_auth or self_signed_tls_client_auth. Client
+// assertions have to be addressed to the URL set by SetTokenEndpoint. The form
+// of r has to be parsed. Errors wrap ErrInvalidClient or ErrMultipleAuthMethods.
+func AuthenticateClient(r *http.Request) (*Client, error) {
+	basic := hasBasicAuth(r)
+	post := r.PostForm.Has("client_secret")
+	assertion := r.PostForm.Has("client_assertion") || r.PostForm.Has("client_assertion_type")
+	if (basic && post) || (basic && assertion) || (post && assertion) {
+		return nil, ErrMultipleAuthMethods
+	}
+
+	switch {
+	case basic:
+		clientID, secret, ok := ExtractBasicAuthCredentials(r)
+		if !ok {
+			return nil, fmt.Errorf("%w: malformed Basic credentials", ErrInvalidClient)
+		}
+		// client_id may be repeated in the body, but has to name the same client.
+		if id := r.PostForm.Get("client_id"); id != "" && id != clientID {
+			return nil, fmt.Errorf("%w: client_id %s does not match the Basic credentials", ErrInvalidClient, id)
+		}
+		return authenticateSecret(clientID, secret, AuthMethodClientSecretBasic)
+	case post:
+		return authenticateSecret(r.PostForm.Get("client_id"), r.PostForm.Get("client_secret"), AuthMethodClientSecretPost)
+	case assertion:
+		tokenEndpointMu.RLock()
+		audience := tokenEndpoint
+		tokenEndpointMu.RUnlock()
+		return authenticateAssertion(r.PostForm, audience, time.Now())
+	case r.TLS != nil && len(r.TLS.PeerCertificates) > 0:
+		return authenticateCertificate(r.PostForm.Get("client_id"), r.TLS.PeerCertificates)
+	default:
+		return nil, fmt.Errorf("%w: no client credentials", ErrInvalidClient)
+	}
+}
+
+// authenticateSecret authenticates a client by its secret.
+func authenticateSecret(clientID, secret, method string) (*Client, error) {
+	client, err := lookupClientFor(clientID, method)
+	if err != nil {
+		return nil, err
+	}
+	if client.Secret == "" || !VerifySecret(client.Secret, secret) {
+		return nil, fmt.Errorf("%w: wrong secret for client %s", ErrInvalidClient, clientID)
+	}
+	return client, nil
+}
+
+// authenticateAssertion authenticates a client by a JWT signed with one of its
+// registered keys (RFC 7523, section 3), addressed to audience.
+func authenticateAssertion(form url.Values, audience string, now time.Time) (*Client, error) {
+	if assertionType := form.Get("client_assertion_type"); assertionType != ClientAssertionTypeJWTBearer {
+		return nil, fmt.Errorf("%w: unsupported client_assertion_type %q", ErrInvalidClient, assertionType)
+	}
+
+	var client *Client
+	claims, err := jwt.ParseAssertion(form.Get("client_assertion"), func(claims *jwt.AssertionClaims, kid, alg string) (interface{}, error) {
+		c, err := lookupClientFor(claims.Subject, AuthMethodPrivateKeyJWT)
+		if err != nil {
+			return nil, err
+		}
+		client = c
+		return c.assertionKey(kid, alg)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid client assertion: %v", ErrInvalidClient, err)
+	}
+
+	switch {
+	case claims.Issuer != client.ID:
+		return nil, fmt.Errorf("%w: client assertion issuer %q is not the client %s", ErrInvalidClient, claims.Issuer, client.ID)
+	case form.Get("client_id") != "" && form.Get("client_id") != client.ID:
+		return nil, fmt.Errorf("%w: client_id %s does not match the client assertion", ErrInvalidClient, form.Get("client_id"))
+	case audience == "" || !claims.Audience.ContainsAny(audience):
+		return nil, fmt.Errorf("%w: client assertion of %s is not addressed to %q", ErrInvalidClient, client.ID, audience)
+	case claims.ID == "":
+		return nil, fmt.Errorf("%w: client assertion of %s has no jti", ErrInvalidClient, client.ID)
+	}
+	expiry := time.Unix(claims.ExpiresAt, 0)
+	if expiry.After(now.Add(MaxAssertionLifetime)) {
+		return nil, fmt.Errorf("%w: client assertion of %s expires more than %v ahead", ErrInvalidClient, client.ID, MaxAssertionLifetime)
+	}
+	if !assertionReplays.Use(client.ID+" "+claims.ID, expiry, now) {
+		return nil, fmt.Errorf("%w: client assertion %s of %s was already used", ErrInvalidClient, claims.ID, client.ID)
+	}
+	return client, nil
+}
+
+// lookupClientFor returns the client if it may authenticate with method.
+func lookupClientFor(clientID, method string) (*Client, error) {
+	client, err := LookupClient(clientID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unknown client %q", ErrInvalidClient, clientID)
+	}
+	if !client.AllowsAuthMethod(method) {
+		return nil, fmt.Errorf("%w: client %s may not use %s", ErrInvalidClient, clientID, method)
+	}
+	return client, nil
+}
+
+// checkAuthMethods returns an error unless every method is supported.
+func checkAuthMethods(methods []string) error {
+	for _, method := range methods {
+		if !slices.Contains(authMethods, method) {
+			return fmt.Errorf("unsupported token endpoint auth method %q, use one of %v", method, authMethods)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth-basic/src/jwt"
+	"oauth-basic/src/keys"
+)
+
+// clientRequest builds a parsed token request with form as body.
+func clientRequest(t *testing.T, form url.Values) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := req.ParseForm(); err != nil {
+		t.Fatalf("Failed to parse form: %v", err)
+	}
+	return req
+}
+
+func TestAuthenticateClient(t *testing.T) {
+	if err := LoadClients(writeClientsFile(t, `[
+		{"client_id": "basic-only", "client_secret": "s1"},
+		{"client_id": "post-only", "client_secret": "s:2%", "token_endpoint_auth_methods": ["client_secret_post"]},
+		{"client_id": "both", "client_secret": "s3", "token_endpoint_auth_methods": ["client_secret_basic", "client_secret_post"]}
+	]`)); err != nil {
+		t.Fatalf("Unexpected error loading clients: %v", err)
+	}
+	defer LoadClients(writeClientsFile(t, `[]`))
+
+	basic := func(id, secret string, form url.Values) *http.Request {
+		req := clientRequest(t, form)
+		req.SetBasicAuth(url.QueryEscape(id), url.QueryEscape(secret))
+		return req
+	}
+	post := func(id, secret string) *http.Request {
+		return clientRequest(t, url.Values{"client_id": {id}, "client_secret": {secret}})
+	}
+
+	for _, tc := range []struct {
+		name  string
+		req   *http.Request
+		id    string
+		error error
+	}{
+		{"basic", basic("basic-only", "s1", nil), "basic-only", nil},
+		{"basic with client_id in body", basic("both", "s3", url.Values{"client_id": {"both"}}), "both", nil},
+		{"post", post("post-only", "s:2%"), "post-only", nil},
+		{"post by a basic client", post("basic-only", "s1"), "", ErrInvalidClient},
+		{"basic by a post client", basic("post-only", "s:2%", nil), "", ErrInvalidClient},
+		{"wrong secret", post("both", "wrong"), "", ErrInvalidClient},
+		{"unknown client", post("nobody", "s1"), "", ErrInvalidClient},
+		{"mismatched client_id", basic("both", "s3", url.Values{"client_id": {"basic-only"}}), "", ErrInvalidClient},
+		{"no credentials", clientRequest(t, url.Values{"client_id": {"both"}}), "", ErrInvalidClient},
+		{"two methods", basic("both", "s3", url.Values{"client_id": {"both"}, "client_secret": {"s3"}}), "", ErrMultipleAuthMethods},
+	} {
+		client, err := AuthenticateClient(tc.req)
+		if tc.error != nil {
+			if !errors.Is(err, tc.error) {
+				t.Errorf("%s: expected %v, got %v", tc.name, tc.error, err)
+			}
+			continue
+		}
+		if err != nil || client.ID != tc.id {
+			t.Errorf("%s: expected client %s, got %v", tc.name, tc.id, err)
+		}
+	}
+}
+
+func TestLoadClients_InvalidAuthMethod(t *testing.T) {
+	path := writeClientsFile(t, `[{"client_id": "svc", "client_secret": "s1", "token_endpoint_auth_methods": ["none"]}]`)
+	if err := LoadClients(path); err == nil {
+		t.Error("Expected an unsupported auth method to be rejected")
+	}
+}
+
+// ed25519JWK returns a new Ed25519 key of a client and its JWK.
+func ed25519JWK(t *testing.T, kid string) (ed25519.PrivateKey, keys.JWK) {
+	t.Helper()
+	pub, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return private, keys.JWK{Kty: "OKP", Crv: "Ed25519", Kid: kid, X: base64.RawURLEncoding.EncodeToString(pub)}
+}
+
+// assertionRequest builds a token request authenticated with a client assertion.
+func assertionRequest(t *testing.T, assertion string) *http.Request {
+	return clientRequest(t, url.Values{
+		"client_assertion_type": {ClientAssertionTypeJWTBearer},
+		"client_assertion":      {assertion},
+	})
+}
+
+func TestAuthenticateClient_PrivateKeyJWT(t *testing.T) {
+	const audience = "https://as.example.com/token"
+	SetTokenEndpoint(audience)
+	defer SetTokenEndpoint("")
+	key, jwk := ed25519JWK(t, "k1")
+	other, _ := ed25519JWK(t, "k1")
+	set, _ := json.Marshal(JWKSet{Keys: []keys.JWK{jwk}})
+	if err := LoadClients(writeClientsFile(t, `[
+		{"client_id": "keyed", "token_endpoint_auth_methods": ["private_key_jwt"], "jwks": `+string(set)+`},
+		{"client_id": "secret-only", "client_secret": "s1", "jwks": `+string(set)+`}
+	]`)); err != nil {
+		t.Fatalf("Unexpected error loading clients: %v", err)
+	}
+	defer LoadClients(writeClientsFile(t, `[]`))
+
+	now := time.Now().Unix()
+	claims := jwt.AssertionClaims{Issuer: "keyed", Subject: "keyed", Audience: jwt.Audience{audience}, ExpiresAt: now + 60}
+	sign := func(claims jwt.AssertionClaims, key ed25519.PrivateKey, jti string) string {
+		claims.ID = jti
+		assertion, err := jwt.GenerateAssertion(claims, key, "EdDSA", "k1")
+		if err != nil {
+			t.Fatalf("Failed to sign assertion: %v", err)
+		}
+		return assertion
+	}
+
+	valid := sign(claims, key, "jti-1")
+	client, err := AuthenticateClient(assertionRequest(t, valid))
+	if err != nil || client.ID != "keyed" {
+		t.Fatalf("Expected the client assertion to authenticate keyed: %v", err)
+	}
+	if _, err := AuthenticateClient(assertionRequest(t, valid)); !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("Expected a replayed client assertion to be rejected, got %v", err)
+	}
+
+	otherIssuer, wrongAudience, sharedIssuer, longLived, secretClient := claims, claims, claims, claims, claims
+	otherIssuer.Issuer = "secret-only"
+	wrongAudience.Audience = jwt.Audience{"https://other.example.com/token"}
+	sharedIssuer.Audience = jwt.Audience{"oauth2-server"}
+	longLived.ExpiresAt = now + int64(2*MaxAssertionLifetime/time.Second)
+	secretClient.Issuer, secretClient.Subject = "secret-only", "secret-only"
+	for name, assertion := range map[string]string{
+		"wrong key":        sign(claims, other, "jti-2"),
+		"issuer mismatch":  sign(otherIssuer, key, "jti-3"),
+		"wrong audience":   sign(wrongAudience, key, "jti-4"),
+		"issuer audience":  sign(sharedIssuer, key, "jti-8"),
+		"too long lived":   sign(longLived, key, "jti-5"),
+		"missing jti":      sign(claims, key, ""),
+		"secret client":    sign(secretClient, key, "jti-6"),
+		"not a JWT":        "garbage",
+		"unsigned segment": strings.Join(strings.Split(valid, ".")[:2], ".") + ".",
+	} {
+		if _, err := AuthenticateClient(assertionRequest(t, assertion)); !errors.Is(err, ErrInvalidClient) {
+			t.Errorf("%s: expected ErrInvalidClient, got %v", name, err)
+		}
+	}
+
+	req := assertionRequest(t, sign(claims, key, "jti-7"))
+	req.SetBasicAuth("keyed", "s1")
+	if _, err := AuthenticateClient(req); !errors.Is(err, ErrMultipleAuthMethods) {
+		t.Errorf("Expected a client assertion with Basic Auth to be rejected, got %v", err)
+	}
+}
+
+func TestAuthenticateClient_JWKSURI(t *testing.T) {
+	key, jwk := ed25519JWK(t, "k1")
+	var served []keys.JWK
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JWKSet{Keys: served})
+	}))
+	defer server.Close()
+	defer func(client *http.Client) { jwksClient = client }(jwksClient)
+	jwksClient = server.Client()
+	SetTokenEndpoint("https://as.example.com/token")
+	defer SetTokenEndpoint("")
+
+	if err := LoadClients(writeClientsFile(t, `[
+		{"client_id": "remote", "token_endpoint_auth_methods": ["private_key_jwt"], "jwks_uri": "`+server.URL+`/jwks.json"}
+	]`)); err != nil {
+		t.Fatalf("Unexpected error loading clients: %v", err)
+	}
+	defer LoadClients(writeClientsFile(t, `[]`))
+
+	served = []keys.JWK{jwk}
+	assertion, _ := jwt.GenerateAssertion(jwt.AssertionClaims{
+		Issuer: "remote", Subject: "remote", Audience: jwt.Audience{"https://as.example.com/token"},
+		ExpiresAt: time.Now().Add(time.Minute).Unix(), ID: "jti-1",
+	}, key, "EdDSA", "")
+	if client, err := AuthenticateClient(assertionRequest(t, assertion)); err != nil || client.ID != "remote" {
+		t.Errorf("Expected the key from jwks_uri to authenticate the client: %v", err)
+	}
+}
+
+func TestLoadClients_PrivateKeyJWTNeedsTokenEndpoint(t *testing.T) {
+	_, jwk := ed25519JWK(t, "k1")
+	set, _ := json.Marshal(JWKSet{Keys: []keys.JWK{jwk}})
+	clients := `[{"client_id": "keyed", "token_endpoint_auth_methods": ["private_key_jwt"], "jwks": ` + string(set) + `}]`
+	if err := LoadClients(writeClientsFile(t, clients)); err == nil {
+		t.Error("Expected a private_key_jwt client to be rejected without a token endpoint URL")
+	}
+}
+
+func TestLoadClients_PrivateKeyJWTNeedsKeys(t *testing.T) {
+	SetTokenEndpoint("https://as.example.com/token")
+	defer SetTokenEndpoint("")
+	for _, clients := range []string{
+		`[{"client_id": "svc", "token_endpoint_auth_methods": ["private_key_jwt"]}]`,
+		`[{"client_id": "svc", "token_endpoint_auth_methods": ["private_key_jwt"], "jwks_uri": "http://svc.example.com/jwks.json"}]`,
+		`[{"client_id": "svc", "token_endpoint_auth_methods": ["private_key_jwt"], "jwks": {"keys": [{"kty": "RSA", "n": "AQAB", "e": "AQAB"}]}}]`,
+	} {
+		if err := LoadClients(writeClientsFile(t, clients)); err == nil {
+			t.Errorf("Expected %s to be rejected", clients)
+		}
+	}
+}
@@ -2,21 +2,22 @@ package auth
 
 import (
 	"encoding/base64"
-	"errors"
 	"net/http"
+	"net/url"
 	. "oauth-basic/src/utils"
 	"os"
 	"strings"
 )
 
 // extracts the username and password from the Authorization header and returns as a string.
+// Both are form-url-decoded after splitting, as RFC 6749 section 2.3.1 requires
+// for client credentials, so they may contain ':' and '%' when encoded.
 func ExtractBasicAuthCredentials(r *http.Request) (string, string, bool) {
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" || !strings.HasPrefix(authHeader, "Basic ") {
+	scheme, encodedCredentials, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Basic") {
 		return "", "", false
 	}
 
-	encodedCredentials := strings.TrimPrefix(authHeader, "Basic ")
 	decodedBytes, err := base64.StdEncoding.DecodeString(encodedCredentials)
 	if err != nil {
 		Logger.Printf("Failed to decode base64: %v", err)
@@ -29,7 +30,23 @@ func ExtractBasicAuthCredentials(r *http.Request) (string, string, bool) {
 		Logger.Println("Invalid credentials format")
 		return "", "", false
 	}
-	return parts[0], parts[1], true
+	clientID, err := url.QueryUnescape(parts[0])
+	if err != nil {
+		Logger.Printf("Failed to decode client id: %v", err)
+		return "", "", false
+	}
+	clientSecret, err := url.QueryUnescape(parts[1])
+	if err != nil {
+		Logger.Printf("Failed to decode client secret: %v", err)
+		return "", "", false
+	}
+	return clientID, clientSecret, true
+}
+
+// hasBasicAuth reports whether r carries Basic credentials, valid or not.
+func hasBasicAuth(r *http.Request) bool {
+	scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	return strings.EqualFold(scheme, "Basic")
 }
 
 // checks if the provided credentials are valid.
@@ -43,7 +60,7 @@ func ValidateBasicAuth(r *http.Request) (string, bool) {
 	if err != nil {
 		return "", false
 	}
-	if providedClientSecret != expectedClientSecret {
+	if !VerifySecret(expectedClientSecret, providedClientSecret) {
 		return "", false
 	}
 
@@ -52,15 +69,14 @@ func ValidateBasicAuth(r *http.Request) (string, bool) {
 
 // lookup in db for the password with provided username and return it.
 func LookupClientSecret(clientID string) (string, error) {
-	// For demonstration, we assume that the client with "testid" has the password "testsecret".
-	// This would be a db operation but for now we just check from environment variables.
-
-	clientIDFromEnv, clientSecretFromEnv := LoadClientCredentialFromEnv()
-	if clientID == clientIDFromEnv {
-		return clientSecretFromEnv, nil
+	// This would be a db operation but for now we check the client from the
+	// environment variables and the clients file.
+	client, err := LookupClient(clientID)
+	if err != nil {
+		Logger.Printf("Client not found: %s", clientID)
+		return "", err
 	}
-	Logger.Printf("Client not found: %s", clientID)
-	return "", errors.New("client not found")
+	return client.Secret, nil
 }
 
 func LoadClientCredentialFromEnv() (string, string) {
@@ -3,6 +3,7 @@ package auth
 import (
 	"encoding/base64"
 	"net/http"
+	"net/url"
 	"os"
 	"testing"
 )
@@ -167,3 +168,29 @@ func TestLoadClientCredentialFromEnv(t *testing.T) {
 		t.Errorf("Expected CLIENT_SECRET %s, got %s", expectedClientSecret, clientSecret)
 	}
 }
+
+// check that the client id and secret are form-url-decoded (RFC 6749 section 2.3.1).
+func TestExtractBasicAuthCredentials_FormURLEncoded(t *testing.T) {
+	req, err := http.NewRequest("GET", "/dummy", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	credentials := url.QueryEscape("svc:1") + ":" + url.QueryEscape("p:ss%w rd+")
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+
+	client, secret, ok := ExtractBasicAuthCredentials(req)
+	if !ok {
+		t.Fatal("Expected credentials extraction to succeed but it failed")
+	}
+	if client != "svc:1" {
+		t.Errorf("Expected clientId 'svc:1', got '%s'", client)
+	}
+	if secret != "p:ss%w rd+" {
+		t.Errorf("Expected secret 'p:ss%%w rd+', got '%s'", secret)
+	}
+
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("svc:50%")))
+	if _, _, ok := ExtractBasicAuthCredentials(req); ok {
+		t.Error("Expected extraction to fail for an invalid percent encoding")
+	}
+}
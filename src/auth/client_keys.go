@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"oauth-basic/src/keys"
+)
+
+const (
+	// JWKSCacheTTL is how long a JWK set fetched from a client's jwks_uri is used.
+	JWKSCacheTTL = 5 * time.Minute
+	// jwksRefetchInterval is how soon a JWK set is fetched again for an
+	// unknown kid, so clients can rotate keys without waiting for JWKSCacheTTL.
+	jwksRefetchInterval = time.Minute
+	// maxJWKSSize limits the size of a fetched JWK set.
+	maxJWKSSize = 1 << 20
+)
+
+// jwksClient fetches the JWK sets of clients. Tests replace it.
+var jwksClient = &http.Client{Timeout: 10 * time.Second}
+
+// JWKSet is a JWK set (RFC 7517, section 5).
+type JWKSet struct {
+	Keys []keys.JWK `json:"keys"`
+}
+
+// cachedJWKS is a JWK set fetched from a jwks_uri.
+type cachedJWKS struct {
+	keys    []keys.JWK
+	fetched time.Time
+}
+
+var (
+	jwksCacheMu sync.Mutex
+	jwksCache   = map[string]cachedJWKS{}
+)
+
+// assertionKey returns the registered key of the client that verifies JWTs
+// signed with alg. kid may be empty if only one key fits.
+func (c *Client) assertionKey(kid, alg string) (interface{}, error) {
+	var key interface{}
+	err := c.withKeys(func(set []keys.JWK) (err error) {
+		key, err = findKey(set, kid, alg)
+		return err
+	})
+	return key, err
+}
+
+// withKeys calls find with the registered JWK set of the client. A set from a
+// jwks_uri is fetched again if find fails, as the client may have rotated its
+// keys since.
+func (c *Client) withKeys(find func(set []keys.JWK) error) error {
+	if c.JWKS != nil {
+		return find(c.JWKS.Keys)
+	}
+	if c.JWKSURI == "" {
+		return fmt.Errorf("client %s has no registered keys", c.ID)
+	}
+	set, fetched, err := clientJWKS(c.JWKSURI, false)
+	if err != nil {
+		return err
+	}
+	if err = find(set); err != nil && time.Since(fetched) >= jwksRefetchInterval {
+		if set, _, err = clientJWKS(c.JWKSURI, true); err != nil {
+			return err
+		}
+		return find(set)
+	}
+	return err
+}
+
+// findKey returns the public key of the signing key in set with kid that can
+// verify alg. With an empty kid, exactly one key has to fit.
+func findKey(set []keys.JWK, kid, alg string) (interface{}, error) {
+	var found interface{}
+	for _, jwk := range set {
+		if (kid != "" && jwk.Kid != kid) || (jwk.Use != "" && jwk.Use != "sig") || (jwk.Alg != "" && jwk.Alg != alg) {
+			continue
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil || keys.CheckAlgorithm(pub, alg) != nil {
+			continue
+		}
+		if found != nil {
+			return nil, errors.New("more than one registered key fits, the kid header is required")
+		}
+		found = pub
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no registered key with kid %q for %s", kid, alg)
+	}
+	return found, nil
+}
+
+// clientJWKS returns the JWK set at uri and when it was fetched. It is only
+// fetched if it is not cached, has expired or refresh is set.
+func clientJWKS(uri string, refresh bool) ([]keys.JWK, time.Time, error) {
+	jwksCacheMu.Lock()
+	cached, ok := jwksCache[uri]
+	jwksCacheMu.Unlock()
+	if ok && !refresh && time.Since(cached.fetched) < JWKSCacheTTL {
+		return cached.keys, cached.fetched, nil
+	}
+
+	resp, err := jwksClient.Get(uri)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("fetching JWK set %s: %w", uri, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("fetching JWK set %s: status %d", uri, resp.StatusCode)
+	}
+	var set JWKSet
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSSize)).Decode(&set); err != nil {
+		return nil, time.Time{}, fmt.Errorf("parsing JWK set %s: %w", uri, err)
+	}
+
+	cached = cachedJWKS{keys: set.Keys, fetched: time.Now()}
+	jwksCacheMu.Lock()
+	jwksCache[uri] = cached
+	jwksCacheMu.Unlock()
+	return cached.keys, cached.fetched, nil
+}
+
+// checkClientKeys returns an error unless a client that may use
+// private_key_jwt or self_signed_tls_client_auth registered either usable
+// inline keys or an https jwks_uri.
+func checkClientKeys(c Client) error {
+	if c.JWKS != nil && c.JWKSURI != "" {
+		return errors.New("jwks and jwks_uri are mutually exclusive")
+	}
+	if c.JWKS != nil {
+		for _, jwk := range c.JWKS.Keys {
+			if _, err := jwk.PublicKey(); err != nil {
+				return fmt.Errorf("jwks: key %q: %w", jwk.Kid, err)
+			}
+		}
+	}
+	if c.JWKSURI != "" {
+		if u, err := url.Parse(c.JWKSURI); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("jwks_uri %q is not an https URL", c.JWKSURI)
+		}
+	}
+	if (c.JWKS == nil || len(c.JWKS.Keys) == 0) && c.JWKSURI == "" {
+		for _, method := range []string{AuthMethodPrivateKeyJWT, AuthMethodSelfSignedTLSClientAuth} {
+			if c.AllowsAuthMethod(method) {
+				return fmt.Errorf("%s requires jwks or jwks_uri", method)
+			}
+		}
+	}
+	return nil
+}
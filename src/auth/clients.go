@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"oauth-basic/src/jwt"
+)
+
+// Client is a registered OAuth client.
+type Client struct {
+	ID string `json:"client_id"`
+	// Secret is the client secret or its hash from HashSecret.
+	Secret string `json:"client_secret"`
+	// TokenSigningAlg is the JWS algorithm the client's access tokens are signed
+	// with. Empty means the server default.
+	TokenSigningAlg string `json:"token_signing_alg,omitempty"`
+	// Role is put into the client's access tokens. Empty means jwt.RoleUser;
+	// jwt.RoleAdmin tokens may use the admin API.
+	Role jwt.Role `json:"role,omitempty"`
+	// AuthMethods are the methods the client may authenticate with at the
+	// token endpoint. Empty means DefaultAuthMethods.
+	AuthMethods []string `json:"token_endpoint_auth_methods,omitempty"`
+	// JWKS are the keys the client signs its client assertions with, for
+	// private_key_jwt. Mutually exclusive with JWKSURI.
+	JWKS *JWKSet `json:"jwks,omitempty"`
+	// JWKSURI is an https URL the client publishes its JWK set at.
+	JWKSURI string `json:"jwks_uri,omitempty"`
+	// The subject of the certificate for tls_client_auth is matched by one of
+	// these: the subject distinguished name (RFC 4514 string, e.g.
+	// "CN=svc,O=Example") or a subject alternative name.
+	TLSClientAuthSubjectDN string `json:"tls_client_auth_subject_dn,omitempty"`
+	TLSClientAuthSANDNS    string `json:"tls_client_auth_san_dns,omitempty"`
+	TLSClientAuthSANURI    string `json:"tls_client_auth_san_uri,omitempty"`
+	TLSClientAuthSANIP     string `json:"tls_client_auth_san_ip,omitempty"`
+	TLSClientAuthSANEmail  string `json:"tls_client_auth_san_email,omitempty"`
+}
+
+// AllowsAuthMethod reports whether the client may authenticate with method.
+func (c *Client) AllowsAuthMethod(method string) bool {
+	if len(c.AuthMethods) == 0 {
+		return slices.Contains(DefaultAuthMethods, method)
+	}
+	return slices.Contains(c.AuthMethods, method)
+}
+
+// usesSecret reports whether the client may authenticate with its secret.
+func (c *Client) usesSecret() bool {
+	return c.AllowsAuthMethod(AuthMethodClientSecretBasic) || c.AllowsAuthMethod(AuthMethodClientSecretPost)
+}
+
+// check returns an error if the client record cannot be used as configured.
+func (c *Client) check() error {
+	if c.Role != "" && c.Role != jwt.RoleAdmin && c.Role != jwt.RoleUser {
+		return fmt.Errorf("invalid role %q", c.Role)
+	}
+	if c.usesSecret() || c.Secret != "" {
+		if err := checkSecret(c.Secret); err != nil {
+			return err
+		}
+	}
+	if err := checkAuthMethods(c.AuthMethods); err != nil {
+		return err
+	}
+	if err := checkSigningAlg(c.TokenSigningAlg); err != nil {
+		return err
+	}
+	if err := checkTLSClientAuth(*c); err != nil {
+		return err
+	}
+	if err := checkTokenEndpoint(*c); err != nil {
+		return err
+	}
+	return checkClientKeys(*c)
+}
+
+// TokenRole returns the role for the client's access tokens.
+func (c *Client) TokenRole() jwt.Role {
+	if c.Role == "" {
+		return jwt.RoleUser
+	}
+	return c.Role
+}
+
+var (
+	clientsMu sync.RWMutex
+	clients   = map[string]Client{}
+)
+
+var (
+	signingAlgsMu sync.RWMutex
+	// signingAlgs are the algorithms the server has signing keys for, any if empty.
+	signingAlgs []string
+)
+
+// SetSigningAlgorithms sets the algorithms the server signs tokens with. The
+// token_signing_alg of clients loaded afterwards has to be one of them, so a
+// typo is found at startup instead of failing token requests.
+func SetSigningAlgorithms(algs []string) {
+	signingAlgsMu.Lock()
+	defer signingAlgsMu.Unlock()
+	signingAlgs = slices.Clone(algs)
+}
+
+// checkSigningAlg returns an error unless the server can sign tokens with alg.
+// An empty alg means the server default.
+func checkSigningAlg(alg string) error {
+	signingAlgsMu.RLock()
+	defer signingAlgsMu.RUnlock()
+	if alg != "" && len(signingAlgs) > 0 && !slices.Contains(signingAlgs, alg) {
+		return fmt.Errorf("no signing key for token_signing_alg %q, use one of %v", alg, signingAlgs)
+	}
+	return nil
+}
+
+// LoadClients registers the clients listed in the JSON file at path, e.g.
+//
+//	[{"client_id": "svc", "client_secret": "secret", "token_signing_alg": "ES256", "role": "user",
+//	  "token_endpoint_auth_methods": ["client_secret_post"]},
+//	 {"client_id": "keyed", "token_endpoint_auth_methods": ["private_key_jwt"], "jwks_uri": "https://keyed.example.com/jwks.json"}]
+func LoadClients(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading clients file: %w", err)
+	}
+	var list []Client
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("parsing clients file %s: %w", path, err)
+	}
+
+	loaded := make(map[string]Client, len(list))
+	for _, c := range list {
+		if c.ID == "" {
+			return fmt.Errorf("clients file %s: client without client_id", path)
+		}
+		if _, ok := loaded[c.ID]; ok {
+			return fmt.Errorf("clients file %s: duplicate client %s", path, c.ID)
+		}
+		if err := c.check(); err != nil {
+			return fmt.Errorf("clients file %s: client %s: %w", path, c.ID, err)
+		}
+		loaded[c.ID] = c
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	clients = loaded
+	return nil
+}
+
+// LookupClient returns the client record for clientID. The client configured
+// through the environment takes precedence over the clients file.
+func LookupClient(clientID string) (*Client, error) {
+	if clientID == "" {
+		return nil, errors.New("client not found")
+	}
+	if env := loadClientFromEnv(); clientID == env.ID {
+		return &env, nil
+	}
+
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+	if c, ok := clients[clientID]; ok {
+		return &c, nil
+	}
+	return nil, errors.New("client not found")
+}
+
+// CheckEnvClient returns an error if the client configured through the
+// environment cannot be used, e.g. because the crypto policy requires hashed
+// secrets or CLIENT_AUTH_METHODS or CLIENT_ROLE is invalid.
+func CheckEnvClient() error {
+	env := loadClientFromEnv()
+	if env.ID == "" {
+		return nil
+	}
+	if err := env.check(); err != nil {
+		return fmt.Errorf("client %s from the environment: %w", env.ID, err)
+	}
+	return nil
+}
+
+func loadClientFromEnv() Client {
+	clientID, clientSecret := LoadClientCredentialFromEnv()
+	return Client{
+		ID:              clientID,
+		Secret:          clientSecret,
+		TokenSigningAlg: os.Getenv("CLIENT_TOKEN_SIGNING_ALG"),
+		Role:            jwt.Role(os.Getenv("CLIENT_ROLE")),
+		AuthMethods:     splitList(os.Getenv("CLIENT_AUTH_METHODS")),
+		JWKSURI:         os.Getenv("CLIENT_JWKS_URI"),
+	}
+}
+
+// splitList splits a comma separated list, empty means nil.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"oauth-basic/src/jwt"
+)
+
+func writeClientsFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "clients.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write clients file: %v", err)
+	}
+	return path
+}
+
+func TestLoadClients_Valid(t *testing.T) {
+	path := writeClientsFile(t, `[
+		{"client_id": "legacy", "client_secret": "s1"},
+		{"client_id": "modern", "client_secret": "s2", "token_signing_alg": "ES256"}
+	]`)
+	if err := LoadClients(path); err != nil {
+		t.Fatalf("Unexpected error loading clients: %v", err)
+	}
+	defer LoadClients(writeClientsFile(t, `[]`))
+
+	client, err := LookupClient("modern")
+	if err != nil {
+		t.Fatalf("Expected client 'modern' to be found: %v", err)
+	}
+	if client.Secret != "s2" {
+		t.Errorf("Expected secret 's2', got '%s'", client.Secret)
+	}
+	if client.TokenSigningAlg != "ES256" {
+		t.Errorf("Expected token_signing_alg 'ES256', got '%s'", client.TokenSigningAlg)
+	}
+
+	client, err = LookupClient("legacy")
+	if err != nil {
+		t.Fatalf("Expected client 'legacy' to be found: %v", err)
+	}
+	if client.TokenSigningAlg != "" {
+		t.Errorf("Expected no token_signing_alg, got '%s'", client.TokenSigningAlg)
+	}
+	if client.TokenRole() != jwt.RoleUser {
+		t.Errorf("Expected the default role 'user', got '%s'", client.TokenRole())
+	}
+
+	if _, err := LookupClient("unknown"); err == nil {
+		t.Error("Expected an error for an unknown client, but got nil")
+	}
+}
+
+func TestLoadClients_Invalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"malformed":  `{"client_id": `,
+		"missing id": `[{"client_secret": "s"}]`,
+		"duplicate":  `[{"client_id": "a"}, {"client_id": "a"}]`,
+		"bad role":   `[{"client_id": "a", "role": "root"}]`,
+	} {
+		if err := LoadClients(writeClientsFile(t, content)); err == nil {
+			t.Errorf("Expected an error for a %s clients file, but got nil", name)
+		}
+	}
+}
+
+func TestLoadClients_UnknownSigningAlg(t *testing.T) {
+	SetSigningAlgorithms([]string{"RS256", "ES256"})
+	defer SetSigningAlgorithms(nil)
+
+	if err := LoadClients(writeClientsFile(t, `[{"client_id": "a", "client_secret": "s", "token_signing_alg": "ES265"}]`)); err == nil {
+		t.Error("Expected an error for a token_signing_alg without signing key, but got nil")
+	}
+	if err := LoadClients(writeClientsFile(t, `[{"client_id": "a", "client_secret": "s", "token_signing_alg": "ES256"}]`)); err != nil {
+		t.Errorf("Unexpected error for a token_signing_alg with signing key: %v", err)
+	}
+	LoadClients(writeClientsFile(t, `[]`))
+}
+
+func TestCheckEnvClient_InvalidRole(t *testing.T) {
+	os.Setenv("CLIENT_ID", "envclient")
+	os.Setenv("CLIENT_SECRET", "envsecret")
+	os.Setenv("CLIENT_ROLE", "superuser")
+	defer os.Unsetenv("CLIENT_ID")
+	defer os.Unsetenv("CLIENT_SECRET")
+	defer os.Unsetenv("CLIENT_ROLE")
+
+	if err := CheckEnvClient(); err == nil {
+		t.Error("Expected an error for an invalid CLIENT_ROLE, but got nil")
+	}
+	os.Setenv("CLIENT_ROLE", "admin")
+	if err := CheckEnvClient(); err != nil {
+		t.Errorf("Unexpected error for CLIENT_ROLE admin: %v", err)
+	}
+}
+
+func TestLookupClient_FromEnv(t *testing.T) {
+	os.Setenv("CLIENT_ID", "envclient")
+	os.Setenv("CLIENT_SECRET", "envsecret")
+	os.Setenv("CLIENT_TOKEN_SIGNING_ALG", "EdDSA")
+	os.Setenv("CLIENT_ROLE", "admin")
+	defer os.Unsetenv("CLIENT_ID")
+	defer os.Unsetenv("CLIENT_SECRET")
+	defer os.Unsetenv("CLIENT_TOKEN_SIGNING_ALG")
+	defer os.Unsetenv("CLIENT_ROLE")
+
+	client, err := LookupClient("envclient")
+	if err != nil {
+		t.Fatalf("Expected the environment client to be found: %v", err)
+	}
+	if client.Secret != "envsecret" {
+		t.Errorf("Expected secret 'envsecret', got '%s'", client.Secret)
+	}
+	if client.TokenSigningAlg != "EdDSA" {
+		t.Errorf("Expected token_signing_alg 'EdDSA', got '%s'", client.TokenSigningAlg)
+	}
+	if client.TokenRole() != jwt.RoleAdmin {
+		t.Errorf("Expected role 'admin', got '%s'", client.TokenRole())
+	}
+	if _, err := LookupClient(""); err == nil {
+		t.Error("Expected an error for an empty client id, but got nil")
+	}
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	. "oauth-basic/src/utils"
+)
+
+const (
+	// pbkdf2Prefix starts a client secret hashed by HashSecret.
+	pbkdf2Prefix = "$pbkdf2-sha256$"
+	// PBKDF2Iterations is the work factor of new secret hashes (OWASP recommendation for PBKDF2-HMAC-SHA256).
+	PBKDF2Iterations = 600000
+	pbkdf2SaltBytes  = 16
+	pbkdf2KeyBytes   = 32
+)
+
+// requireHashedSecrets rejects plaintext client secrets, see RequireHashedSecrets.
+var requireHashedSecrets atomic.Bool
+
+// RequireHashedSecrets makes client secrets only verify against PBKDF2 hashes,
+// e.g. because a crypto policy demands it. Plaintext secrets are rejected.
+func RequireHashedSecrets(require bool) {
+	requireHashedSecrets.Store(require)
+}
+
+// HashSecret hashes secret with PBKDF2-HMAC-SHA256 and a random salt. The
+// result is in PHC string format and can be used as client_secret:
+//
+//	$pbkdf2-sha256$i=600000$<salt>$<hash>
+func HashSecret(secret string) (string, error) {
+	salt := make([]byte, pbkdf2SaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	hash, err := pbkdf2.Key(sha256.New, secret, salt, PBKDF2Iterations, pbkdf2KeyBytes)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%si=%d$%s$%s", pbkdf2Prefix, PBKDF2Iterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// VerifySecret reports whether provided matches the stored client secret,
+// which is either a hash from HashSecret or, unless RequireHashedSecrets is
+// set, the secret itself.
+func VerifySecret(stored, provided string) bool {
+	if !strings.HasPrefix(stored, pbkdf2Prefix) {
+		if requireHashedSecrets.Load() {
+			Logger.Println("Rejecting a plaintext client secret, the crypto policy requires PBKDF2 hashes")
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(provided)) == 1
+	}
+	iterations, salt, hash, err := parseSecretHash(stored)
+	if err != nil {
+		Logger.Printf("Invalid client secret hash: %v", err)
+		return false
+	}
+	derived, err := pbkdf2.Key(sha256.New, provided, salt, iterations, len(hash))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(derived, hash) == 1
+}
+
+// checkSecret returns an error if stored cannot be verified against.
+func checkSecret(stored string) error {
+	if strings.HasPrefix(stored, pbkdf2Prefix) {
+		_, _, _, err := parseSecretHash(stored)
+		return err
+	}
+	if requireHashedSecrets.Load() {
+		return errors.New("client secret is not a PBKDF2 hash, which the crypto policy requires")
+	}
+	return nil
+}
+
+func parseSecretHash(stored string) (iterations int, salt, hash []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(stored, pbkdf2Prefix), "$")
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "i=") {
+		return 0, nil, nil, errors.New("expected $pbkdf2-sha256$i=<iterations>$<salt>$<hash>")
+	}
+	if iterations, err = strconv.Atoi(strings.TrimPrefix(parts[0], "i=")); err != nil || iterations < 1 {
+		return 0, nil, nil, errors.New("invalid iteration count")
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[1]); err != nil {
+		return 0, nil, nil, errors.New("invalid salt")
+	}
+	if hash, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil || len(hash) == 0 {
+		return 0, nil, nil, errors.New("invalid hash")
+	}
+	return iterations, salt, hash, nil
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashSecret(t *testing.T) {
+	hash, err := HashSecret("s3cret")
+	if err != nil {
+		t.Fatalf("Unexpected error hashing secret: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$pbkdf2-sha256$i=600000$") {
+		t.Errorf("Expected a PBKDF2 hash, got '%s'", hash)
+	}
+	if !VerifySecret(hash, "s3cret") {
+		t.Error("Expected the secret to match its hash")
+	}
+	if VerifySecret(hash, "wrong") {
+		t.Error("Expected a wrong secret not to match the hash")
+	}
+	if other, _ := HashSecret("s3cret"); other == hash {
+		t.Error("Expected hashes of the same secret to use different salts")
+	}
+}
+
+func TestVerifySecret_RequireHashedSecrets(t *testing.T) {
+	if !VerifySecret("s3cret", "s3cret") {
+		t.Error("Expected a plaintext secret to match by default")
+	}
+	RequireHashedSecrets(true)
+	defer RequireHashedSecrets(false)
+	if VerifySecret("s3cret", "s3cret") {
+		t.Error("Expected a plaintext secret to be rejected when hashes are required")
+	}
+	hash, _ := HashSecret("s3cret")
+	if !VerifySecret(hash, "s3cret") {
+		t.Error("Expected a hashed secret to match when hashes are required")
+	}
+}
+
+func TestLoadClients_RequireHashedSecrets(t *testing.T) {
+	RequireHashedSecrets(true)
+	defer RequireHashedSecrets(false)
+	if err := LoadClients(writeClientsFile(t, `[{"client_id": "plain", "client_secret": "s1"}]`)); err == nil {
+		t.Error("Expected a plaintext client secret to be rejected when hashes are required")
+	}
+	if err := LoadClients(writeClientsFile(t, `[{"client_id": "broken", "client_secret": "$pbkdf2-sha256$i=1$salt"}]`)); err == nil {
+		t.Error("Expected a malformed secret hash to be rejected")
+	}
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+
+	"oauth-basic/src/keys"
+)
+
+var (
+	clientCAsMu sync.RWMutex
+	// clientCAs verify the certificates of tls_client_auth clients.
+	clientCAs *x509.CertPool
+)
+
+// LoadClientCAs reads the PEM encoded CA certificates that issue the
+// certificates of tls_client_auth clients.
+func LoadClientCAs(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("client CA file %s contains no certificates", path)
+	}
+	clientCAsMu.Lock()
+	defer clientCAsMu.Unlock()
+	clientCAs = pool
+	return nil
+}
+
+// CertificateThumbprint returns the base64url encoded SHA-256 hash of the DER
+// encoding of cert, the x5t#S256 a token is bound to (RFC 8705, section 3.1).
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authenticateCertificate authenticates a client by the certificate chain it
+// presented in the TLS handshake (RFC 8705, section 2).
+func authenticateCertificate(clientID string, chain []*x509.Certificate) (*Client, error) {
+	client, err := LookupClient(clientID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unknown client %q", ErrInvalidClient, clientID)
+	}
+	var errs []error
+	if client.AllowsAuthMethod(AuthMethodTLSClientAuth) {
+		err := client.checkPKICertificate(chain)
+		if err == nil {
+			return client, nil
+		}
+		errs = append(errs, err)
+	}
+	if client.AllowsAuthMethod(AuthMethodSelfSignedTLSClientAuth) {
+		err := client.checkSelfSignedCertificate(chain[0])
+		if err == nil {
+			return client, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("%w: client %s may not authenticate with a certificate", ErrInvalidClient, clientID)
+	}
+	return nil, fmt.Errorf("%w: certificate of client %s: %v", ErrInvalidClient, clientID, errors.Join(errs...))
+}
+
+// checkPKICertificate returns an error unless chain is issued by one of the
+// client CAs and its leaf has the registered subject (RFC 8705, section 2.1).
+func (c *Client) checkPKICertificate(chain []*x509.Certificate) error {
+	clientCAsMu.RLock()
+	roots := clientCAs
+	clientCAsMu.RUnlock()
+	if roots == nil {
+		return errors.New("no client CAs are configured")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	leaf := chain[0]
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return err
+	}
+
+	var matches bool
+	switch {
+	case c.TLSClientAuthSubjectDN != "":
+		matches = leaf.Subject.String() == c.TLSClientAuthSubjectDN
+	case c.TLSClientAuthSANDNS != "":
+		matches = slices.Contains(leaf.DNSNames, c.TLSClientAuthSANDNS)
+	case c.TLSClientAuthSANURI != "":
+		matches = slices.ContainsFunc(leaf.URIs, func(u *url.URL) bool { return u.String() == c.TLSClientAuthSANURI })
+	case c.TLSClientAuthSANIP != "":
+		ip := net.ParseIP(c.TLSClientAuthSANIP)
+		matches = slices.ContainsFunc(leaf.IPAddresses, ip.Equal)
+	case c.TLSClientAuthSANEmail != "":
+		matches = slices.Contains(leaf.EmailAddresses, c.TLSClientAuthSANEmail)
+	}
+	if !matches {
+		return fmt.Errorf("subject %q does not match the registered one", leaf.Subject.String())
+	}
+	return nil
+}
+
+// checkSelfSignedCertificate returns an error unless cert is the first x5c
+// certificate of one of the client's registered keys (RFC 8705, section 2.2).
+func (c *Client) checkSelfSignedCertificate(cert *x509.Certificate) error {
+	return c.withKeys(func(set []keys.JWK) error {
+		for _, jwk := range set {
+			if len(jwk.X5c) == 0 {
+				continue
+			}
+			if der, err := base64.StdEncoding.DecodeString(jwk.X5c[0]); err == nil && bytes.Equal(der, cert.Raw) {
+				return nil
+			}
+		}
+		return errors.New("certificate is not registered")
+	})
+}
+
+// checkTLSClientAuth returns an error unless a client that may use
+// tls_client_auth registers exactly one way to match its certificate.
+func checkTLSClientAuth(c Client) error {
+	var registered int
+	for _, value := range []string{c.TLSClientAuthSubjectDN, c.TLSClientAuthSANDNS, c.TLSClientAuthSANURI, c.TLSClientAuthSANIP, c.TLSClientAuthSANEmail} {
+		if value != "" {
+			registered++
+		}
+	}
+	if registered > 1 {
+		return errors.New("only one of tls_client_auth_subject_dn and the tls_client_auth_san_* members may be set")
+	}
+	if c.AllowsAuthMethod(AuthMethodTLSClientAuth) && registered == 0 {
+		return fmt.Errorf("%s requires tls_client_auth_subject_dn or one of the tls_client_auth_san_* members", AuthMethodTLSClientAuth)
+	}
+	if c.TLSClientAuthSANIP != "" && net.ParseIP(c.TLSClientAuthSANIP) == nil {
+		return fmt.Errorf("tls_client_auth_san_ip %q is not an IP address", c.TLSClientAuthSANIP)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"oauth-basic/src/keys"
+)
+
+// issueCertificate creates a client certificate for dnsName, signed by parent
+// and parentKey or self-signed if parent is nil.
+func issueCertificate(t *testing.T, dnsName string, isCA bool, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: dnsName, Organization: []string{"Example"}},
+		DNSNames:              []string{dnsName},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return cert, key
+}
+
+// certificateRequest builds a parsed token request for clientID over a TLS
+// connection the client presented cert on.
+func certificateRequest(t *testing.T, clientID string, cert *x509.Certificate) *http.Request {
+	req := clientRequest(t, url.Values{"client_id": {clientID}})
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	return req
+}
+
+func TestAuthenticateClient_TLSClientAuth(t *testing.T) {
+	ca, caKey := issueCertificate(t, "ca.example.com", true, nil, nil)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o600)
+	if err := LoadClientCAs(caFile); err != nil {
+		t.Fatalf("Failed to load client CAs: %v", err)
+	}
+	defer func() { clientCAs = nil }()
+
+	issued, _ := issueCertificate(t, "svc.example.com", false, ca, caKey)
+	otherName, _ := issueCertificate(t, "other.example.com", false, ca, caKey)
+	selfSigned, _ := issueCertificate(t, "svc.example.com", false, nil, nil)
+	unregistered, _ := issueCertificate(t, "svc.example.com", false, nil, nil)
+
+	jwk := keys.JWK{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(selfSigned.PublicKey.(*ecdsa.PublicKey).X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(selfSigned.PublicKey.(*ecdsa.PublicKey).Y.FillBytes(make([]byte, 32))),
+		X5c: []string{base64.StdEncoding.EncodeToString(selfSigned.Raw)},
+	}
+	set, _ := json.Marshal(JWKSet{Keys: []keys.JWK{jwk}})
+	if err := LoadClients(writeClientsFile(t, `[
+		{"client_id": "pki", "token_endpoint_auth_methods": ["tls_client_auth"], "tls_client_auth_san_dns": "svc.example.com"},
+		{"client_id": "pki-dn", "token_endpoint_auth_methods": ["tls_client_auth"], "tls_client_auth_subject_dn": "CN=svc.example.com,O=Example"},
+		{"client_id": "self-signed", "token_endpoint_auth_methods": ["self_signed_tls_client_auth"], "jwks": `+string(set)+`},
+		{"client_id": "secret", "client_secret": "s1"}
+	]`)); err != nil {
+		t.Fatalf("Unexpected error loading clients: %v", err)
+	}
+	defer LoadClients(writeClientsFile(t, `[]`))
+
+	for _, tc := range []struct {
+		name     string
+		clientID string
+		cert     *x509.Certificate
+		ok       bool
+	}{
+		{"issued by a client CA", "pki", issued, true},
+		{"subject DN", "pki-dn", issued, true},
+		{"other SAN", "pki", otherName, false},
+		{"not issued by a client CA", "pki", selfSigned, false},
+		{"registered self-signed", "self-signed", selfSigned, true},
+		{"unregistered self-signed", "self-signed", unregistered, false},
+		{"secret client", "secret", issued, false},
+		{"no client_id", "", issued, false},
+	} {
+		client, err := AuthenticateClient(certificateRequest(t, tc.clientID, tc.cert))
+		if tc.ok && (err != nil || client.ID != tc.clientID) {
+			t.Errorf("%s: expected client %s to be authenticated: %v", tc.name, tc.clientID, err)
+		}
+		if !tc.ok && !errors.Is(err, ErrInvalidClient) {
+			t.Errorf("%s: expected ErrInvalidClient, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestLoadClients_TLSClientAuthNeedsSubject(t *testing.T) {
+	for _, clients := range []string{
+		`[{"client_id": "svc", "token_endpoint_auth_methods": ["tls_client_auth"]}]`,
+		`[{"client_id": "svc", "token_endpoint_auth_methods": ["tls_client_auth"], "tls_client_auth_san_dns": "a", "tls_client_auth_san_uri": "b"}]`,
+		`[{"client_id": "svc", "token_endpoint_auth_methods": ["tls_client_auth"], "tls_client_auth_san_ip": "not-an-ip"}]`,
+		`[{"client_id": "svc", "token_endpoint_auth_methods": ["self_signed_tls_client_auth"]}]`,
+	} {
+		if err := LoadClients(writeClientsFile(t, clients)); err == nil {
+			t.Errorf("Expected %s to be rejected", clients)
+		}
+	}
+}
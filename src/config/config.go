@@ -2,7 +2,12 @@ package config
 
 import (
 	"log"
+	"oauth-basic/src/cryptopolicy"
+	"oauth-basic/src/keys"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -10,6 +15,23 @@ import (
 // Config holds the configuration values for the application.
 type Config struct {
 	Port string
+	// TLSCertFile and TLSKeyFile make the server serve HTTPS and request
+	// client certificates. Plain HTTP is served if they are empty.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSClientCAFile holds the CA certificates for tls_client_auth clients.
+	TLSClientCAFile string
+	// Keys describes where the token signing keys are loaded from.
+	Keys keys.Options
+	// ClientsFile is an optional JSON file with further client records.
+	ClientsFile string
+	// TokenURL is the public URL of the token endpoint, which client
+	// assertions are addressed to. private_key_jwt requires it; DPoP proofs
+	// are checked against the URL of the request if it is empty.
+	TokenURL string
+	// CryptoPolicy constrains the keys, tokens and client secrets. Keys.Policy
+	// is already restricted to it.
+	CryptoPolicy cryptopolicy.Policy
 	// You might add other configuration like client credentials, etc.
 }
 
@@ -22,7 +44,157 @@ func Load() Config {
 	if port == "" {
 		port = "8080" // default port
 	}
-	return Config{
-		Port: port,
+	policy, err := cryptopolicy.Lookup(os.Getenv("CRYPTO_POLICY"))
+	if err != nil {
+		log.Fatalf("Invalid value for CRYPTO_POLICY: %v", err)
 	}
+	cfg := Config{
+		Port:            port,
+		TLSCertFile:     os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:      os.Getenv("TLS_KEY_FILE"),
+		TLSClientCAFile: os.Getenv("TLS_CLIENT_CA_FILE"),
+		Keys: keys.Options{
+			KeyFile:               os.Getenv("SIGNING_KEY_FILE"),
+			Algorithm:             os.Getenv("SIGNING_ALG"),
+			AdditionalKeyFiles:    getPerAlgorithm("SIGNING_KEY_FILES"),
+			GenerateKey:           getBool("SIGNING_KEY_GENERATE"),
+			Passphrase:            getSecret("SIGNING_KEY_PASSPHRASE"),
+			CertificateFiles:      getList("SIGNING_CERT_FILES"),
+			SelfIssueCertificates: getBool("SIGNING_CERT_SELF_ISSUE"),
+			RotationInterval:      getDuration("KEY_ROTATION_INTERVAL"),
+			PrePublish:            getDuration("KEY_PREPUBLISH_PERIOD"),
+			Transit:               getTransit(),
+			RevocationFile:        os.Getenv("KEY_REVOCATION_FILE"),
+			KeyDirectory:          os.Getenv("KEY_DIRECTORY"),
+			KeySecret:             getKeySecret(),
+			AdditionalAlgorithms:  getList("SIGNING_ADDITIONAL_ALGS"),
+			Policy: keys.KeyPolicy{
+				KeyType:     os.Getenv("KEY_TYPE"),
+				RSABits:     getInt("KEY_RSA_BITS"),
+				Curve:       os.Getenv("KEY_EC_CURVE"),
+				MinStrength: getInt("KEY_MIN_STRENGTH"),
+			},
+		},
+		ClientsFile:  os.Getenv("CLIENTS_FILE"),
+		TokenURL:     os.Getenv("TOKEN_ENDPOINT_URL"),
+		CryptoPolicy: policy,
+	}
+	if cfg.Keys.Policy, err = policy.KeyPolicy(cfg.Keys.Policy); err != nil {
+		log.Fatalf("Invalid key policy: %v", err)
+	}
+	return cfg
+}
+
+// getBool parses a boolean environment variable, unset means false.
+func getBool(name string) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", name, err)
+	}
+	return b
+}
+
+// getInt parses an integer environment variable, unset means zero.
+func getInt(name string) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", name, err)
+	}
+	return i
+}
+
+// getDuration parses a duration environment variable such as "24h", unset means zero.
+func getDuration(name string) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", name, err)
+	}
+	return d
+}
+
+// getTransit configures signing through Vault Transit when VAULT_TRANSIT_KEY is set.
+// The token is read from VAULT_TOKEN or the file in VAULT_TOKEN_FILE.
+func getTransit() *keys.TransitOptions {
+	key := os.Getenv("VAULT_TRANSIT_KEY")
+	if key == "" {
+		return nil
+	}
+	return &keys.TransitOptions{
+		Address:        os.Getenv("VAULT_ADDR"),
+		Token:          string(getSecret("VAULT_TOKEN")),
+		Mount:          os.Getenv("VAULT_TRANSIT_MOUNT"),
+		Key:            key,
+		AdditionalKeys: getPerAlgorithm("VAULT_TRANSIT_KEYS"),
+	}
+}
+
+// getKeySecret keeps the keys in the Kubernetes Secret KEY_SECRET when it is
+// set, accessed with the pod's service account.
+func getKeySecret() *keys.SecretOptions {
+	name := os.Getenv("KEY_SECRET")
+	if name == "" {
+		return nil
+	}
+	return &keys.SecretOptions{
+		Name:      name,
+		Namespace: os.Getenv("KEY_SECRET_NAMESPACE"),
+	}
+}
+
+// getSecret reads a secret from the environment variable name or, if name_FILE
+// is set, from that file, e.g. a mounted Kubernetes secret. A trailing newline
+// in the file is ignored.
+func getSecret(name string) []byte {
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		if value := os.Getenv(name); value != "" {
+			return []byte(value)
+		}
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Error reading %s_FILE: %v", name, err)
+	}
+	return []byte(strings.TrimRight(string(data), "\r\n"))
+}
+
+// getList parses a comma separated list, unset means empty.
+func getList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getPerAlgorithm parses a list of values per algorithm such as "ES256=/keys/es256.pem,EdDSA=/keys/ed25519.pem".
+func getPerAlgorithm(name string) map[string]string {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	values := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		alg, v, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || alg == "" || v == "" {
+			log.Fatalf("Invalid value for %s: expected ALG=value, got %q", name, entry)
+		}
+		values[alg] = v
+	}
+	return values
 }
@@ -0,0 +1,94 @@
+// Package cryptopolicy defines the crypto policies the server can run under.
+// A policy constrains the signing keys, the token algorithms and the
+// verification of client secrets together.
+package cryptopolicy
+
+import (
+	"fmt"
+	"slices"
+
+	"oauth-basic/src/auth"
+	"oauth-basic/src/jwt"
+	"oauth-basic/src/keys"
+)
+
+// Policy is a set of approved algorithms and key sizes.
+type Policy struct {
+	Name string `json:"name"`
+	// Algorithms are the JWS algorithms tokens may be signed and verified
+	// with, all supported ones if empty.
+	Algorithms []string `json:"algorithms,omitempty"`
+	// MinKeyStrength is the security strength in bits every signing key needs
+	// at least, see keys.Strength.
+	MinKeyStrength int `json:"min_key_strength"`
+	// MinRSABits is the modulus size RSA keys need at least.
+	MinRSABits int `json:"min_rsa_bits"`
+	// HashedClientSecrets only accepts client secrets hashed with PBKDF2, see auth.HashSecret.
+	HashedClientSecrets bool `json:"hashed_client_secrets"`
+}
+
+var (
+	// Default allows every supported algorithm and keys down to 2048 bit RSA.
+	Default = Policy{
+		Name:           "default",
+		MinKeyStrength: keys.DefaultMinStrength,
+		MinRSABits:     keys.MinRSAKeyBits,
+	}
+	// FIPS only allows approved algorithms and key sizes: RS256 and PS256
+	// with RSA keys of at least 3072 bits, ES256 and ES384, no EdDSA, and
+	// client secrets hashed with PBKDF2.
+	FIPS = Policy{
+		Name:                "fips",
+		Algorithms:          []string{"RS256", "PS256", "ES256", "ES384"},
+		MinKeyStrength:      128,
+		MinRSABits:          3072,
+		HashedClientSecrets: true,
+	}
+)
+
+// Lookup returns the policy called name, Default if name is empty.
+func Lookup(name string) (Policy, error) {
+	switch name {
+	case "", Default.Name:
+		return Default, nil
+	case FIPS.Name:
+		return FIPS, nil
+	default:
+		return Policy{}, fmt.Errorf("unknown crypto policy %q, use %s or %s", name, Default.Name, FIPS.Name)
+	}
+}
+
+// KeyPolicy restricts the configured key policy to what p allows. Settings
+// that p does not allow are an error rather than silently replaced.
+func (p Policy) KeyPolicy(configured keys.KeyPolicy) (keys.KeyPolicy, error) {
+	if len(p.Algorithms) > 0 {
+		for _, alg := range configured.Algorithms {
+			if !slices.Contains(p.Algorithms, alg) {
+				return keys.KeyPolicy{}, fmt.Errorf("signing algorithm %s is not allowed by the %s crypto policy", alg, p.Name)
+			}
+		}
+		if len(configured.Algorithms) == 0 {
+			configured.Algorithms = p.Algorithms
+		}
+	}
+	if configured.MinStrength == 0 {
+		configured.MinStrength = p.MinKeyStrength
+	} else if configured.MinStrength < p.MinKeyStrength {
+		return keys.KeyPolicy{}, fmt.Errorf("minimum key strength %d is below the %d bits of the %s crypto policy", configured.MinStrength, p.MinKeyStrength, p.Name)
+	}
+	if configured.RSABits == 0 {
+		configured.RSABits = max(keys.DefaultRSAKeyBits, p.MinRSABits)
+	} else if configured.RSABits < p.MinRSABits {
+		return keys.KeyPolicy{}, fmt.Errorf("RSA key size %d is below the %d bits of the %s crypto policy", configured.RSABits, p.MinRSABits, p.Name)
+	}
+	return configured, nil
+}
+
+// Activate makes token signing and verification, the keys of clients and DPoP
+// proofs and the client secret verification follow p. Signing keys follow it
+// through KeyPolicy.
+func (p Policy) Activate() {
+	jwt.SetAlgorithms(p.Algorithms)
+	keys.SetPublicKeyPolicy(p.MinRSABits, p.Algorithms)
+	auth.RequireHashedSecrets(p.HashedClientSecrets)
+}
@@ -0,0 +1,66 @@
+package cryptopolicy
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"oauth-basic/src/keys"
+)
+
+func TestLookup(t *testing.T) {
+	if policy, err := Lookup(""); err != nil || policy.Name != "default" {
+		t.Errorf("Expected the default policy for an empty name, got %q (%v)", policy.Name, err)
+	}
+	if policy, err := Lookup("fips"); err != nil || policy.Name != "fips" {
+		t.Errorf("Expected the fips policy, got %q (%v)", policy.Name, err)
+	}
+	if _, err := Lookup("strict"); err == nil {
+		t.Error("Expected an unknown policy to be rejected")
+	}
+}
+
+func TestFIPS_KeyPolicy(t *testing.T) {
+	policy, err := FIPS.KeyPolicy(keys.KeyPolicy{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if policy.RSABits != 3072 || policy.MinStrength != 128 {
+		t.Errorf("Expected 3072 bit RSA keys with a strength of 128 bits, got %d and %d", policy.RSABits, policy.MinStrength)
+	}
+	if !slices.Equal(policy.Algorithms, FIPS.Algorithms) {
+		t.Errorf("Expected the algorithms %v, got %v", FIPS.Algorithms, policy.Algorithms)
+	}
+
+	for _, configured := range []keys.KeyPolicy{
+		{RSABits: 2048},
+		{MinStrength: 112},
+		{Algorithms: []string{"EdDSA"}},
+	} {
+		if _, err := FIPS.KeyPolicy(configured); err == nil {
+			t.Errorf("Expected %+v to be rejected by the fips policy", configured)
+		}
+	}
+}
+
+func TestFIPS_LoadKeys(t *testing.T) {
+	policy, _ := FIPS.KeyPolicy(keys.KeyPolicy{})
+	dir := t.TempDir()
+	if _, err := keys.LoadKeys(keys.Options{
+		KeyFile:     filepath.Join(dir, "ed25519.pem"),
+		Algorithm:   "EdDSA",
+		GenerateKey: true,
+		Policy:      policy,
+	}); err == nil {
+		t.Error("Expected EdDSA to be rejected by the fips policy")
+	}
+
+	// A 2048 bit key from before the policy was switched on is rejected.
+	keyFile := filepath.Join(dir, "signing.pem")
+	if _, err := keys.LoadKeys(keys.Options{KeyFile: keyFile, GenerateKey: true}); err != nil {
+		t.Fatalf("Unexpected error generating key: %v", err)
+	}
+	if _, err := keys.LoadKeys(keys.Options{KeyFile: keyFile, Policy: policy}); err == nil {
+		t.Error("Expected a 2048 bit RSA key to be rejected by the fips policy")
+	}
+}
@@ -0,0 +1,179 @@
+// Package dpop checks DPoP proofs (RFC 9449), with which clients show that
+// they hold the private key an access token is bound to. The token endpoint
+// uses it to bind tokens to the key of a proof; resource servers use it to
+// check that a bound token is presented by the client holding the key.
+package dpop
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"oauth-basic/src/jwt"
+	"oauth-basic/src/keys"
+)
+
+const (
+	// HeaderName is the request header carrying the proof.
+	HeaderName = "DPoP"
+	// ProofType is the typ header of a proof.
+	ProofType = "dpop+jwt"
+	// TokenType is the token_type of DPoP bound access tokens and the
+	// Authorization scheme they are sent with.
+	TokenType = "DPoP"
+	// DefaultMaxAge is how long after its iat a proof is accepted unless the
+	// Verifier sets MaxAge.
+	DefaultMaxAge = 5 * time.Minute
+	// clockSkew is how far in the future the iat of a proof may be.
+	clockSkew = time.Minute
+)
+
+// ErrInvalidProof is wrapped by every error about a proof, so it can be
+// reported as invalid_dpop_proof.
+var ErrInvalidProof = errors.New("invalid DPoP proof")
+
+// Verifier checks DPoP proofs and remembers their jti, so every proof is only
+// accepted once. The zero value is ready to use.
+type Verifier struct {
+	// MaxAge is how long after its iat a proof is accepted, DefaultMaxAge if 0.
+	MaxAge time.Duration
+
+	replays jwt.ReplayCache
+}
+
+// CheckProof validates the proof of a token request r to uri, the URL of the
+// token endpoint, and returns the JWK thumbprint of the key the token is to
+// be bound to. It returns "" if r carries no proof.
+func (v *Verifier) CheckProof(r *http.Request, uri string) (string, error) {
+	if len(r.Header.Values(HeaderName)) == 0 {
+		return "", nil
+	}
+	return v.check(r, uri, "")
+}
+
+// VerifyRequest is for resource servers: it checks that r presents its access
+// token as "Authorization: DPoP <token>" together with a proof for the token,
+// signed with the key of thumbprint jkt, the cnf.jkt claim of the token. uri
+// is the URL of the resource without query and fragment. It returns the token.
+func (v *Verifier) VerifyRequest(r *http.Request, uri, jkt string) (string, error) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, TokenType) || token == "" {
+		return "", fmt.Errorf("%w: the access token has to be sent with the %s scheme", ErrInvalidProof, TokenType)
+	}
+	proofJKT, err := v.check(r, uri, token)
+	if err != nil {
+		return "", err
+	}
+	if proofJKT != jkt {
+		return "", fmt.Errorf("%w: the proof is not signed with the key the token is bound to", ErrInvalidProof)
+	}
+	return token, nil
+}
+
+// check validates the proof of r (RFC 9449, section 4.3). If accessToken is
+// set, the proof has to carry its hash.
+func (v *Verifier) check(r *http.Request, uri, accessToken string) (string, error) {
+	proofs := r.Header.Values(HeaderName)
+	if len(proofs) != 1 {
+		return "", fmt.Errorf("%w: expected exactly one %s header", ErrInvalidProof, HeaderName)
+	}
+
+	var jkt string
+	claims, err := jwt.ParseProof(proofs[0], func(header map[string]interface{}, alg string) (interface{}, error) {
+		if header["typ"] != ProofType {
+			return nil, fmt.Errorf("typ header is not %s", ProofType)
+		}
+		pub, thumbprint, err := headerKey(header["jwk"], alg)
+		if err != nil {
+			return nil, err
+		}
+		jkt = thumbprint
+		return pub, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+
+	now := time.Now()
+	issuedAt := time.Unix(claims.IssuedAt, 0)
+	switch {
+	case claims.ID == "":
+		return "", fmt.Errorf("%w: missing jti", ErrInvalidProof)
+	case claims.Method != r.Method:
+		return "", fmt.Errorf("%w: htm %q does not match the request method %s", ErrInvalidProof, claims.Method, r.Method)
+	case !sameURI(claims.URI, uri):
+		return "", fmt.Errorf("%w: htu %q does not match %s", ErrInvalidProof, claims.URI, uri)
+	case claims.IssuedAt == 0 || issuedAt.Before(now.Add(-v.maxAge())) || issuedAt.After(now.Add(clockSkew)):
+		return "", fmt.Errorf("%w: iat is missing or too far from the current time", ErrInvalidProof)
+	}
+	if accessToken != "" && claims.AccessTokenHash != AccessTokenHash(accessToken) {
+		return "", fmt.Errorf("%w: ath does not match the access token", ErrInvalidProof)
+	}
+	if !v.replays.Use(jkt+" "+claims.ID, issuedAt.Add(v.maxAge()), now) {
+		return "", fmt.Errorf("%w: proof %s was already used", ErrInvalidProof, claims.ID)
+	}
+	return jkt, nil
+}
+
+func (v *Verifier) maxAge() time.Duration {
+	if v.MaxAge == 0 {
+		return DefaultMaxAge
+	}
+	return v.MaxAge
+}
+
+// headerKey returns the public key in the jwk header of a proof and its
+// RFC 7638 thumbprint. The key has to be able to sign with alg.
+func headerKey(member interface{}, alg string) (interface{}, string, error) {
+	object, ok := member.(map[string]interface{})
+	if !ok {
+		return nil, "", errors.New("missing jwk header")
+	}
+	if _, ok := object["d"]; ok {
+		return nil, "", errors.New("jwk header contains a private key")
+	}
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, "", err
+	}
+	var jwk keys.JWK
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, "", fmt.Errorf("invalid jwk header: %w", err)
+	}
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid jwk header: %w", err)
+	}
+	if err := keys.CheckAlgorithm(pub, alg); err != nil {
+		return nil, "", err
+	}
+	thumbprint, err := keys.Thumbprint(pub)
+	if err != nil {
+		return nil, "", err
+	}
+	return pub, thumbprint, nil
+}
+
+// AccessTokenHash returns the ath of a proof sent with accessToken: the
+// base64url encoded SHA-256 hash of the token.
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// sameURI compares the htu of a proof to the URL of the request, ignoring
+// query and fragment and the case of scheme and host (RFC 9449, section 4.3).
+func sameURI(htu, uri string) bool {
+	a, errA := url.Parse(htu)
+	b, errB := url.Parse(uri)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host) && a.EscapedPath() == b.EscapedPath()
+}
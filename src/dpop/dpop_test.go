@@ -0,0 +1,154 @@
+package dpop
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"oauth-basic/src/jwt"
+	"oauth-basic/src/keys"
+)
+
+const tokenURL = "https://server.example.com/token"
+
+func TestVerifier_CheckProof(t *testing.T) {
+	signer, jwk := proofKey(t)
+	v := &Verifier{}
+
+	req := proofRequest(t, signer, jwk, "POST", tokenURL, time.Now(), "")
+	jkt, err := v.CheckProof(req, tokenURL)
+	if err != nil {
+		t.Fatalf("Expected the proof to be valid, got %v", err)
+	}
+	if expected, _ := keys.Thumbprint(signer.Public()); jkt != expected {
+		t.Errorf("Expected the thumbprint %s, got %s", expected, jkt)
+	}
+
+	if _, err := v.CheckProof(req, tokenURL); !errors.Is(err, ErrInvalidProof) {
+		t.Errorf("Expected a replayed proof to be rejected, got %v", err)
+	}
+
+	if jkt, err := v.CheckProof(httptest.NewRequest("POST", tokenURL, nil), tokenURL); jkt != "" || err != nil {
+		t.Errorf("Expected no binding without a proof, got %q, %v", jkt, err)
+	}
+}
+
+func TestVerifier_CheckProofRejects(t *testing.T) {
+	signer, jwk := proofKey(t)
+	wrongMethod := proofRequest(t, signer, jwk, "GET", tokenURL, time.Now(), "")
+	wrongMethod.Method = "POST"
+	withPrivate := map[string]interface{}{"d": "AAAA"}
+	for name, value := range jwk {
+		withPrivate[name] = value
+	}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+	}{
+		{"wrong method", wrongMethod},
+		{"wrong URI", proofRequest(t, signer, jwk, "POST", "https://other.example.com/token", time.Now(), "")},
+		{"too old", proofRequest(t, signer, jwk, "POST", tokenURL, time.Now().Add(-DefaultMaxAge-time.Minute), "")},
+		{"in the future", proofRequest(t, signer, jwk, "POST", tokenURL, time.Now().Add(2*clockSkew), "")},
+		{"private key", proofRequest(t, signer, withPrivate, "POST", tokenURL, time.Now(), "")},
+		{"other key", proofRequest(t, signer, otherKey(t), "POST", tokenURL, time.Now(), "")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := (&Verifier{}).CheckProof(tt.req, tokenURL); !errors.Is(err, ErrInvalidProof) {
+				t.Errorf("Expected the proof to be rejected, got %v", err)
+			}
+		})
+	}
+
+	req := proofRequest(t, signer, jwk, "POST", tokenURL, time.Now(), "")
+	req.Header.Add(HeaderName, req.Header.Get(HeaderName))
+	if _, err := (&Verifier{}).CheckProof(req, tokenURL); !errors.Is(err, ErrInvalidProof) {
+		t.Errorf("Expected two proofs to be rejected, got %v", err)
+	}
+}
+
+func TestVerifier_VerifyRequest(t *testing.T) {
+	signer, jwk := proofKey(t)
+	jkt, _ := keys.Thumbprint(signer.Public())
+	resource := "https://resource.example.com/data"
+	v := &Verifier{}
+
+	req := proofRequest(t, signer, jwk, "GET", resource+"?page=2", time.Now(), "token")
+	req.Header.Set("Authorization", "DPoP token")
+	if token, err := v.VerifyRequest(req, resource, jkt); err != nil || token != "token" {
+		t.Errorf("Expected the request to be valid, got %q, %v", token, err)
+	}
+
+	req = proofRequest(t, signer, jwk, "GET", resource, time.Now(), "other token")
+	req.Header.Set("Authorization", "DPoP token")
+	if _, err := v.VerifyRequest(req, resource, jkt); !errors.Is(err, ErrInvalidProof) {
+		t.Errorf("Expected a proof for another token to be rejected, got %v", err)
+	}
+
+	req = proofRequest(t, signer, jwk, "GET", resource, time.Now(), "token")
+	req.Header.Set("Authorization", "DPoP token")
+	if _, err := v.VerifyRequest(req, resource, "other"); !errors.Is(err, ErrInvalidProof) {
+		t.Errorf("Expected a proof with another key to be rejected, got %v", err)
+	}
+
+	req = proofRequest(t, signer, jwk, "GET", resource, time.Now(), "token")
+	req.Header.Set("Authorization", "Bearer token")
+	if _, err := v.VerifyRequest(req, resource, jkt); !errors.Is(err, ErrInvalidProof) {
+		t.Errorf("Expected a Bearer token to be rejected, got %v", err)
+	}
+}
+
+var proofCounter int
+
+// proofRequest builds a request to uri with a proof signed by signer. If
+// accessToken is set, the proof carries its hash.
+func proofRequest(t *testing.T, signer crypto.Signer, jwk map[string]interface{}, method, uri string, issuedAt time.Time, accessToken string) *http.Request {
+	t.Helper()
+	proofCounter++
+	claims := jwt.ProofClaims{
+		ID:       "proof-" + strconv.Itoa(proofCounter),
+		Method:   method,
+		URI:      uri,
+		IssuedAt: issuedAt.Unix(),
+	}
+	if accessToken != "" {
+		claims.AccessTokenHash = AccessTokenHash(accessToken)
+	}
+	proof, err := jwt.GenerateProof(claims, map[string]interface{}{"typ": ProofType, "jwk": jwk}, signer, "ES256")
+	if err != nil {
+		t.Fatalf("Failed to generate proof: %v", err)
+	}
+	req := httptest.NewRequest(method, uri, nil)
+	req.Header.Set(HeaderName, proof)
+	return req
+}
+
+// proofKey generates an ES256 key and its public JWK.
+func proofKey(t *testing.T) (crypto.Signer, map[string]interface{}) {
+	t.Helper()
+	signer, err := keys.GenerateKey("ES256")
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	pub := signer.Public().(*ecdsa.PublicKey)
+	return signer, map[string]interface{}{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// otherKey returns the public JWK of another key than the proof is signed with.
+func otherKey(t *testing.T) map[string]interface{} {
+	t.Helper()
+	_, jwk := proofKey(t)
+	return jwk
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"oauth-basic/src/auth"
+	"oauth-basic/src/dpop"
+	"oauth-basic/src/jwt"
+	"oauth-basic/src/keys"
+	. "oauth-basic/src/utils"
+)
+
+// KeyInfo describes a signing key in the admin API.
+type KeyInfo struct {
+	Kid         string     `json:"kid"`
+	Alg         string     `json:"alg"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatedAt *time.Time `json:"activated_at,omitempty"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	// IssuedTokens counts the tokens the key signed since the server started.
+	IssuedTokens int64      `json:"issued_tokens"`
+	LastIssuedAt *time.Time `json:"last_issued_at,omitempty"`
+	// MaxTokenLifetime is the longest lifetime of a token the key signed, in seconds.
+	MaxTokenLifetime int64 `json:"max_token_lifetime"`
+	// SafeToUnpublishAt is when every token the key signed has expired. It is
+	// only set for retiring keys.
+	SafeToUnpublishAt *time.Time `json:"safe_to_unpublish_at,omitempty"`
+}
+
+// GenerateKeyRequest is the body of POST /admin/keys.
+type GenerateKeyRequest struct {
+	// Alg is the algorithm of the new key, empty for the default algorithm.
+	Alg string `json:"alg"`
+}
+
+// AdminHandler manages the signing keys of its key ring. Every request needs an
+// access token with the admin role, sent with the DPoP scheme and a proof if it
+// is DPoP bound and as Bearer token otherwise. Certificate bound tokens are
+// only accepted over mutual TLS with their certificate.
+type AdminHandler struct {
+	Keys *keys.KeyRing
+
+	// proofs checks the DPoP proofs of requests with DPoP bound tokens.
+	proofs dpop.Verifier
+}
+
+// ListKeys godoc
+// @Summary      List Signing Keys
+// @Description  Lists every signing key with its lifecycle status: pending, active, retiring or revoked.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   handlers.KeyInfo
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      403  {string}  string "Forbidden"
+// @Router       /admin/keys [get]
+func (h *AdminHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+	list := []KeyInfo{}
+	for _, k := range h.Keys.Keys() {
+		list = append(list, h.keyInfo(k))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// GenerateKey godoc
+// @Summary      Generate Signing Key
+// @Description  Generates a pending key for an algorithm that already has an active key. The key is published in the JWKS right away and signs once it is promoted, or when rotation is enabled once it is due.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      handlers.GenerateKeyRequest  false  "Algorithm of the new key"
+// @Success      201      {object}  handlers.KeyInfo
+// @Failure      400      {string}  string "Invalid request"
+// @Failure      401      {string}  string "Unauthorized"
+// @Failure      403      {string}  string "Forbidden"
+// @Failure      409      {string}  string "Key cannot be generated"
+// @Router       /admin/keys [post]
+func (h *AdminHandler) GenerateKey(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+	var req GenerateKeyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, err := h.Keys.ActiveKey(req.Alg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key, err := h.Keys.Generate(req.Alg, time.Now())
+	if err != nil {
+		Logger.Printf("Error generating signing key: %v", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusCreated, h.keyInfo(key))
+}
+
+// PromoteKey godoc
+// @Summary      Promote Signing Key
+// @Description  Makes a pending key the active key of its algorithm. The previously active key retires and stays published until its tokens have expired. A key can only be promoted once it has been published for the JWKS max-age.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        kid  path      string  true  "Key ID"
+// @Success      200  {object}  handlers.KeyInfo
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      403  {string}  string "Forbidden"
+// @Failure      404  {string}  string "Unknown key"
+// @Failure      409  {string}  string "Key is not pending or not published long enough"
+// @Router       /admin/keys/{kid}/promote [post]
+func (h *AdminHandler) PromoteKey(w http.ResponseWriter, r *http.Request) {
+	h.changeKey(w, r, h.Keys.Promote)
+}
+
+// RetireKey godoc
+// @Summary      Retire Signing Key
+// @Description  Retires a pending key. Retiring keys stay published until the tokens they signed have expired. The active key cannot be retired, promote its successor instead.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        kid  path      string  true  "Key ID"
+// @Success      200  {object}  handlers.KeyInfo
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      403  {string}  string "Forbidden"
+// @Failure      404  {string}  string "Unknown key"
+// @Failure      409  {string}  string "Key cannot be retired"
+// @Router       /admin/keys/{kid}/retire [post]
+func (h *AdminHandler) RetireKey(w http.ResponseWriter, r *http.Request) {
+	h.changeKey(w, r, h.Keys.Retire)
+}
+
+// RevokeKey godoc
+// @Summary      Revoke Signing Key
+// @Description  Removes a compromised key from the JWKS immediately, tokens it signed are no longer accepted. Revoking the active key switches issuance to a freshly generated key in the same step. Revocations are kept in the revocation file and survive restarts.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        kid  path      string  true  "Key ID"
+// @Success      200  {object}  handlers.KeyInfo
+// @Failure      401  {string}  string "Unauthorized"
+// @Failure      403  {string}  string "Forbidden"
+// @Failure      404  {string}  string "Unknown key"
+// @Failure      500  {string}  string "Key cannot be revoked"
+// @Router       /admin/keys/{kid}/revoke [post]
+func (h *AdminHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+	key, err := h.Keys.Revoke(r.PathValue("kid"), time.Now())
+	if errors.Is(err, keys.ErrUnknownKey) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		// The key is still in use, e.g. because no replacement could be generated.
+		Logger.Printf("Error revoking signing key: %v", err)
+		http.Error(w, "Error revoking key", http.StatusInternalServerError)
+		return
+	}
+	Logger.Printf("Admin %s %s: key is now %s", r.Method, r.URL.Path, key.Status)
+	writeJSON(w, http.StatusOK, h.keyInfo(key))
+}
+
+// UnpublishKey godoc
+// @Summary      Unpublish Signing Key
+// @Description  Removes a retiring key from the JWKS. This is refused while tokens signed by the key may still be valid, see safe_to_unpublish_at, unless force is set.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        kid    path      string  true   "Key ID"
+// @Param        force  query     bool    false  "Unpublish even if tokens signed by the key may still be valid"
+// @Success      200    {object}  handlers.KeyInfo
+// @Failure      401    {string}  string "Unauthorized"
+// @Failure      403    {string}  string "Forbidden"
+// @Failure      404    {string}  string "Unknown key"
+// @Failure      409    {string}  string "Key cannot be unpublished yet"
+// @Router       /admin/keys/{kid} [delete]
+func (h *AdminHandler) UnpublishKey(w http.ResponseWriter, r *http.Request) {
+	force := r.URL.Query().Get("force") == "true"
+	h.changeKey(w, r, func(kid string, now time.Time) (keys.Key, error) {
+		return h.Keys.Unpublish(kid, force, now)
+	})
+}
+
+// changeKey applies a lifecycle change to the key named in the path.
+func (h *AdminHandler) changeKey(w http.ResponseWriter, r *http.Request, change func(kid string, now time.Time) (keys.Key, error)) {
+	if !h.authorize(w, r) {
+		return
+	}
+	key, err := change(r.PathValue("kid"), time.Now())
+	if errors.Is(err, keys.ErrUnknownKey) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	Logger.Printf("Admin %s %s: key is now %s", r.Method, r.URL.Path, key.Status)
+	writeJSON(w, http.StatusOK, h.keyInfo(key))
+}
+
+// authorize checks for a valid access token with the admin role and writes
+// the error response if there is none. A DPoP bound token has to come with the
+// DPoP scheme and a proof of its key (RFC 9449, section 7), a certificate
+// bound token with its client certificate (RFC 8705, section 3).
+func (h *AdminHandler) authorize(w http.ResponseWriter, r *http.Request) bool {
+	scheme, tokenStr, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if (scheme != "Bearer" && !strings.EqualFold(scheme, dpop.TokenType)) || tokenStr == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	claims, err := jwt.ParseToken(tokenStr, h.Keys.LookupPublicKey)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", scheme+` error="invalid_token"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	var cnf jwt.Confirmation
+	if claims.Confirmation != nil {
+		cnf = *claims.Confirmation
+	}
+	if cnf.X5tS256 != "" && (r.TLS == nil || len(r.TLS.PeerCertificates) == 0 || auth.CertificateThumbprint(r.TLS.PeerCertificates[0]) != cnf.X5tS256) {
+		Logger.Printf("Admin request by %s rejected: the client certificate does not match the token", claims.Subject)
+		w.Header().Set("WWW-Authenticate", scheme+` error="invalid_token"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if cnf.JKT != "" {
+		if _, err := h.proofs.VerifyRequest(r, requestURL(r), cnf.JKT); err != nil {
+			Logger.Printf("Admin request by %s rejected: %v", claims.Subject, err)
+			w.Header().Set("WWW-Authenticate", dpop.TokenType+` error="invalid_dpop_proof"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return false
+		}
+	} else if scheme != "Bearer" {
+		// Only DPoP bound tokens are sent with the DPoP scheme.
+		w.Header().Set("WWW-Authenticate", dpop.TokenType+` error="invalid_token"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if claims.Role != jwt.RoleAdmin {
+		Logger.Printf("Admin request by %s without admin role", claims.Subject)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (h *AdminHandler) keyInfo(k keys.Key) KeyInfo {
+	return KeyInfo{
+		Kid:               k.Kid,
+		Alg:               k.Alg,
+		Status:            string(k.Status),
+		CreatedAt:         k.CreatedAt,
+		ActivatedAt:       optionalTime(k.ActivatedAt),
+		RetiredAt:         optionalTime(k.RetiredAt),
+		RevokedAt:         optionalTime(k.RevokedAt),
+		IssuedTokens:      k.IssuedTokens,
+		LastIssuedAt:      optionalTime(k.LastIssuedAt),
+		MaxTokenLifetime:  int64(k.MaxTokenLifetime.Seconds()),
+		SafeToUnpublishAt: optionalTime(h.Keys.SafeToUnpublishAt(k)),
+	}
+}
+
+// optionalTime returns nil for the zero time, so it is left out of the JSON.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
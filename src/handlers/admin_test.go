@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth-basic/src/auth"
+	"oauth-basic/src/dpop"
+	"oauth-basic/src/jwt"
+	"oauth-basic/src/keys"
+)
+
+func adminMux(ring *keys.KeyRing) *http.ServeMux {
+	admin := &AdminHandler{Keys: ring}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/keys", admin.ListKeys)
+	mux.HandleFunc("POST /admin/keys", admin.GenerateKey)
+	mux.HandleFunc("POST /admin/keys/{kid}/promote", admin.PromoteKey)
+	mux.HandleFunc("POST /admin/keys/{kid}/retire", admin.RetireKey)
+	mux.HandleFunc("POST /admin/keys/{kid}/revoke", admin.RevokeKey)
+	mux.HandleFunc("DELETE /admin/keys/{kid}", admin.UnpublishKey)
+	return mux
+}
+
+func roleToken(t *testing.T, ring *keys.KeyRing, role jwt.Role) string {
+	t.Helper()
+	return boundToken(t, ring, role, nil)
+}
+
+// boundToken returns a token with role bound to cnf.
+func boundToken(t *testing.T, ring *keys.KeyRing, role jwt.Role, cnf *jwt.Confirmation) string {
+	t.Helper()
+	key, err := ring.ActiveKey("")
+	if err != nil {
+		t.Fatalf("Failed to get active key: %v", err)
+	}
+	claims := jwt.Claims{
+		StandardClaims: jwt.StandardClaims{Subject: "operator", ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		Role:           role,
+		Confirmation:   cnf,
+	}
+	token, err := jwt.GenerateToken(claims, key.Signer, key.Alg, key.Kid)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	return token
+}
+
+func adminRequest(mux http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAdminHandler_KeyLifecycle(t *testing.T) {
+	ring := keys.InitializeKeys()
+	mux := adminMux(ring)
+	token := roleToken(t, ring, jwt.RoleAdmin)
+	old, _ := ring.ActiveKey("")
+
+	rr := adminRequest(mux, "POST", "/admin/keys", token, `{"alg": "RS256"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201 generating a key, got %d: %s", rr.Code, rr.Body)
+	}
+	var generated KeyInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &generated); err != nil {
+		t.Fatalf("Failed to decode key: %v", err)
+	}
+	if generated.Status != "pending" || generated.Alg != "RS256" {
+		t.Errorf("Expected a pending RS256 key, got %s %s", generated.Status, generated.Alg)
+	}
+	if !strings.Contains(jwksBody(t, ring), generated.Kid) {
+		t.Error("Expected the generated key to be published in the JWKS")
+	}
+
+	// Caches may hold a JWKS without the key for the max-age.
+	rr = adminRequest(mux, "POST", "/admin/keys/"+generated.Kid+"/promote", token, "")
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 promoting a freshly published key, got %d: %s", rr.Code, rr.Body)
+	}
+	if _, err := ring.Promote(generated.Kid, time.Now().Add(keys.DefaultJWKSMaxAge)); err != nil {
+		t.Fatalf("Failed to promote key: %v", err)
+	}
+	if active, _ := ring.ActiveKey(""); active.Kid != generated.Kid {
+		t.Error("Expected the promoted key to sign new tokens")
+	}
+
+	// The admin token was signed by the old key, so revoking it ends this session.
+	rr = adminRequest(mux, "POST", "/admin/keys/"+old.Kid+"/revoke", token, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 revoking the old key, got %d: %s", rr.Code, rr.Body)
+	}
+	if strings.Contains(jwksBody(t, ring), old.Kid) {
+		t.Error("Expected the revoked key to be removed from the JWKS")
+	}
+	if rr := adminRequest(mux, "GET", "/admin/keys", token, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a token of a revoked key, got %d", rr.Code)
+	}
+
+	rr = adminRequest(mux, "GET", "/admin/keys", roleToken(t, ring, jwt.RoleAdmin), "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 listing keys, got %d", rr.Code)
+	}
+	var list []KeyInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to decode key list: %v", err)
+	}
+	statuses := map[string]string{}
+	for _, k := range list {
+		statuses[k.Kid] = k.Status
+	}
+	if statuses[old.Kid] != "revoked" || statuses[generated.Kid] != "active" {
+		t.Errorf("Expected the old key revoked and the new one active, got %v", statuses)
+	}
+}
+
+func TestAdminHandler_RevokeActiveKey(t *testing.T) {
+	ring := keys.InitializeKeys()
+	mux := adminMux(ring)
+	leaked, _ := ring.ActiveKey("")
+	userToken := roleToken(t, ring, jwt.RoleUser)
+
+	rr := adminRequest(mux, "POST", "/admin/keys/"+leaked.Kid+"/revoke", roleToken(t, ring, jwt.RoleAdmin), "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 revoking the active key, got %d: %s", rr.Code, rr.Body)
+	}
+	if active, err := ring.ActiveKey(""); err != nil || active.Kid == leaked.Kid {
+		t.Error("Expected issuance to switch to a fresh key")
+	}
+	if strings.Contains(jwksBody(t, ring), leaked.Kid) {
+		t.Error("Expected the revoked key to be removed from the JWKS")
+	}
+
+	req := httptest.NewRequest("GET", "/introspect", nil)
+	req.Header.Set("Authorization", "Bearer "+userToken)
+	rr = httptest.NewRecorder()
+	(&IntrospectionHandler{Keys: ring}).ServeHTTP(rr, req)
+	var resp IntrospectionResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode introspection response: %v", err)
+	}
+	if resp.Active {
+		t.Error("Expected a token signed by the revoked key to be inactive")
+	}
+}
+
+func TestAdminHandler_UnpublishKey(t *testing.T) {
+	ring := keys.InitializeKeys()
+	mux := adminMux(ring)
+	first, _ := ring.ActiveKey("")
+
+	os.Setenv("CLIENT_ID", "testuser")
+	os.Setenv("CLIENT_SECRET", "testpassword")
+	defer os.Unsetenv("CLIENT_ID")
+	defer os.Unsetenv("CLIENT_SECRET")
+	req := tokenRequest("grant_type=client_credentials")
+	req.SetBasicAuth("testuser", "testpassword")
+	rr := httptest.NewRecorder()
+	(&TokenHandler{Keys: ring}).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 issuing a token, got %d", rr.Code)
+	}
+
+	next, err := ring.Generate("", time.Now().Add(-keys.DefaultJWKSMaxAge))
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if _, err := ring.Promote(next.Kid, time.Now()); err != nil {
+		t.Fatalf("Failed to promote key: %v", err)
+	}
+	token := roleToken(t, ring, jwt.RoleAdmin)
+
+	var list []KeyInfo
+	json.Unmarshal(adminRequest(mux, "GET", "/admin/keys", token, "").Body.Bytes(), &list)
+	var retired KeyInfo
+	for _, k := range list {
+		if k.Kid == first.Kid {
+			retired = k
+		}
+	}
+	if retired.IssuedTokens != 1 || retired.LastIssuedAt == nil || retired.MaxTokenLifetime != int64(TokenLifetime.Seconds()) {
+		t.Errorf("Expected the issued token to be tracked, got %+v", retired)
+	}
+	if retired.SafeToUnpublishAt == nil || !retired.SafeToUnpublishAt.After(time.Now()) {
+		t.Errorf("Expected the key not to be safe to unpublish yet, got %v", retired.SafeToUnpublishAt)
+	}
+
+	if rr := adminRequest(mux, "DELETE", "/admin/keys/"+first.Kid, token, ""); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 unpublishing a key with valid tokens, got %d", rr.Code)
+	}
+	if rr := adminRequest(mux, "DELETE", "/admin/keys/"+first.Kid+"?force=true", token, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 force unpublishing the key, got %d: %s", rr.Code, rr.Body)
+	}
+	if strings.Contains(jwksBody(t, ring), first.Kid) {
+		t.Error("Expected the unpublished key to be removed from the JWKS")
+	}
+}
+
+func TestAdminHandler_Errors(t *testing.T) {
+	ring := keys.InitializeKeys()
+	mux := adminMux(ring)
+	admin := roleToken(t, ring, jwt.RoleAdmin)
+
+	for name, tc := range map[string]struct {
+		method, path, token, body string
+		status                    int
+	}{
+		"missing token":     {"GET", "/admin/keys", "", "", http.StatusUnauthorized},
+		"invalid token":     {"GET", "/admin/keys", "not-a-token", "", http.StatusUnauthorized},
+		"user role":         {"GET", "/admin/keys", roleToken(t, ring, jwt.RoleUser), "", http.StatusForbidden},
+		"unknown key":       {"POST", "/admin/keys/unknown/promote", admin, "", http.StatusNotFound},
+		"unconfigured alg":  {"POST", "/admin/keys", admin, `{"alg": "ES256"}`, http.StatusBadRequest},
+		"malformed body":    {"POST", "/admin/keys", admin, `{"alg":`, http.StatusBadRequest},
+		"promote active":    {"POST", "/admin/keys/" + activeKid(t, ring) + "/promote", admin, "", http.StatusConflict},
+		"retire active key": {"POST", "/admin/keys/" + activeKid(t, ring) + "/retire", admin, "", http.StatusConflict},
+	} {
+		if rr := adminRequest(mux, tc.method, tc.path, tc.token, tc.body); rr.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", name, tc.status, rr.Code)
+		}
+	}
+}
+
+func activeKid(t *testing.T, ring *keys.KeyRing) string {
+	t.Helper()
+	key, err := ring.ActiveKey("")
+	if err != nil {
+		t.Fatalf("Failed to get active key: %v", err)
+	}
+	return key.Kid
+}
+
+func jwksBody(t *testing.T, ring *keys.KeyRing) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	(&KeysHandler{Keys: ring}).ServeHTTP(rr, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	return rr.Body.String()
+}
+
+func TestAdminHandler_DPoPBoundToken(t *testing.T) {
+	ring := keys.InitializeKeys()
+	mux := adminMux(ring)
+	key, _ := keys.GenerateKey("ES256")
+	pub := key.Public().(*ecdsa.PublicKey)
+	jwk := map[string]interface{}{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32))),
+	}
+	jkt, _ := keys.Thumbprint(pub)
+	token := boundToken(t, ring, jwt.RoleAdmin, &jwt.Confirmation{JKT: jkt})
+	proofRequest := func(id, scheme, accessToken string) *httptest.ResponseRecorder {
+		proof, err := jwt.GenerateProof(jwt.ProofClaims{
+			ID:              id,
+			Method:          "GET",
+			URI:             "http://example.com/admin/keys",
+			IssuedAt:        time.Now().Unix(),
+			AccessTokenHash: dpop.AccessTokenHash(accessToken),
+		}, map[string]interface{}{"typ": dpop.ProofType, "jwk": jwk}, key, "ES256")
+		if err != nil {
+			t.Fatalf("Failed to sign DPoP proof: %v", err)
+		}
+		req := httptest.NewRequest("GET", "/admin/keys", nil)
+		req.Header.Set("Authorization", scheme+" "+token)
+		req.Header.Set(dpop.HeaderName, proof)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := adminRequest(mux, "GET", "/admin/keys", token, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a DPoP bound token sent as Bearer token to be rejected, got %d", rr.Code)
+	}
+	if rr := proofRequest("proof-1", "Bearer", token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a DPoP bound token sent as Bearer token with a proof to be rejected, got %d", rr.Code)
+	}
+	if rr := proofRequest("proof-2", "DPoP", "other token"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a proof for another token to be rejected, got %d", rr.Code)
+	}
+	if rr := proofRequest("proof-3", "DPoP", token); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 with a DPoP proof, got %d: %s", rr.Code, rr.Body)
+	}
+
+	req := httptest.NewRequest("GET", "/admin/keys", nil)
+	req.Header.Set("Authorization", "DPoP "+roleToken(t, ring, jwt.RoleAdmin))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an unbound token sent with the DPoP scheme to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestAdminHandler_CertificateBoundToken(t *testing.T) {
+	ring := keys.InitializeKeys()
+	mux := adminMux(ring)
+	certificate := func() *x509.Certificate {
+		key, _ := keys.GenerateKey("ES256")
+		template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+		if err != nil {
+			t.Fatalf("Failed to create certificate: %v", err)
+		}
+		cert, _ := x509.ParseCertificate(der)
+		return cert
+	}
+	cert, other := certificate(), certificate()
+	token := boundToken(t, ring, jwt.RoleAdmin, &jwt.Confirmation{X5tS256: auth.CertificateThumbprint(cert)})
+	request := func(peer *x509.Certificate) int {
+		req := httptest.NewRequest("GET", "/admin/keys", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if peer != nil {
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{peer}}
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := request(nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected a certificate bound token without a client certificate to be rejected, got %d", code)
+	}
+	if code := request(other); code != http.StatusUnauthorized {
+		t.Errorf("Expected a certificate bound token with another certificate to be rejected, got %d", code)
+	}
+	if code := request(cert); code != http.StatusOK {
+		t.Errorf("Expected status 200 with the bound certificate, got %d", code)
+	}
+}
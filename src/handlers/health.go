@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+
+	"oauth-basic/src/cryptopolicy"
+)
+
+// HealthHandler reports that the server is up and which crypto policy it runs under.
+type HealthHandler struct {
+	Policy cryptopolicy.Policy
+}
+
+// HealthResponse is the body of the health endpoint.
+type HealthResponse struct {
+	Status       string              `json:"status"`
+	CryptoPolicy cryptopolicy.Policy `json:"crypto_policy"`
+}
+
+// ServeHTTP godoc
+// @Summary      Health Check
+// @Description  Reports that the server is up, together with the active crypto policy: the allowed token signing algorithms, the minimum key strength and RSA key size, and whether client secrets have to be hashed.
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  handlers.HealthResponse
+// @Router       /health [get]
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "up", CryptoPolicy: h.Policy})
+}
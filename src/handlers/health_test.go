@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"oauth-basic/src/cryptopolicy"
+)
+
+func TestHealthHandler(t *testing.T) {
+	req := httptest.NewRequest("GET", "/health", nil)
+	rr := httptest.NewRecorder()
+	(&HealthHandler{Policy: cryptopolicy.FIPS}).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("HealthHandler returned wrong status code: got %v, want %v", rr.Code, http.StatusOK)
+	}
+	var resp HealthResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Status != "up" || resp.CryptoPolicy.Name != "fips" {
+		t.Errorf("Expected status 'up' with the fips policy, got '%s' and '%s'", resp.Status, resp.CryptoPolicy.Name)
+	}
+}
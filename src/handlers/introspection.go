@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"oauth-basic/src/dpop"
 	"oauth-basic/src/jwt"
 	"oauth-basic/src/keys"
 	. "oauth-basic/src/utils"
@@ -16,9 +17,19 @@ type IntrospectionResponse struct {
 	IssuedAt  int64  `json:"iat,omitempty"`
 	ExpiresAt int64  `json:"exp,omitempty"`
 	Role      string `json:"role,omitempty"`
+	// Confirmation is the key the token is bound to, e.g. the thumbprint of a client certificate.
+	Confirmation *jwt.Confirmation `json:"cnf,omitempty"`
+	// TokenType is DPoP for tokens bound to a DPoP key, which resource servers
+	// must only accept together with a proof (RFC 9449, section 7).
+	TokenType string `json:"token_type,omitempty"`
 }
 
-// IntrospectionHandler godoc
+// IntrospectionHandler verifies tokens against the keys published by its key ring.
+type IntrospectionHandler struct {
+	Keys *keys.KeyRing
+}
+
+// ServeHTTP godoc
 // @Summary      Introspect JWT Token
 // @Description  Validates a JWT token provided as a query parameter and returns its introspection result including active status and token claims.
 // @Tags         introspection
@@ -28,7 +39,7 @@ type IntrospectionResponse struct {
 // @Router       /introspect [get]
 // @security     BearerAuth
 // @Description  Usage: Make sure you have the token available from the token endpoint and then add authorization header provided in this call. Add it like Bearer <token string from token endpoint>
-func IntrospectionHandler(w http.ResponseWriter, r *http.Request) {
+func (h *IntrospectionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
 		Logger.Println("Missing Authorization header")
@@ -44,7 +55,7 @@ func IntrospectionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims, err := jwt.ParseToken(tokenStr, keys.PublicKey)
+	claims, err := jwt.ParseToken(tokenStr, h.Keys.LookupPublicKey)
 	if err != nil {
 		response := IntrospectionResponse{Active: false}
 		w.Header().Set("Content-Type", "application/json")
@@ -58,12 +69,16 @@ func IntrospectionHandler(w http.ResponseWriter, r *http.Request) {
 	// For now, if parsing succeeded and the token is valid, we consider it active.
 
 	response := IntrospectionResponse{
-		Active:    true,
-		Issuer:    claims.Issuer,
-		Subject:   claims.Subject,
-		IssuedAt:  claims.IssuedAt,
-		ExpiresAt: claims.ExpiresAt,
-		Role:      string(claims.Role),
+		Active:       true,
+		Issuer:       claims.Issuer,
+		Subject:      claims.Subject,
+		IssuedAt:     claims.IssuedAt,
+		ExpiresAt:    claims.ExpiresAt,
+		Role:         string(claims.Role),
+		Confirmation: claims.Confirmation,
+	}
+	if claims.Confirmation != nil && claims.Confirmation.JKT != "" {
+		response.TokenType = dpop.TokenType
 	}
 
 	w.Header().Set("Content-Type", "application/json")
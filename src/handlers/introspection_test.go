@@ -12,13 +12,14 @@ import (
 )
 
 func TestIntrospectionHandler_MissingToken(t *testing.T) {
+	handler := &IntrospectionHandler{Keys: keys.InitializeKeys()}
 	req, err := http.NewRequest("GET", "/introspect", nil)
 	if err != nil {
 		t.Fatalf("Failed to create request: %v", err)
 	}
 
 	rr := httptest.NewRecorder()
-	IntrospectionHandler(rr, req)
+	handler.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusBadRequest {
 		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
@@ -26,6 +27,7 @@ func TestIntrospectionHandler_MissingToken(t *testing.T) {
 }
 
 func TestIntrospectionHandler_InvalidToken(t *testing.T) {
+	handler := &IntrospectionHandler{Keys: keys.InitializeKeys()}
 	req, err := http.NewRequest("GET", "/introspect", nil)
 	req.Header.Set("Authorization", "Bearer Invalidtoken")
 	if err != nil {
@@ -33,7 +35,7 @@ func TestIntrospectionHandler_InvalidToken(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	IntrospectionHandler(rr, req)
+	handler.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status code %d, got %d", http.StatusOK, rr.Code)
@@ -49,7 +51,8 @@ func TestIntrospectionHandler_InvalidToken(t *testing.T) {
 }
 
 func TestIntrospectionHandler_ValidToken(t *testing.T) {
-	keys.InitializeKeys()
+	ring := keys.InitializeKeys()
+	handler := &IntrospectionHandler{Keys: ring}
 
 	now := time.Now().Unix()
 	exp := time.Now().Add(time.Hour).Unix()
@@ -64,7 +67,11 @@ func TestIntrospectionHandler_ValidToken(t *testing.T) {
 	}
 
 	// Generate a valid token.
-	tokenString, err := jwt.GenerateToken(claims, keys.PrivateKey)
+	key, err := ring.ActiveKey("")
+	if err != nil {
+		t.Fatalf("Error getting signing key: %v", err)
+	}
+	tokenString, err := jwt.GenerateToken(claims, key.Signer, key.Alg, key.Kid)
 	if err != nil {
 		t.Fatalf("Error generating token: %v", err)
 	}
@@ -77,7 +84,7 @@ func TestIntrospectionHandler_ValidToken(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	IntrospectionHandler(rr, req)
+	handler.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status code %d, got %d", http.StatusOK, rr.Code)
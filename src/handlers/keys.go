@@ -1,32 +1,73 @@
 package handlers
 
 import (
-	"encoding/json"
+	"bytes"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"oauth-basic/src/keys"
 	. "oauth-basic/src/utils"
 )
 
-// KeysHandler godoc
+// KeysHandler publishes the keys of its key ring as a JWK set.
+type KeysHandler struct {
+	Keys *keys.KeyRing
+}
+
+// ServeHTTP godoc
 // @Summary      Retrieve Public Signing Keys
-// @Description  Returns the RSA public signing keys in JWK format, which can be used to verify JWT signatures.
+// @Description  Returns the public signing keys (RSA, EC and Ed25519) in JWK format, which can be used to verify JWT signatures.
+// @Description  The response carries an ETag, Last-Modified and a Cache-Control max-age short enough that caches pick up a pre-published key before it signs. Conditional requests with If-None-Match or If-Modified-Since are answered with 304.
 // @Tags         keys
 // @Produce      json
+// @Param        If-None-Match  header  string  false  "ETag of a cached copy"
 // @Success      200  {object}  map[string]interface{}
-// @Failure      500  {string}  string "Error getting keys" or "Error encoding keys"
+// @Success      304  {string}  string "Not modified"
+// @Failure      500  {string}  string "Error getting keys"
 // @Router       /.well-known/jwks.json [get]
-func KeysHandler(w http.ResponseWriter, r *http.Request) {
-	jwk, err := keys.GetJWK()
+func (h *KeysHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	jwks, err := h.Keys.JWKS()
 	if err != nil {
 		Logger.Printf("Error getting JWK: %v", err)
 		http.Error(w, "Error getting keys", http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(jwk); err != nil {
-		Logger.Printf("Error encoding JWK: %v", err)
-		http.Error(w, "Error encoding keys", http.StatusInternalServerError)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwks.MaxAge.Seconds())))
+	w.Header().Set("ETag", jwks.ETag)
+	// ServeContent answers If-None-Match and If-Modified-Since with 304.
+	http.ServeContent(w, r, "", jwks.LastModified, bytes.NewReader(jwks.Body))
+}
+
+// PEMHandler publishes the keys of its key ring as PEM, for consumers that
+// cannot read JWKs.
+type PEMHandler struct {
+	Keys *keys.KeyRing
+}
+
+// ServeHTTP godoc
+// @Summary      Retrieve Public Signing Keys as PEM
+// @Description  Returns the published public signing keys as PEM encoded SubjectPublicKeyInfo blocks, each preceded by its kid and alg. With kid, only that key is returned.
+// @Tags         keys
+// @Produce      application/x-pem-file
+// @Param        kid  query     string  false  "Key ID"
+// @Success      200  {string}  string "PEM encoded public keys"
+// @Failure      404  {string}  string "Unknown key"
+// @Failure      500  {string}  string "Error getting keys"
+// @Router       /keys.pem [get]
+func (h *PEMHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	kid := r.URL.Query().Get("kid")
+	data, err := h.Keys.ExportPublicKeyPEM(kid)
+	if kid != "" && (errors.Is(err, keys.ErrUnknownKey) || errors.Is(err, keys.ErrKeyRevoked)) {
+		http.Error(w, "Unknown key", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		Logger.Printf("Error exporting PEM keys: %v", err)
+		http.Error(w, "Error getting keys", http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(data)
 }
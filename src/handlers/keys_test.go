@@ -4,13 +4,15 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"oauth-basic/src/keys"
 )
 
 func TestKeysHandler_valid(t *testing.T) {
-	keys.InitializeKeys()
+	ring := keys.InitializeKeys()
 	req, err := http.NewRequest("GET", "/keys", nil)
 	if err != nil {
 		t.Fatal(err)
@@ -18,7 +20,7 @@ func TestKeysHandler_valid(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	KeysHandler(rr, req)
+	(&KeysHandler{Keys: ring}).ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("KeysHandler returned wrong status code: got %v, want %v", rr.Code, http.StatusOK)
@@ -43,10 +45,6 @@ func TestKeysHandler_valid(t *testing.T) {
 }
 
 func TestKeysHandler_error(t *testing.T) {
-	keys.InitializeKeys()
-
-	keys.PublicKey = nil
-
 	req, err := http.NewRequest("GET", "/keys", nil)
 	if err != nil {
 		t.Fatal(err)
@@ -54,9 +52,98 @@ func TestKeysHandler_error(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	KeysHandler(rr, req)
+	// A handler without key ring cannot publish any keys.
+	(&KeysHandler{}).ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusInternalServerError {
 		t.Errorf("KeysHandler returned wrong status code: got %v, want %v", rr.Code, http.StatusInternalServerError)
 	}
 }
+
+func TestKeysHandler_Caching(t *testing.T) {
+	ring := keys.InitializeKeys()
+	handler := &KeysHandler{Keys: ring}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	etag := rr.Header().Get("ETag")
+	if etag == "" || !strings.HasPrefix(etag, `"`) {
+		t.Errorf("Expected a strong ETag, got %q", etag)
+	}
+	if got := rr.Header().Get("Cache-Control"); got != "public, max-age=300" {
+		t.Errorf("Expected Cache-Control 'public, max-age=300', got %q", got)
+	}
+	lastModified := rr.Header().Get("Last-Modified")
+	if lastModified == "" {
+		t.Error("Expected a Last-Modified header")
+	}
+
+	req := httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("Expected status 304 for a matching ETag, got %d", rr.Code)
+	}
+	if rr.Body.Len() != 0 {
+		t.Error("Expected no body with 304")
+	}
+
+	req = httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+	req.Header.Set("If-Modified-Since", lastModified)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("Expected status 304 for an unmodified JWKS, got %d", rr.Code)
+	}
+
+	if _, err := ring.Generate("", time.Now()); err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	req = httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 after the keys changed, got %d", rr.Code)
+	}
+	if rr.Header().Get("ETag") == etag {
+		t.Error("Expected the ETag to change with the published keys")
+	}
+}
+
+func TestPEMHandler(t *testing.T) {
+	ring := keys.InitializeKeys()
+	active, _ := ring.ActiveKey("")
+	handler := &PEMHandler{Keys: ring}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/keys.pem", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "kid: "+active.Kid) || !strings.Contains(rr.Body.String(), "-----BEGIN PUBLIC KEY-----") {
+		t.Errorf("Expected the published key with its kid, got %s", rr.Body)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/keys.pem?kid="+active.Kid, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for a kid, got %d", rr.Code)
+	}
+	if !strings.HasPrefix(rr.Body.String(), "-----BEGIN PUBLIC KEY-----") {
+		t.Errorf("Expected a single PEM block, got %s", rr.Body)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/keys.pem?kid=unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown kid, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	(&PEMHandler{}).ServeHTTP(rr, httptest.NewRequest("GET", "/keys.pem", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500 without key ring, got %d", rr.Code)
+	}
+}
@@ -1,15 +1,36 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
+	"mime"
 	"net/http"
 	"oauth-basic/src/auth"
+	"oauth-basic/src/dpop"
 	"oauth-basic/src/jwt"
 	"oauth-basic/src/keys"
 	. "oauth-basic/src/utils"
 	"time"
 )
 
+// TokenLifetime is how long issued access tokens are valid.
+const TokenLifetime = time.Hour
+
+// Issuer is the iss claim of issued access tokens.
+const Issuer = "oauth2-server"
+
+// GrantTypeClientCredentials is the only grant type the token endpoint supports (RFC 6749 section 4.4).
+const GrantTypeClientCredentials = "client_credentials"
+
+// Error codes of the token endpoint (RFC 6749 section 5.2).
+const (
+	errInvalidRequest       = "invalid_request"
+	errInvalidClient        = "invalid_client"
+	errUnsupportedGrantType = "unsupported_grant_type"
+	errServerError          = "server_error"
+	// errInvalidDPoPProof is defined by RFC 9449, section 5.
+	errInvalidDPoPProof = "invalid_dpop_proof"
+)
+
 // TokenResponse represents the JSON response returned by the /token endpoint.
 type TokenResponse struct {
 	AccessToken string `json:"access_token"`
@@ -17,57 +38,186 @@ type TokenResponse struct {
 	ExpiresIn   int    `json:"expires_in"`
 }
 
-// TokenHandler godoc
+// TokenErrorResponse is the JSON body of a failed token request.
+type TokenErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// TokenHandler issues access tokens signed with the keys of its key ring.
+type TokenHandler struct {
+	Keys *keys.KeyRing
+	// URL is the public URL of the token endpoint, which client assertions are
+	// addressed to. Empty means it is derived from the request.
+	URL string
+
+	// proofs checks the DPoP proofs of token requests.
+	proofs dpop.Verifier
+}
+
+// ServeHTTP godoc
 // @Summary      Generate JWT Token
-// @Description  Validates client credentials and returns a JWT token. Use Basic Auth with 'testuser' and 'testpassword' as credentials.
-// @Description  Use Basic Auth with 'testuser' and 'testpassword' as credentials.
+// @Description  Issues an access token with the client credentials grant (RFC 6749 section 4.4). Authenticate the client with Basic Auth, e.g. 'testuser' and 'testpassword', with client_id and client_secret in the form body if the client may use client_secret_post, or with a client_assertion (RFC 7523) if it may use private_key_jwt, or with its TLS client certificate and client_id (RFC 8705), and send grant_type=client_credentials as form body.
+// @Description  Tokens requested with a TLS client certificate carry its thumbprint as cnf claim.
+// @Description  Tokens requested with a DPoP proof (RFC 9449) are bound to its key with cnf.jkt and have the token_type DPoP.
+// @Description  Errors are returned as JSON with error and error_description (RFC 6749 section 5.2).
 // @Tags         token
-// @Accept       json
+// @Accept       x-www-form-urlencoded
 // @Produce      json
 // @Security     BasicAuth
+// @Param        DPoP                   header    string  false  "DPoP proof binding the token to the client's key"
+// @Param        grant_type             formData  string  true   "Grant type"  Enums(client_credentials)
+// @Param        client_id              formData  string  false  "Client id for client_secret_post and TLS client authentication"
+// @Param        client_secret          formData  string  false  "Client secret for client_secret_post"
+// @Param        client_assertion_type  formData  string  false  "Client assertion type for private_key_jwt"  Enums(urn:ietf:params:oauth:client-assertion-type:jwt-bearer)
+// @Param        client_assertion       formData  string  false  "JWT signed by the client for private_key_jwt"
 // @Success      200  {object}  handlers.TokenResponse
-// @Failure      401  {string}  string "Unauthorized"
-// @Failure      500  {string}  string "Error generating token"
-// @Router       /token [get]
-func TokenHandler(w http.ResponseWriter, r *http.Request) {
-	clientID, ok := auth.ValidateBasicAuth(r)
-
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+// @Failure      400  {object}  handlers.TokenErrorResponse "invalid_request, e.g. for two authentication methods, unsupported_grant_type or invalid_dpop_proof"
+// @Failure      401  {object}  handlers.TokenErrorResponse "invalid_client"
+// @Failure      405  {object}  handlers.TokenErrorResponse "invalid_request"
+// @Failure      500  {object}  handlers.TokenErrorResponse "server_error"
+// @Router       /token [post]
+func (h *TokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeTokenError(w, http.StatusMethodNotAllowed, errInvalidRequest, "The token endpoint only accepts POST")
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/x-www-form-urlencoded" {
+		writeTokenError(w, http.StatusBadRequest, errInvalidRequest, "The request body has to be application/x-www-form-urlencoded")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, http.StatusBadRequest, errInvalidRequest, "The request body is malformed")
+		return
+	}
+	// Parameters must not be included more than once (RFC 6749 section 3.2).
+	for name, values := range r.PostForm {
+		if len(values) > 1 {
+			writeTokenError(w, http.StatusBadRequest, errInvalidRequest, "The parameter "+name+" is repeated")
+			return
+		}
+	}
+	grantType := r.PostForm.Get("grant_type")
+	if grantType == "" {
+		writeTokenError(w, http.StatusBadRequest, errInvalidRequest, "The grant_type parameter is missing")
+		return
+	}
+
+	client, err := auth.AuthenticateClient(r)
+	if errors.Is(err, auth.ErrMultipleAuthMethods) {
+		writeTokenError(w, http.StatusBadRequest, errInvalidRequest, "The client has to authenticate with exactly one method")
+		return
+	}
+	if err != nil {
+		Logger.Printf("Token request rejected: %v", err)
+		writeInvalidClient(w)
+		return
+	}
+	clientID := client.ID
+
+	if grantType != GrantTypeClientCredentials {
+		writeTokenError(w, http.StatusBadRequest, errUnsupportedGrantType, "Only the client_credentials grant type is supported")
+		return
+	}
+
+	jkt, err := h.proofs.CheckProof(r, h.endpointURL(r))
+	if err != nil {
+		Logger.Printf("Token request of client %s rejected: %v", clientID, err)
+		writeTokenError(w, http.StatusBadRequest, errInvalidDPoPProof, "The DPoP proof is invalid")
 		return
 	}
 
 	now := time.Now().Unix()
-	exp := time.Now().Add(time.Hour).Unix()
+	exp := time.Now().Add(TokenLifetime).Unix()
 
 	claims := jwt.Claims{
 		StandardClaims: jwt.StandardClaims{
-			Issuer:    "oauth2-server",
+			Issuer:    Issuer,
 			Subject:   clientID,
 			IssuedAt:  now,
 			ExpiresAt: exp,
 		},
-		Role: jwt.RoleUser,
+		Role: client.TokenRole(),
+	}
+	// Tokens requested over mutual TLS are bound to the client certificate (RFC 8705, section 3).
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		claims.Confirmation = &jwt.Confirmation{X5tS256: auth.CertificateThumbprint(r.TLS.PeerCertificates[0])}
+	}
+	// Tokens requested with a DPoP proof are bound to its key (RFC 9449, section 6).
+	tokenType := "Bearer"
+	if jkt != "" {
+		if claims.Confirmation == nil {
+			claims.Confirmation = &jwt.Confirmation{}
+		}
+		claims.Confirmation.JKT = jkt
+		tokenType = dpop.TokenType
 	}
 
 	if err := claims.ValidateRole(); err != nil {
 		Logger.Printf("Invalid claims: %v", err)
-		http.Error(w, "Invalid token claims", http.StatusInternalServerError)
+		writeTokenError(w, http.StatusInternalServerError, errServerError, "Invalid token claims")
+		return
+	}
+
+	// Sign with the algorithm the client asked for, so clients can migrate one by one.
+	key, err := h.Keys.ActiveKey(client.TokenSigningAlg)
+	if err != nil {
+		Logger.Printf("Error getting signing key for client %s: %v", clientID, err)
+		writeTokenError(w, http.StatusInternalServerError, errServerError, "Error generating token")
 		return
 	}
 
-	tokenString, err := jwt.GenerateToken(claims, keys.PrivateKey)
+	tokenString, err := jwt.GenerateToken(claims, key.Signer, key.Alg, key.Kid)
 	if err != nil {
-		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		Logger.Printf("Error generating token for client %s: %v", clientID, err)
+		writeTokenError(w, http.StatusInternalServerError, errServerError, "Error generating token")
 		return
 	}
+	// Keeps the key published until this token has expired.
+	h.Keys.RecordIssued(key.Kid, time.Unix(now, 0), TokenLifetime)
 
 	response := TokenResponse{
 		AccessToken: tokenString,
-		TokenType:   "Bearer",
-		ExpiresIn:   3600,
+		TokenType:   tokenType,
+		ExpiresIn:   int(TokenLifetime.Seconds()),
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	noStore(w)
+	writeJSON(w, http.StatusOK, response)
+}
+
+// endpointURL returns the URL of the token endpoint as clients see it.
+func (h *TokenHandler) endpointURL(r *http.Request) string {
+	if h.URL != "" {
+		return h.URL
+	}
+	return requestURL(r)
+}
+
+// requestURL returns the URL r was sent to, without query and fragment.
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.Path
+}
+
+// writeInvalidClient rejects a request whose client could not be authenticated.
+// The challenge tells clients to retry with Basic Auth (RFC 6749 section 5.2).
+func writeInvalidClient(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="oauth2-server"`)
+	writeTokenError(w, http.StatusUnauthorized, errInvalidClient, "Client authentication failed")
+}
+
+func writeTokenError(w http.ResponseWriter, status int, code, description string) {
+	noStore(w)
+	writeJSON(w, status, TokenErrorResponse{Error: code, ErrorDescription: description})
+}
+
+// noStore keeps token responses out of caches (RFC 6749 section 5.1).
+func noStore(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
 }
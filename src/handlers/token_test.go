@@ -1,27 +1,35 @@
 package handlers
 
 import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"oauth-basic/src/auth"
+	"oauth-basic/src/dpop"
+	"oauth-basic/src/jwt"
 	"oauth-basic/src/keys"
 )
 
 // checks if the function returns a valid jwt token when provided with valid credentials.
 func TestTokenHandler_ValidCredentials(t *testing.T) {
 
-	keys.InitializeKeys()
+	ring := keys.InitializeKeys()
 
-	req, err := http.NewRequest("GET", "/token", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
+	req := tokenRequest("grant_type=client_credentials")
 
 	os.Setenv("CLIENT_ID", "testuser")
 	os.Setenv("CLIENT_SECRET", "testpassword")
@@ -33,7 +41,7 @@ func TestTokenHandler_ValidCredentials(t *testing.T) {
 	req.Header.Set("Authorization", "Basic "+encodedCred)
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(TokenHandler)
+	handler := &TokenHandler{Keys: ring}
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -59,44 +67,357 @@ func TestTokenHandler_ValidCredentials(t *testing.T) {
 	if resp.ExpiresIn != 3600 {
 		t.Errorf("Expected expires_in of 3600, got %d", resp.ExpiresIn)
 	}
+	if cache := rr.Header().Get("Cache-Control"); cache != "no-store" {
+		t.Errorf("Expected Cache-Control no-store, got '%s'", cache)
+	}
 }
 
 // checks if invalid Basic Auth returns 401 Unauthorized.
 func TestTokenHandler_InvalidCredentials(t *testing.T) {
-	keys.InitializeKeys()
+	ring := keys.InitializeKeys()
 
-	req, err := http.NewRequest("GET", "/token", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
+	req := tokenRequest("grant_type=client_credentials")
 
 	cred := "wrong:credentials"
 	encodedCred := base64.StdEncoding.EncodeToString([]byte(cred))
 	req.Header.Set("Authorization", "Basic "+encodedCred)
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(TokenHandler)
+	handler := &TokenHandler{Keys: ring}
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusUnauthorized {
 		t.Errorf("TokenHandler returned wrong status code: got %v, want %v", status, http.StatusUnauthorized)
 	}
+	if challenge := rr.Header().Get("WWW-Authenticate"); !strings.HasPrefix(challenge, "Basic ") {
+		t.Errorf("Expected a Basic WWW-Authenticate challenge, got '%s'", challenge)
+	}
+	if resp := tokenError(t, rr); resp.Error != "invalid_client" {
+		t.Errorf("Expected error invalid_client, got '%s'", resp.Error)
+	}
 }
 
 // checks behavior when no Authorization header is provided.
 func TestTokenHandler_NoCredentials(t *testing.T) {
-	keys.InitializeKeys()
+	ring := keys.InitializeKeys()
 
-	req, err := http.NewRequest("GET", "/token", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
+	req := tokenRequest("grant_type=client_credentials")
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(TokenHandler)
+	handler := &TokenHandler{Keys: ring}
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusUnauthorized {
 		t.Errorf("TokenHandler returned wrong status code for no credentials: got %v, want %v", status, http.StatusUnauthorized)
 	}
 }
+
+// checks that a client with a token_signing_alg gets tokens signed with that algorithm.
+func TestTokenHandler_ClientSigningAlgorithm(t *testing.T) {
+	ring := keys.InitializeKeys()
+	ecKey, err := keys.GenerateKey("ES256")
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	if err := ring.AddKey(ecKey, "ES256", time.Now()); err != nil {
+		t.Fatalf("Failed to add ES256 key: %v", err)
+	}
+
+	os.Setenv("CLIENT_ID", "testuser")
+	os.Setenv("CLIENT_SECRET", "testpassword")
+	os.Setenv("CLIENT_TOKEN_SIGNING_ALG", "ES256")
+	defer os.Unsetenv("CLIENT_ID")
+	defer os.Unsetenv("CLIENT_SECRET")
+	defer os.Unsetenv("CLIENT_TOKEN_SIGNING_ALG")
+
+	req := tokenRequest("grant_type=client_credentials")
+	req.SetBasicAuth("testuser", "testpassword")
+
+	rr := httptest.NewRecorder()
+	(&TokenHandler{Keys: ring}).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("TokenHandler returned wrong status code: got %v, want %v", status, http.StatusOK)
+	}
+
+	var resp TokenResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal JSON response: %v", err)
+	}
+	header := tokenHeader(t, resp.AccessToken)
+	active, err := ring.ActiveKey("ES256")
+	if err != nil {
+		t.Fatalf("Expected an active ES256 key: %v", err)
+	}
+	if header["alg"] != "ES256" {
+		t.Errorf("Expected token signed with ES256, got %v", header["alg"])
+	}
+	if header["kid"] != active.Kid {
+		t.Errorf("Expected kid %s, got %v", active.Kid, header["kid"])
+	}
+}
+
+// checks that malformed token requests get RFC 6749 JSON errors.
+func TestTokenHandler_InvalidRequests(t *testing.T) {
+	ring := keys.InitializeKeys()
+	os.Setenv("CLIENT_ID", "testuser")
+	os.Setenv("CLIENT_SECRET", "testpassword")
+	defer os.Unsetenv("CLIENT_ID")
+	defer os.Unsetenv("CLIENT_SECRET")
+
+	get := httptest.NewRequest("GET", "/token", nil)
+	jsonBody := tokenRequest("grant_type=client_credentials")
+	jsonBody.Header.Set("Content-Type", "application/json")
+	for _, tc := range []struct {
+		name   string
+		req    *http.Request
+		status int
+		error  string
+	}{
+		{"GET request", get, http.StatusMethodNotAllowed, "invalid_request"},
+		{"JSON body", jsonBody, http.StatusBadRequest, "invalid_request"},
+		{"missing grant_type", tokenRequest(""), http.StatusBadRequest, "invalid_request"},
+		{"repeated grant_type", tokenRequest("grant_type=client_credentials&grant_type=client_credentials"), http.StatusBadRequest, "invalid_request"},
+		{"password grant", tokenRequest("grant_type=password&username=u&password=p"), http.StatusBadRequest, "unsupported_grant_type"},
+	} {
+		tc.req.SetBasicAuth("testuser", "testpassword")
+		rr := httptest.NewRecorder()
+		(&TokenHandler{Keys: ring}).ServeHTTP(rr, tc.req)
+		if rr.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.name, tc.status, rr.Code)
+		}
+		if resp := tokenError(t, rr); resp.Error != tc.error {
+			t.Errorf("%s: expected error %s, got '%s'", tc.name, tc.error, resp.Error)
+		}
+		if cache := rr.Header().Get("Cache-Control"); cache != "no-store" {
+			t.Errorf("%s: expected Cache-Control no-store, got '%s'", tc.name, cache)
+		}
+		if tc.req == get && rr.Header().Get("Allow") != "POST" {
+			t.Errorf("%s: expected Allow POST, got '%s'", tc.name, rr.Header().Get("Allow"))
+		}
+	}
+}
+
+// checks client_secret_post and that a request may only use one authentication method.
+func TestTokenHandler_ClientSecretPost(t *testing.T) {
+	ring := keys.InitializeKeys()
+	os.Setenv("CLIENT_ID", "testuser")
+	os.Setenv("CLIENT_SECRET", "testpassword")
+	os.Setenv("CLIENT_AUTH_METHODS", "client_secret_basic,client_secret_post")
+	defer os.Unsetenv("CLIENT_ID")
+	defer os.Unsetenv("CLIENT_SECRET")
+	defer os.Unsetenv("CLIENT_AUTH_METHODS")
+
+	form := "grant_type=client_credentials&client_id=testuser&client_secret=testpassword"
+	rr := httptest.NewRecorder()
+	(&TokenHandler{Keys: ring}).ServeHTTP(rr, tokenRequest(form))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for client_secret_post, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req := tokenRequest(form)
+	req.SetBasicAuth("testuser", "testpassword")
+	rr = httptest.NewRecorder()
+	(&TokenHandler{Keys: ring}).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for two authentication methods, got %d", rr.Code)
+	}
+	if resp := tokenError(t, rr); resp.Error != "invalid_request" {
+		t.Errorf("Expected error invalid_request, got '%s'", resp.Error)
+	}
+}
+
+// checks that a client authenticates with a client assertion addressed to the token endpoint.
+func TestTokenHandler_PrivateKeyJWT(t *testing.T) {
+	ring := keys.InitializeKeys()
+	key, err := keys.GenerateKey("ES256")
+	if err != nil {
+		t.Fatalf("Failed to generate client key: %v", err)
+	}
+	pub := key.Public().(*ecdsa.PublicKey)
+	set, _ := json.Marshal(auth.JWKSet{Keys: []keys.JWK{{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32))),
+	}}})
+	const endpoint = "https://as.example.com/token"
+	auth.SetTokenEndpoint(endpoint)
+	defer auth.SetTokenEndpoint("")
+	clientsFile := filepath.Join(t.TempDir(), "clients.json")
+	os.WriteFile(clientsFile, []byte(`[{"client_id": "keyed", "token_endpoint_auth_methods": ["private_key_jwt"], "jwks": `+string(set)+`}]`), 0o600)
+	if err := auth.LoadClients(clientsFile); err != nil {
+		t.Fatalf("Failed to load clients: %v", err)
+	}
+	defer func() {
+		os.WriteFile(clientsFile, []byte(`[]`), 0o600)
+		auth.LoadClients(clientsFile)
+	}()
+
+	for _, tc := range []struct {
+		audience string
+		host     string
+		status   int
+	}{
+		{endpoint, "", http.StatusOK},
+		{Issuer, "", http.StatusUnauthorized},
+		{"http://example.com/token", "", http.StatusUnauthorized},
+		{"https://attacker.example.com/token", "attacker.example.com", http.StatusUnauthorized},
+	} {
+		assertion, err := jwt.GenerateAssertion(jwt.AssertionClaims{
+			Issuer:    "keyed",
+			Subject:   "keyed",
+			Audience:  jwt.Audience{tc.audience},
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			ID:        tc.audience,
+		}, key, "ES256", "")
+		if err != nil {
+			t.Fatalf("Failed to sign client assertion: %v", err)
+		}
+		form := url.Values{
+			"grant_type":            {"client_credentials"},
+			"client_assertion_type": {auth.ClientAssertionTypeJWTBearer},
+			"client_assertion":      {assertion},
+		}
+		req := tokenRequest(form.Encode())
+		if tc.host != "" {
+			req.Host = tc.host
+		}
+		rr := httptest.NewRecorder()
+		(&TokenHandler{Keys: ring}).ServeHTTP(rr, req)
+		if rr.Code != tc.status {
+			t.Errorf("Audience %s: expected status %d, got %d: %s", tc.audience, tc.status, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+// checks that tokens requested with a client certificate are bound to it and introspection shows the binding.
+func TestTokenHandler_CertificateBound(t *testing.T) {
+	ring := keys.InitializeKeys()
+	os.Setenv("CLIENT_ID", "testuser")
+	os.Setenv("CLIENT_SECRET", "testpassword")
+	defer os.Unsetenv("CLIENT_ID")
+	defer os.Unsetenv("CLIENT_SECRET")
+
+	key, _ := keys.GenerateKey("ES256")
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	req := tokenRequest("grant_type=client_credentials")
+	req.SetBasicAuth("testuser", "testpassword")
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	rr := httptest.NewRecorder()
+	(&TokenHandler{Keys: ring}).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp TokenResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+
+	req = httptest.NewRequest("GET", "/introspect", nil)
+	req.Header.Set("Authorization", "Bearer "+resp.AccessToken)
+	rr = httptest.NewRecorder()
+	(&IntrospectionHandler{Keys: ring}).ServeHTTP(rr, req)
+	var introspection IntrospectionResponse
+	json.Unmarshal(rr.Body.Bytes(), &introspection)
+	if !introspection.Active || introspection.Confirmation == nil || introspection.Confirmation.X5tS256 != auth.CertificateThumbprint(cert) {
+		t.Errorf("Expected the token to be bound to the client certificate, got %s", rr.Body.String())
+	}
+}
+
+func TestTokenHandler_DPoP(t *testing.T) {
+	ring := keys.InitializeKeys()
+	os.Setenv("CLIENT_ID", "testuser")
+	os.Setenv("CLIENT_SECRET", "testpassword")
+	defer os.Unsetenv("CLIENT_ID")
+	defer os.Unsetenv("CLIENT_SECRET")
+
+	key, _ := keys.GenerateKey("ES256")
+	pub := key.Public().(*ecdsa.PublicKey)
+	jwk := map[string]interface{}{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32))),
+	}
+	proof, err := jwt.GenerateProof(jwt.ProofClaims{
+		ID:       "proof-1",
+		Method:   "POST",
+		URI:      "http://example.com/token",
+		IssuedAt: time.Now().Unix(),
+	}, map[string]interface{}{"typ": dpop.ProofType, "jwk": jwk}, key, "ES256")
+	if err != nil {
+		t.Fatalf("Failed to sign DPoP proof: %v", err)
+	}
+
+	handler := &TokenHandler{Keys: ring}
+	req := tokenRequest("grant_type=client_credentials")
+	req.SetBasicAuth("testuser", "testpassword")
+	req.Header.Set(dpop.HeaderName, proof)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp TokenResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.TokenType != "DPoP" {
+		t.Errorf("Expected token_type DPoP, got %s", resp.TokenType)
+	}
+
+	req = httptest.NewRequest("GET", "/introspect", nil)
+	req.Header.Set("Authorization", "Bearer "+resp.AccessToken)
+	rr = httptest.NewRecorder()
+	(&IntrospectionHandler{Keys: ring}).ServeHTTP(rr, req)
+	var introspection IntrospectionResponse
+	json.Unmarshal(rr.Body.Bytes(), &introspection)
+	jkt, _ := keys.Thumbprint(pub)
+	if introspection.Confirmation == nil || introspection.Confirmation.JKT != jkt || introspection.TokenType != "DPoP" {
+		t.Errorf("Expected the token to be bound to the DPoP key, got %s", rr.Body.String())
+	}
+
+	// The same proof must not be used twice.
+	req = tokenRequest("grant_type=client_credentials")
+	req.SetBasicAuth("testuser", "testpassword")
+	req.Header.Set(dpop.HeaderName, proof)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || tokenError(t, rr).Error != "invalid_dpop_proof" {
+		t.Errorf("Expected a replayed proof to be rejected with invalid_dpop_proof, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// tokenRequest builds a client credentials request to the token endpoint with form as body.
+func tokenRequest(form string) *http.Request {
+	req := httptest.NewRequest("POST", "/token", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+// tokenError decodes the JSON error of a failed token request.
+func tokenError(t *testing.T, rr *httptest.ResponseRecorder) TokenErrorResponse {
+	t.Helper()
+	var resp TokenErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal JSON error: %v\nResponse body: %s", err, rr.Body.String())
+	}
+	return resp
+}
+
+// tokenHeader decodes the JOSE header of a JWT.
+func tokenHeader(t *testing.T, token string) map[string]interface{} {
+	t.Helper()
+	encoded, _, _ := strings.Cut(token, ".")
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode token header: %v", err)
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(data, &header); err != nil {
+		t.Fatalf("Failed to unmarshal token header: %v", err)
+	}
+	return header
+}
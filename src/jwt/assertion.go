@@ -0,0 +1,113 @@
+package jwt
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+// Audience is the aud claim, a single string or an array of strings (RFC 7519, section 4.1.3).
+type Audience []string
+
+// UnmarshalJSON accepts both forms of the aud claim.
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("aud claim must be a string or an array of strings")
+	}
+	*a = list
+	return nil
+}
+
+// ContainsAny reports whether the audience names any of audiences.
+func (a Audience) ContainsAny(audiences ...string) bool {
+	for _, aud := range audiences {
+		if slices.Contains(a, aud) {
+			return true
+		}
+	}
+	return false
+}
+
+// AssertionClaims are the claims of a JWT signed by a client rather than by
+// the server, e.g. a client assertion (RFC 7523, section 3).
+type AssertionClaims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Valid checks the time claims. Unlike for access tokens, exp is required.
+func (c AssertionClaims) Valid() error {
+	now := time.Now().Unix()
+	if c.ExpiresAt == 0 {
+		return errors.New("missing exp claim")
+	}
+	if now >= c.ExpiresAt {
+		return errors.New("assertion is expired")
+	}
+	if c.NotBefore != 0 && now < c.NotBefore {
+		return errors.New("assertion is not valid yet")
+	}
+	return nil
+}
+
+// AssertionKeyLookup returns the key to verify a client signed JWT with, given
+// its claims, which are not verified yet, and the kid, which may be empty, and
+// alg from its header. It has to reject algorithms the key is not meant for.
+type AssertionKeyLookup func(claims *AssertionClaims, kid, alg string) (key interface{}, err error)
+
+// GenerateAssertion signs claims with signer using the JWS algorithm alg, as a
+// client would. kid is left out of the header if empty.
+func GenerateAssertion(claims AssertionClaims, signer crypto.Signer, alg, kid string) (string, error) {
+	header := map[string]interface{}{}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	return signToken(claims, header, signer, alg)
+}
+
+// ParseAssertion verifies a JWT signed by a client with the key lookup returns
+// and checks its time claims. The other claims are left to the caller.
+func ParseAssertion(tokenString string, lookup AssertionKeyLookup) (*AssertionClaims, error) {
+	token, err := jwtgo.ParseWithClaims(tokenString, &AssertionClaims{}, func(token *jwtgo.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwtgo.SigningMethodRSA, *jwtgo.SigningMethodRSAPSS, *jwtgo.SigningMethodECDSA, *SigningMethodEd25519:
+		default:
+			return nil, errors.New("unexpected signing method")
+		}
+		if err := checkAlgorithm(token.Method.Alg()); err != nil {
+			return nil, err
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, err := lookup(token.Claims.(*AssertionClaims), kid, token.Method.Alg())
+		if err != nil {
+			return nil, err
+		}
+		if err := checkPSS(token, key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*AssertionClaims); ok && token.Valid {
+		return claims, nil
+	}
+	return nil, errors.New("invalid assertion")
+}
@@ -0,0 +1,63 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAudience_UnmarshalJSON(t *testing.T) {
+	var claims AssertionClaims
+	if err := json.Unmarshal([]byte(`{"aud": "https://as.example.com/token"}`), &claims); err != nil || !claims.Audience.ContainsAny("https://as.example.com/token") {
+		t.Errorf("Expected a single audience to be parsed, got %v (%v)", claims.Audience, err)
+	}
+	if err := json.Unmarshal([]byte(`{"aud": ["a", "b"]}`), &claims); err != nil || !claims.Audience.ContainsAny("x", "b") {
+		t.Errorf("Expected an audience array to be parsed, got %v (%v)", claims.Audience, err)
+	}
+	if err := json.Unmarshal([]byte(`{"aud": 1}`), &claims); err == nil {
+		t.Error("Expected a numeric audience to be rejected")
+	}
+}
+
+func TestGenerateAndParseAssertion(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	now := time.Now().Unix()
+	assertion, err := GenerateAssertion(AssertionClaims{
+		Issuer:    "svc",
+		Subject:   "svc",
+		Audience:  Audience{"oauth2-server"},
+		ExpiresAt: now + 60,
+		ID:        "1",
+	}, privateKey, "ES256", "")
+	if err != nil {
+		t.Fatalf("Failed to generate assertion: %v", err)
+	}
+
+	var gotKid, gotAlg, gotSubject string
+	claims, err := ParseAssertion(assertion, func(claims *AssertionClaims, kid, alg string) (interface{}, error) {
+		gotKid, gotAlg, gotSubject = kid, alg, claims.Subject
+		return &privateKey.PublicKey, nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse assertion: %v", err)
+	}
+	if gotKid != "" || gotAlg != "ES256" || gotSubject != "svc" {
+		t.Errorf("Expected the lookup to get no kid, ES256 and subject svc, got %q, %q and %q", gotKid, gotAlg, gotSubject)
+	}
+	if claims.ID != "1" || !claims.Audience.ContainsAny("oauth2-server") {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+
+	noExp, _ := GenerateAssertion(AssertionClaims{Issuer: "svc", Subject: "svc"}, privateKey, "ES256", "")
+	if _, err := ParseAssertion(noExp, func(*AssertionClaims, string, string) (interface{}, error) {
+		return &privateKey.PublicKey, nil
+	}); err == nil {
+		t.Error("Expected an assertion without exp to be rejected")
+	}
+}
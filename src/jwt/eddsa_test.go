@@ -0,0 +1,70 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+// Test vectors from RFC 8037, appendix A.
+const (
+	rfc8037PrivateKey    = "nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A"
+	rfc8037SigningString = "eyJhbGciOiJFZERTQSJ9.RXhhbXBsZSBvZiBFZDI1NTE5IHNpZ25pbmc"
+	rfc8037Signature     = "hgyY0il_MGCjP0JzlnLWG1PPOt7-09PGcvMg3AIbQR6dWbhijcNR4ki4iylGjg5BhVsPt9g7sVvpAr_MuM0KAg"
+)
+
+func TestSigningMethodEdDSA_RFC8037(t *testing.T) {
+	seed, err := base64.RawURLEncoding.DecodeString(rfc8037PrivateKey)
+	if err != nil {
+		t.Fatalf("Failed to decode private key: %v", err)
+	}
+	privateKey := ed25519.NewKeyFromSeed(seed)
+
+	signature, err := SigningMethodEdDSA.Sign(rfc8037SigningString, privateKey)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	if signature != rfc8037Signature {
+		t.Errorf("Signature mismatch: got %s, want %s", signature, rfc8037Signature)
+	}
+
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	if err := SigningMethodEdDSA.Verify(rfc8037SigningString, rfc8037Signature, publicKey); err != nil {
+		t.Errorf("Expected RFC 8037 signature to verify: %v", err)
+	}
+	if err := SigningMethodEdDSA.Verify(rfc8037SigningString+"x", rfc8037Signature, publicKey); err == nil {
+		t.Error("Expected verification of a modified payload to fail")
+	}
+}
+
+func TestGenerateAndParseToken_EdDSA(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+	claims := Claims{StandardClaims: StandardClaims{Subject: "test-subject"}}
+
+	tokenString, err := GenerateToken(claims, privateKey, "EdDSA", "ed-kid")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	parsedClaims, err := ParseToken(tokenString, func(kid string) (interface{}, string, error) {
+		return publicKey, "EdDSA", nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	if parsedClaims.Subject != claims.Subject {
+		t.Errorf("Subject mismatch: got %s, want %s", parsedClaims.Subject, claims.Subject)
+	}
+}
+
+func TestSigningMethodEdDSA_InvalidKey(t *testing.T) {
+	if _, err := SigningMethodEdDSA.Sign("payload", []byte("secret")); err == nil {
+		t.Error("Expected an error signing with a non-Ed25519 key, but got nil")
+	}
+	if err := SigningMethodEdDSA.Verify("payload", "c2ln", []byte("secret")); err == nil {
+		t.Error("Expected an error verifying with a non-Ed25519 key, but got nil")
+	}
+}
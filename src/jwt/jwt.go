@@ -1,7 +1,11 @@
 package jwt
 
 import (
+	"crypto"
 	"errors"
+	"fmt"
+	"slices"
+	"sync"
 
 	jwtgo "github.com/dgrijalva/jwt-go"
 )
@@ -17,20 +21,107 @@ const (
 type Claims struct {
 	StandardClaims
 	Role Role `json:"role,omitempty"`
+	// Confirmation binds the token to a key of the client, so only the client
+	// holding it can use the token.
+	Confirmation *Confirmation `json:"cnf,omitempty"`
 }
 
-func GenerateToken(claims Claims, privateKey interface{}) (string, error) {
-	token := jwtgo.NewWithClaims(jwtgo.SigningMethodRS256, claims)
-	return token.SignedString(privateKey)
+// Confirmation is the cnf claim (RFC 7800).
+type Confirmation struct {
+	// X5tS256 is the SHA-256 thumbprint of the client certificate the token
+	// is bound to (RFC 8705, section 3.1).
+	X5tS256 string `json:"x5t#S256,omitempty"`
+	// JKT is the JWK thumbprint of the DPoP key the token is bound to (RFC 9449, section 6).
+	JKT string `json:"jkt,omitempty"`
 }
 
-// * This function is not used yet but will be useful in the future when we implement token validation. *
-func ParseToken(tokenString string, publicKey interface{}) (*Claims, error) {
+var (
+	algorithmsMu sync.RWMutex
+	// algorithms are the JWS algorithms tokens may use, all supported ones if empty.
+	algorithms []string
+)
+
+// SetAlgorithms restricts the JWS algorithms tokens are signed and accepted
+// with, e.g. to those a crypto policy approves. No algorithms lifts the
+// restriction.
+func SetAlgorithms(algs []string) {
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+	algorithms = slices.Clone(algs)
+}
+
+// checkAlgorithm returns an error if alg is not among the algorithms set by SetAlgorithms.
+func checkAlgorithm(alg string) error {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+	if len(algorithms) > 0 && !slices.Contains(algorithms, alg) {
+		return fmt.Errorf("signing algorithm %s is not allowed", alg)
+	}
+	return nil
+}
+
+// KeyLookup returns the verification key published under kid and the one JWS
+// algorithm it is registered for.
+type KeyLookup func(kid string) (key interface{}, alg string, err error)
+
+// GenerateToken signs the claims with signer using the JWS algorithm alg
+// (RS256, PS256/PS384/PS512, ES256/ES384/ES512 or EdDSA) and stamps kid into the header so verifiers can
+// pick the matching key from the JWKS.
+func GenerateToken(claims Claims, signer crypto.Signer, alg, kid string) (string, error) {
+	return signToken(claims, map[string]interface{}{"kid": kid}, signer, alg)
+}
+
+// signToken signs claims with signer using alg. header is added to the JOSE header.
+func signToken(claims jwtgo.Claims, header map[string]interface{}, signer crypto.Signer, alg string) (string, error) {
+	method := jwtgo.GetSigningMethod(alg)
+	if method == nil || method == jwtgo.SigningMethodNone {
+		return "", fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if err := checkAlgorithm(alg); err != nil {
+		return "", err
+	}
+	token := jwtgo.NewWithClaims(method, claims)
+	for name, value := range header {
+		token.Header[name] = value
+	}
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+	sig, err := sign(signer, method, signingString)
+	if err != nil {
+		return "", err
+	}
+	return signingString + "." + jwtgo.EncodeSegment(sig), nil
+}
+
+// ParseToken verifies the token with the key lookup returns for the kid in its
+// header. Only the exact algorithm registered for that key is accepted.
+func ParseToken(tokenString string, lookup KeyLookup) (*Claims, error) {
 	token, err := jwtgo.ParseWithClaims(tokenString, &Claims{}, func(token *jwtgo.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwtgo.SigningMethodRSA); !ok {
+		switch token.Method.(type) {
+		case *jwtgo.SigningMethodRSA, *jwtgo.SigningMethodRSAPSS, *jwtgo.SigningMethodECDSA, *SigningMethodEd25519:
+		default:
 			return nil, errors.New("unexpected signing method")
 		}
-		return publicKey, nil
+		if err := checkAlgorithm(token.Method.Alg()); err != nil {
+			return nil, err
+		}
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, errors.New("missing kid in token header")
+		}
+		key, alg, err := lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != alg {
+			return nil, fmt.Errorf("key %s is registered for %s, not %s", kid, alg, token.Method.Alg())
+		}
+		if err := checkPSS(token, key); err != nil {
+			return nil, err
+		}
+		return key, nil
 	})
 	if err != nil {
 		return nil, err
@@ -1,8 +1,14 @@
 package jwt
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -27,12 +33,16 @@ func TestGenerateAndParseToken(t *testing.T) {
 		},
 	}
 
-	tokenString, err := GenerateToken(claims, privateKey)
+	tokenString, err := GenerateToken(claims, privateKey, "RS256", "test-kid")
 	if err != nil {
 		t.Fatalf("Failed to generate token: %v", err)
 	}
 
-	parsedClaims, err := ParseToken(tokenString, publicKey)
+	var requestedKid string
+	parsedClaims, err := ParseToken(tokenString, func(kid string) (interface{}, string, error) {
+		requestedKid = kid
+		return publicKey, "RS256", nil
+	})
 	if err != nil {
 		t.Fatalf("Failed to parse token: %v", err)
 	}
@@ -43,6 +53,27 @@ func TestGenerateAndParseToken(t *testing.T) {
 	if parsedClaims.Subject != claims.Subject {
 		t.Errorf("Subject mismatch: got %s, want %s", parsedClaims.Subject, claims.Subject)
 	}
+	if requestedKid != "test-kid" {
+		t.Errorf("Kid mismatch: got %s, want test-kid", requestedKid)
+	}
+}
+
+func TestParseToken_UnknownKid(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	tokenString, err := GenerateToken(Claims{}, privateKey, "RS256", "retired-kid")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	_, err = ParseToken(tokenString, func(kid string) (interface{}, string, error) {
+		return nil, "", errors.New("unknown key id")
+	})
+	if err == nil {
+		t.Error("Expected an error for a token signed by an unknown kid, but got nil")
+	}
 }
 
 func TestParseToken_InvalidSignatureMethod(t *testing.T) {
@@ -71,3 +102,200 @@ func TestParseToken_BadToken(t *testing.T) {
 		t.Error("Expected ParseToken to return an error for an invalid token string, but got nil")
 	}
 }
+
+func TestGenerateAndParseToken_ECDSA(t *testing.T) {
+	for alg, curve := range map[string]elliptic.Curve{
+		"ES256": elliptic.P256(),
+		"ES384": elliptic.P384(),
+		"ES512": elliptic.P521(),
+	} {
+		privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate EC key: %v", err)
+		}
+		claims := Claims{StandardClaims: StandardClaims{Subject: "test-subject"}}
+
+		tokenString, err := GenerateToken(claims, privateKey, alg, "ec-kid")
+		if err != nil {
+			t.Fatalf("Failed to generate %s token: %v", alg, err)
+		}
+		parsedClaims, err := ParseToken(tokenString, func(kid string) (interface{}, string, error) {
+			return &privateKey.PublicKey, alg, nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to parse %s token: %v", alg, err)
+		}
+		if parsedClaims.Subject != claims.Subject {
+			t.Errorf("Subject mismatch: got %s, want %s", parsedClaims.Subject, claims.Subject)
+		}
+	}
+}
+
+func TestParseToken_MethodDoesNotFitKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	tokenString, err := GenerateToken(Claims{}, ecKey, "ES256", "kid")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	_, err = ParseToken(tokenString, func(kid string) (interface{}, string, error) {
+		return &rsaKey.PublicKey, "RS256", nil
+	})
+	if err == nil {
+		t.Error("Expected an error for an ES256 token verified with an RSA key, but got nil")
+	}
+}
+
+func TestGenerateToken_UnsupportedAlgorithm(t *testing.T) {
+	if _, err := GenerateToken(Claims{}, nil, "none", "kid"); err == nil {
+		t.Error("Expected an error for an unsupported algorithm, but got nil")
+	}
+}
+
+func TestSetAlgorithms(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+	token, err := GenerateToken(Claims{}, privateKey, "EdDSA", "kid")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	SetAlgorithms([]string{"RS256", "ES256"})
+	defer SetAlgorithms(nil)
+	if _, err := GenerateToken(Claims{}, privateKey, "EdDSA", "kid"); err == nil {
+		t.Error("Expected an error signing with an algorithm that is not allowed, but got nil")
+	}
+	_, err = ParseToken(token, func(kid string) (interface{}, string, error) {
+		return privateKey.Public(), "EdDSA", nil
+	})
+	if err == nil {
+		t.Error("Expected an error for a token with an algorithm that is not allowed, but got nil")
+	}
+}
+
+func TestGenerateAndParseToken_RSAPSS(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	for _, method := range []*jwtgo.SigningMethodRSAPSS{
+		jwtgo.SigningMethodPS256,
+		jwtgo.SigningMethodPS384,
+		jwtgo.SigningMethodPS512,
+	} {
+		alg := method.Alg()
+		tokenString, err := GenerateToken(Claims{}, privateKey, alg, "pss-kid")
+		if err != nil {
+			t.Fatalf("Failed to generate %s token: %v", alg, err)
+		}
+		if _, err := ParseToken(tokenString, func(kid string) (interface{}, string, error) {
+			return &privateKey.PublicKey, alg, nil
+		}); err != nil {
+			t.Fatalf("Failed to parse %s token: %v", alg, err)
+		}
+
+		// The salt has to be exactly as long as the hash (RFC 7518, section 3.5).
+		parts := strings.Split(tokenString, ".")
+		sig, err := jwtgo.DecodeSegment(parts[2])
+		if err != nil {
+			t.Fatalf("Failed to decode signature: %v", err)
+		}
+		hashed := method.Hash.New()
+		hashed.Write([]byte(parts[0] + "." + parts[1]))
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+		if err := rsa.VerifyPSS(&privateKey.PublicKey, method.Hash, hashed.Sum(nil), sig, opts); err != nil {
+			t.Errorf("Expected %s signature with hash-length salt: %v", alg, err)
+		}
+	}
+}
+
+func TestParseToken_RSAPSSSaltLength(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	if jwtgo.SigningMethodPS256.Options.SaltLength != rsa.PSSSaltLengthAuto {
+		t.Fatal("Expected the jwtgo signing methods to be left unchanged")
+	}
+
+	// jwtgo signs with the longest salt that fits the key.
+	token := jwtgo.NewWithClaims(jwtgo.SigningMethodPS256, Claims{})
+	token.Header["kid"] = "pss-kid"
+	tokenString, err := token.SignedString(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	_, err = ParseToken(tokenString, func(kid string) (interface{}, string, error) {
+		return &privateKey.PublicKey, "PS256", nil
+	})
+	if err == nil {
+		t.Error("Expected an error for a PS256 token whose salt is longer than the hash, but got nil")
+	}
+}
+
+func TestParseToken_AlgorithmNotRegisteredForKey(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	tokenString, err := GenerateToken(Claims{}, privateKey, "RS256", "pss-kid")
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	// The key is valid for RS256 signatures, but only registered for PS256.
+	_, err = ParseToken(tokenString, func(kid string) (interface{}, string, error) {
+		return &privateKey.PublicKey, "PS256", nil
+	})
+	if err == nil {
+		t.Error("Expected an error for an RS256 token verified with a PS256 key, but got nil")
+	}
+}
+
+// opaqueSigner hides the concrete key type, like a key held by an external signing service.
+type opaqueSigner struct {
+	crypto.Signer
+}
+
+func TestGenerateToken_OpaqueSigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+	for _, tc := range []struct {
+		alg string
+		key crypto.Signer
+	}{
+		{"RS256", rsaKey},
+		{"PS512", rsaKey},
+		{"ES384", ecKey},
+		{"EdDSA", edKey},
+	} {
+		tokenString, err := GenerateToken(Claims{}, opaqueSigner{tc.key}, tc.alg, "kid")
+		if err != nil {
+			t.Fatalf("Failed to generate %s token: %v", tc.alg, err)
+		}
+		if _, err := ParseToken(tokenString, func(kid string) (interface{}, string, error) {
+			return tc.key.Public(), tc.alg, nil
+		}); err != nil {
+			t.Errorf("Expected %s token signed through crypto.Signer to verify: %v", tc.alg, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package jwt
+
+import (
+	"crypto"
+	"errors"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+// ProofClaims are the claims of a DPoP proof (RFC 9449, section 4.2).
+type ProofClaims struct {
+	ID       string `json:"jti"`
+	Method   string `json:"htm"`
+	URI      string `json:"htu"`
+	IssuedAt int64  `json:"iat"`
+	// AccessTokenHash is the base64url encoded SHA-256 hash of the access
+	// token the proof is sent with, for requests to resource servers.
+	AccessTokenHash string `json:"ath,omitempty"`
+}
+
+// Valid does not check anything. How old a proof may be is up to the verifier.
+func (c ProofClaims) Valid() error {
+	return nil
+}
+
+// HeaderKeyLookup returns the key to verify a JWT with from its JOSE header,
+// e.g. the jwk of a DPoP proof. It has to reject algorithms the key is not
+// meant for.
+type HeaderKeyLookup func(header map[string]interface{}, alg string) (key interface{}, err error)
+
+// GenerateProof signs claims with signer using alg, as a client creating a
+// DPoP proof would. header is added to the JOSE header, e.g. typ and jwk.
+func GenerateProof(claims ProofClaims, header map[string]interface{}, signer crypto.Signer, alg string) (string, error) {
+	return signToken(claims, header, signer, alg)
+}
+
+// ParseProof verifies a JWT with the key lookup takes from its header.
+func ParseProof(tokenString string, lookup HeaderKeyLookup) (*ProofClaims, error) {
+	token, err := jwtgo.ParseWithClaims(tokenString, &ProofClaims{}, func(token *jwtgo.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwtgo.SigningMethodRSA, *jwtgo.SigningMethodRSAPSS, *jwtgo.SigningMethodECDSA, *SigningMethodEd25519:
+		default:
+			return nil, errors.New("unexpected signing method")
+		}
+		if err := checkAlgorithm(token.Method.Alg()); err != nil {
+			return nil, err
+		}
+		key, err := lookup(token.Header, token.Method.Alg())
+		if err != nil {
+			return nil, err
+		}
+		if err := checkPSS(token, key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*ProofClaims); ok && token.Valid {
+		return claims, nil
+	}
+	return nil, errors.New("invalid proof")
+}
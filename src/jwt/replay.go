@@ -0,0 +1,33 @@
+package jwt
+
+import (
+	"sync"
+	"time"
+)
+
+// ReplayCache remembers the ids of used JWTs until they expire, so each is
+// only accepted once, e.g. client assertions or DPoP proofs. It only covers
+// this instance. The zero value is ready to use.
+type ReplayCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// Use records id until expiry and reports whether it was not used before.
+func (c *ReplayCache) Use(id string, expiry, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen == nil {
+		c.seen = map[string]time.Time{}
+	}
+	for seen, seenExpiry := range c.seen {
+		if !seenExpiry.After(now) {
+			delete(c.seen, seen)
+		}
+	}
+	if _, ok := c.seen[id]; ok {
+		return false
+	}
+	c.seen[id] = expiry
+	return true
+}
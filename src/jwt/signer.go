@@ -0,0 +1,64 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	jwtgo "github.com/dgrijalva/jwt-go"
+)
+
+// sign computes the JWS signature of signingString with signer. Only the
+// crypto.Signer interface is used, so the private key may live outside the
+// process, e.g. in an HSM or a remote signing service.
+func sign(signer crypto.Signer, method jwtgo.SigningMethod, signingString string) ([]byte, error) {
+	switch method := method.(type) {
+	case *jwtgo.SigningMethodRSA:
+		return signer.Sign(rand.Reader, digest(method.Hash, signingString), method.Hash)
+	case *jwtgo.SigningMethodRSAPSS:
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: method.Hash}
+		return signer.Sign(rand.Reader, digest(method.Hash, signingString), opts)
+	case *jwtgo.SigningMethodECDSA:
+		der, err := signer.Sign(rand.Reader, digest(method.Hash, signingString), method.Hash)
+		if err != nil {
+			return nil, err
+		}
+		return ecdsaJOSESignature(der, method.KeySize)
+	case *SigningMethodEd25519:
+		return signer.Sign(rand.Reader, []byte(signingString), crypto.Hash(0))
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", method.Alg())
+	}
+}
+
+func digest(hash crypto.Hash, signingString string) []byte {
+	h := hash.New()
+	h.Write([]byte(signingString))
+	return h.Sum(nil)
+}
+
+// ecdsaJOSESignature converts the ASN.1 DER signature crypto.Signer returns into
+// the fixed size R || S encoding of RFC 7518, section 3.4.
+func ecdsaJOSESignature(der []byte, size int) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	rest, err := asn1.Unmarshal(der, &sig)
+	if err != nil {
+		return nil, fmt.Errorf("parsing ECDSA signature: %w", err)
+	}
+	if len(rest) > 0 || sig.R.Sign() <= 0 || sig.S.Sign() <= 0 {
+		return nil, errors.New("malformed ECDSA signature")
+	}
+	if sig.R.BitLen() > size*8 || sig.S.BitLen() > size*8 {
+		return nil, errors.New("ECDSA signature does not fit the curve")
+	}
+	out := make([]byte, 2*size)
+	sig.R.FillBytes(out[:size])
+	sig.S.FillBytes(out[size:])
+	return out, nil
+}
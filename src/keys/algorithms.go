@@ -0,0 +1,77 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+)
+
+// DefaultAlgorithm is the JWS algorithm used when none is configured.
+const DefaultAlgorithm = "RS256"
+
+// curveAlgorithms maps the supported EC curves to their JWS algorithm (RFC 7518, section 3.4).
+var curveAlgorithms = map[elliptic.Curve]string{
+	elliptic.P256(): "ES256",
+	elliptic.P384(): "ES384",
+	elliptic.P521(): "ES512",
+}
+
+// algorithmCurves maps the ECDSA JWS algorithms to their curves.
+var algorithmCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+// rsaAlgorithms are the JWS algorithms an RSA key can be registered for.
+var rsaAlgorithms = map[string]bool{
+	"RS256": true,
+	"PS256": true,
+	"PS384": true,
+	"PS512": true,
+}
+
+// Algorithm returns the default JWS algorithm for the private half of pub.
+// RSA keys default to RS256 but may be registered for RSA-PSS instead, see CheckAlgorithm.
+func Algorithm(pub crypto.PublicKey) (string, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return "RS256", nil
+	case *ecdsa.PublicKey:
+		if alg, ok := curveAlgorithms[pub.Curve]; ok {
+			return alg, nil
+		}
+		return "", fmt.Errorf("unsupported EC curve %s", pub.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "EdDSA", nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T", pub)
+	}
+}
+
+// CheckAlgorithm returns an error unless the private half of pub can sign with alg.
+func CheckAlgorithm(pub crypto.PublicKey, alg string) error {
+	if _, ok := pub.(*rsa.PublicKey); ok {
+		if !rsaAlgorithms[alg] {
+			return fmt.Errorf("RSA keys cannot sign with %s", alg)
+		}
+		return nil
+	}
+	keyAlg, err := Algorithm(pub)
+	if err != nil {
+		return err
+	}
+	if keyAlg != alg {
+		return fmt.Errorf("%s keys cannot sign with %s", keyAlg, alg)
+	}
+	return nil
+}
+
+// GenerateKey creates a new private key for the JWS algorithm alg under the
+// default key policy, see KeyPolicy.GenerateKey.
+func GenerateKey(alg string) (crypto.Signer, error) {
+	return KeyPolicy{}.GenerateKey(alg)
+}
@@ -0,0 +1,37 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestCheckAlgorithm(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+
+	for _, alg := range []string{"RS256", "PS256", "PS384", "PS512"} {
+		if err := CheckAlgorithm(&rsaKey.PublicKey, alg); err != nil {
+			t.Errorf("Expected RSA key to be usable with %s: %v", alg, err)
+		}
+	}
+	for _, alg := range []string{"ES256", "EdDSA", "HS256"} {
+		if err := CheckAlgorithm(&rsaKey.PublicKey, alg); err == nil {
+			t.Errorf("Expected RSA key to be rejected for %s", alg)
+		}
+	}
+	if err := CheckAlgorithm(&ecKey.PublicKey, "ES256"); err != nil {
+		t.Errorf("Expected P-256 key to be usable with ES256: %v", err)
+	}
+	if err := CheckAlgorithm(&ecKey.PublicKey, "ES384"); err == nil {
+		t.Error("Expected P-256 key to be rejected for ES384")
+	}
+}
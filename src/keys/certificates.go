@@ -0,0 +1,180 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+)
+
+// DefaultCertificateValidity is how long self-issued certificates are valid
+// when keys are not rotated. Like all self-issued certificates, they are
+// renewed once two thirds of their validity have passed.
+const DefaultCertificateValidity = 365 * 24 * time.Hour
+
+// certificateClockSkew backdates self-issued certificates, so verifiers with a
+// slightly late clock accept them right away.
+const certificateClockSkew = 5 * time.Minute
+
+// LoadCertificateChain reads a PEM file with an X.509 certificate chain. The
+// first certificate is the one of the signing key, each following one has to
+// have issued the certificate before it.
+func LoadCertificateChain(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading certificate chain: %w", err)
+	}
+	chain, err := parseCertificateChain(data)
+	if err != nil {
+		return nil, fmt.Errorf("certificate chain %s: %w", path, err)
+	}
+	return chain, nil
+}
+
+func parseCertificateChain(data []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	for i := 0; i+1 < len(chain); i++ {
+		if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+			return nil, fmt.Errorf("certificate %d is not issued by the next one: %w", i, err)
+		}
+	}
+	return chain, nil
+}
+
+// encodeCertificateChain is the inverse of parseCertificateChain.
+func encodeCertificateChain(chain []*x509.Certificate) []byte {
+	var data []byte
+	for _, cert := range chain {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return data
+}
+
+// certificateChain returns the certificate chain published with key: a loaded
+// chain for the key if there is one, otherwise a self-issued certificate if
+// enabled, otherwise none.
+func (r *KeyRing) certificateChain(key crypto.Signer, kid string, now time.Time) ([]*x509.Certificate, error) {
+	if chain := r.loadedCertificateChain(key); chain != nil {
+		return chain, nil
+	}
+	if !r.selfIssue {
+		return nil, nil
+	}
+
+	validity := DefaultCertificateValidity
+	if r.rotationInterval > 0 {
+		// Long enough for the whole time the key is published.
+		validity = r.prePublish + r.rotationInterval + r.tokenLifetime + certificateClockSkew
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: kid},
+		NotBefore:             now.Add(-certificateClockSkew),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("issuing certificate for key %s: %w", kid, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return []*x509.Certificate{cert}, nil
+}
+
+// certificatesDue returns the published keys whose self-issued certificate
+// has used up two thirds of its validity, so it is renewed long before
+// verifiers reject it, e.g. for keys that sign longer than planned.
+func (r *KeyRing) certificatesDue(now time.Time) []Key {
+	if !r.selfIssue {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var due []Key
+	for _, k := range r.keys {
+		if k.Status == StatusRevoked || len(k.Certificates) != 1 || r.loadedCertificateChain(k.Signer) != nil {
+			continue
+		}
+		cert := k.Certificates[0]
+		if renewAt := cert.NotBefore.Add(cert.NotAfter.Sub(cert.NotBefore) * 2 / 3); now.Before(renewAt) {
+			continue
+		}
+		due = append(due, *k)
+	}
+	return due
+}
+
+// loadedCertificateChain returns the loaded chain for key, if there is one.
+func (r *KeyRing) loadedCertificateChain(key crypto.Signer) []*x509.Certificate {
+	for _, chain := range r.certificates {
+		if samePublicKey(chain[0].PublicKey, key.Public()) {
+			return chain
+		}
+	}
+	return nil
+}
+
+// checkCertificatesUsed fails for loaded certificate chains that belong to none
+// of the keys, which usually means a certificate was renewed for the wrong key.
+func (r *KeyRing) checkCertificatesUsed() error {
+	for _, chain := range r.certificates {
+		used := false
+		for _, k := range r.keys {
+			used = used || (len(k.Certificates) > 0 && k.Certificates[0] == chain[0])
+		}
+		if !used {
+			return fmt.Errorf("certificate %q does not belong to any signing key", chain[0].Subject)
+		}
+	}
+	return nil
+}
+
+func samePublicKey(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// certificateMembers returns the x5c and x5t#S256 JWK members for a chain (RFC 7517, sections 4.7 and 4.9).
+func certificateMembers(chain []*x509.Certificate) ([]string, string) {
+	x5c := make([]string, len(chain))
+	for i, cert := range chain {
+		// x5c uses standard base64, unlike the other members.
+		x5c[i] = base64.StdEncoding.EncodeToString(cert.Raw)
+	}
+	thumbprint := sha256.Sum256(chain[0].Raw)
+	return x5c, base64.RawURLEncoding.EncodeToString(thumbprint[:])
+}
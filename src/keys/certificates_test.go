@@ -0,0 +1,206 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// issueCertificate creates a certificate for key, signed by issuer or self-signed if issuer is nil.
+func issueCertificate(t *testing.T, key crypto.Signer, name string, isCA bool, issuer *x509.Certificate, issuerKey crypto.Signer) *x509.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if issuer == nil {
+		issuer, issuerKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), issuerKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+func writeCertificates(t *testing.T, certs ...*x509.Certificate) string {
+	t.Helper()
+	var data []byte
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	path := filepath.Join(t.TempDir(), "chain.pem")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write certificate chain: %v", err)
+	}
+	return path
+}
+
+func TestGetJWK_SelfIssuedCertificate(t *testing.T) {
+	ring, err := NewKeyRing(NewMemoryProvider(nil, KeyPolicy{}), Options{Algorithm: "ES256", SelfIssueCertificates: true}, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to create key ring: %v", err)
+	}
+	jwkMap, err := ring.GetJWK()
+	if err != nil {
+		t.Fatalf("Unexpected error when getting JWK: %v", err)
+	}
+	jwk := jwkMap["keys"].([]JWK)[0]
+
+	if len(jwk.X5c) != 1 {
+		t.Fatalf("Expected a single self-issued certificate in x5c, got %d", len(jwk.X5c))
+	}
+	der, err := base64.StdEncoding.DecodeString(jwk.X5c[0])
+	if err != nil {
+		t.Fatalf("Expected x5c to be standard base64: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse x5c certificate: %v", err)
+	}
+	active, _ := ring.ActiveKey("")
+	if !samePublicKey(cert.PublicKey, active.Public()) {
+		t.Error("Expected the certificate to be issued for the signing key")
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		t.Errorf("Expected a self-signed certificate: %v", err)
+	}
+	thumbprint := sha256.Sum256(der)
+	if jwk.X5tS256 != base64.RawURLEncoding.EncodeToString(thumbprint[:]) {
+		t.Errorf("Expected x5t#S256 to be the SHA-256 thumbprint of the certificate, got %s", jwk.X5tS256)
+	}
+}
+
+func TestGetJWK_NoCertificate(t *testing.T) {
+	jwkMap, err := InitializeKeys().GetJWK()
+	if err != nil {
+		t.Fatalf("Unexpected error when getting JWK: %v", err)
+	}
+	if jwk := jwkMap["keys"].([]JWK)[0]; jwk.X5c != nil || jwk.X5tS256 != "" {
+		t.Error("Expected no certificate members without certificates")
+	}
+}
+
+func TestLoadKeys_CertificateChain(t *testing.T) {
+	key, err := GenerateKey("ES256")
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "signing.pem")
+	if err := WritePrivateKeyFile(keyFile, key, nil); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	caKey, err := GenerateKey("ES384")
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	ca := issueCertificate(t, caKey, "Test CA", true, nil, nil)
+	leaf := issueCertificate(t, key, "signing", false, ca, caKey)
+
+	ring, err := LoadKeys(Options{KeyFile: keyFile, Algorithm: "ES256", CertificateFiles: []string{writeCertificates(t, leaf, ca)}})
+	if err != nil {
+		t.Fatalf("Unexpected error loading key with certificate chain: %v", err)
+	}
+	jwkMap, err := ring.GetJWK()
+	if err != nil {
+		t.Fatalf("Unexpected error when getting JWK: %v", err)
+	}
+	jwk := jwkMap["keys"].([]JWK)[0]
+	if len(jwk.X5c) != 2 || jwk.X5c[0] != base64.StdEncoding.EncodeToString(leaf.Raw) {
+		t.Errorf("Expected x5c to hold the leaf followed by the CA, got %d certificates", len(jwk.X5c))
+	}
+
+	// The chain has to start with the certificate of the key.
+	if _, err := LoadKeys(Options{KeyFile: keyFile, Algorithm: "ES256", CertificateFiles: []string{writeCertificates(t, ca, leaf)}}); err == nil {
+		t.Error("Expected an error for a chain in the wrong order, but got nil")
+	}
+	other := issueCertificate(t, caKey, "other", false, nil, nil)
+	if _, err := LoadKeys(Options{KeyFile: keyFile, Algorithm: "ES256", CertificateFiles: []string{writeCertificates(t, other)}}); err == nil {
+		t.Error("Expected an error for a certificate of another key, but got nil")
+	}
+}
+
+func TestKeyRing_RotationSelfIssuesCertificate(t *testing.T) {
+	start := time.Now()
+	ring, err := NewKeyRing(NewMemoryProvider(nil, KeyPolicy{}), Options{
+		Algorithm:             "ES256",
+		RotationInterval:      24 * time.Hour,
+		PrePublish:            time.Hour,
+		SelfIssueCertificates: true,
+	}, start)
+	if err != nil {
+		t.Fatalf("Failed to create key ring: %v", err)
+	}
+	if err := ring.Rotate(start.Add(23 * time.Hour)); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	for _, k := range ring.Keys() {
+		if len(k.Certificates) != 1 || !samePublicKey(k.Certificates[0].PublicKey, k.Public()) {
+			t.Errorf("Expected key %s to have its own certificate", k.Kid)
+			continue
+		}
+		if k.Certificates[0].NotAfter.Before(k.CreatedAt.Add(25 * time.Hour)) {
+			t.Errorf("Expected the certificate of key %s to outlive the key", k.Kid)
+		}
+	}
+}
+
+func TestKeyRing_RenewsSelfIssuedCertificate(t *testing.T) {
+	start := time.Now()
+	opts := Options{Algorithm: "ES256", KeyDirectory: t.TempDir(), SelfIssueCertificates: true}
+	first, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+	second, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Failed to load keys of a second instance: %v", err)
+	}
+	key, _ := first.ActiveKey("")
+	issued := key.Certificates[0]
+
+	if err := first.Rotate(start.Add(DefaultCertificateValidity / 2)); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	if key, _ := first.ActiveKey(""); key.Certificates[0] != issued {
+		t.Error("Expected the certificate to be kept while it is valid long enough")
+	}
+
+	later := start.Add(DefaultCertificateValidity * 3 / 4)
+	if err := first.Rotate(later); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	renewed, _ := first.ActiveKey("")
+	if renewed.Certificates[0] == issued || !renewed.Certificates[0].NotAfter.After(later.Add(DefaultCertificateValidity/2)) {
+		t.Fatalf("Expected the certificate to be renewed, valid until %s", renewed.Certificates[0].NotAfter)
+	}
+	if !samePublicKey(renewed.Certificates[0].PublicKey, renewed.Public()) {
+		t.Error("Expected the renewed certificate to be for the same key")
+	}
+
+	// The other instance picks up the renewed certificate.
+	if err := second.Sync(later); err != nil {
+		t.Fatalf("Unexpected sync error: %v", err)
+	}
+	if other, _ := second.ActiveKey(""); !other.Certificates[0].Equal(renewed.Certificates[0]) {
+		t.Error("Expected the other instance to publish the renewed certificate")
+	}
+}
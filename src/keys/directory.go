@@ -0,0 +1,168 @@
+package keys
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// directoryLockFile exists while an instance changes the ring.
+const directoryLockFile = ".lock"
+
+// DirectoryStore is a KeyStore in a directory all instances mount, e.g. a
+// ReadWriteMany volume:
+//
+//	ring.json    the keys and their lifecycle
+//	<kid>.pem    the private keys, encrypted if a passphrase is set
+//	<kid>.crt    the certificate chains of keys that have one
+//	.lock        exists while an instance changes the ring
+type DirectoryStore struct {
+	dir        string
+	passphrase []byte
+	// owner identifies this instance in the lock file.
+	owner string
+
+	mu sync.Mutex
+	// signers caches the parsed private keys, so polling stays cheap.
+	signers map[string]crypto.Signer
+}
+
+// NewDirectoryStore creates a store in dir. Key files are encrypted with
+// passphrase unless it is empty.
+func NewDirectoryStore(dir string, passphrase []byte) (*DirectoryStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating key directory: %w", err)
+	}
+	return &DirectoryStore{
+		dir:        dir,
+		passphrase: passphrase,
+		owner:      lockOwner(),
+		signers:    map[string]crypto.Signer{},
+	}, nil
+}
+
+func (s *DirectoryStore) Load() ([]Key, string, error) {
+	data, err := os.ReadFile(s.path(storedRingFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("reading key ring: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, err := loadStoredKeys(data, s.passphrase, s.signers, func(name string) ([]byte, error) {
+		return os.ReadFile(s.path(name))
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("key ring %s: %w", s.path(storedRingFile), err)
+	}
+	return keys, dataVersion(data), nil
+}
+
+func (s *DirectoryStore) Version() (string, error) {
+	data, err := os.ReadFile(s.path(storedRingFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading key ring: %w", err)
+	}
+	return dataVersion(data), nil
+}
+
+// Save writes the files of new keys before the ring, so other instances never
+// see a key without its file, and removes the files of dropped keys afterwards.
+func (s *DirectoryStore) Save(keys []Key) (string, error) {
+	files, kept, err := storedKeyFiles(keys, s.passphrase, func(name string) ([]byte, bool) {
+		data, err := os.ReadFile(s.path(name))
+		return data, err == nil
+	})
+	if err != nil {
+		return "", err
+	}
+	for name, data := range files {
+		if err := s.write(name, data); err != nil {
+			return "", err
+		}
+	}
+
+	data, err := marshalRing(keys)
+	if err != nil {
+		return "", err
+	}
+	if err := s.write(storedRingFile, data); err != nil {
+		return "", err
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return "", fmt.Errorf("reading key directory: %w", err)
+	}
+	for _, entry := range entries {
+		if name := entry.Name(); isKeyFile(name) && !kept[name] {
+			os.Remove(s.path(name))
+		}
+	}
+	return dataVersion(data), nil
+}
+
+// Lock creates the lock file. A lock file older than staleLockAge was left
+// behind by a crashed instance and is taken over.
+func (s *DirectoryStore) Lock() error {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(s.path(directoryLockFile), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			_, err = f.WriteString(s.owner)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("creating lock file: %w", err)
+		}
+		if info, err := os.Stat(s.path(directoryLockFile)); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(s.path(directoryLockFile))
+			continue
+		}
+		if time.Now().After(deadline) {
+			return ErrLocked
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Unlock removes the lock file unless another instance has taken it over.
+func (s *DirectoryStore) Unlock() error {
+	owner, err := os.ReadFile(s.path(directoryLockFile))
+	if err != nil {
+		return fmt.Errorf("reading lock file: %w", err)
+	}
+	if string(owner) != s.owner {
+		return errors.New("lock file has been taken over by another instance")
+	}
+	return os.Remove(s.path(directoryLockFile))
+}
+
+func (s *DirectoryStore) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// write replaces the file name, so other instances never read it half written.
+func (s *DirectoryStore) write(name string, data []byte) error {
+	tmp := s.path("." + name + ".new")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	if err := os.Rename(tmp, s.path(name)); err != nil {
+		return fmt.Errorf("replacing %s: %w", name, err)
+	}
+	return nil
+}
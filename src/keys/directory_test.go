@@ -0,0 +1,120 @@
+package keys
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadKeys_KeyDirectoryShared(t *testing.T) {
+	opts := Options{
+		KeyDirectory:         t.TempDir(),
+		AdditionalAlgorithms: []string{"ES256"},
+		RotationInterval:     24 * time.Hour,
+		PrePublish:           2 * time.Hour,
+		TokenLifetime:        time.Hour,
+	}
+	first, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+	second, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Failed to load keys of a second instance: %v", err)
+	}
+	for _, alg := range []string{"RS256", "ES256"} {
+		a, errA := first.ActiveKey(alg)
+		b, errB := second.ActiveKey(alg)
+		if errA != nil || errB != nil || a.Kid != b.Kid {
+			t.Errorf("Expected both instances to sign %s with the same key, got %s and %s", alg, a.Kid, b.Kid)
+		}
+	}
+	docA, _ := first.JWKS()
+	docB, _ := second.JWKS()
+	if docA.ETag != docB.ETag {
+		t.Error("Expected both instances to publish the same JWKS")
+	}
+
+	// A rotation by one instance is picked up by the other.
+	if err := first.Rotate(time.Now().Add(22 * time.Hour)); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	if err := second.Sync(time.Now()); err != nil {
+		t.Fatalf("Unexpected sync error: %v", err)
+	}
+	if len(second.Keys()) != 4 {
+		t.Errorf("Expected the pending keys to be published by the other instance, got %d keys", len(second.Keys()))
+	}
+
+	// So is a revocation.
+	leaked, _ := first.ActiveKey("RS256")
+	if _, err := first.Revoke(leaked.Kid, time.Now()); err != nil {
+		t.Fatalf("Unexpected error revoking the active key: %v", err)
+	}
+	if err := second.Sync(time.Now()); err != nil {
+		t.Fatalf("Unexpected sync error: %v", err)
+	}
+	if _, err := second.Lookup(leaked.Kid); !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("Expected the other instance to reject the revoked key, got %v", err)
+	}
+	a, _ := first.ActiveKey("RS256")
+	b, _ := second.ActiveKey("RS256")
+	if a.Kid != b.Kid || a.Kid == leaked.Kid {
+		t.Errorf("Expected both instances to switch to the same replacement, got %s and %s", a.Kid, b.Kid)
+	}
+
+	// A restarted instance loads the same keys.
+	restarted, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Failed to load keys after a restart: %v", err)
+	}
+	if len(restarted.Keys()) != len(first.Keys()) {
+		t.Errorf("Expected %d keys after a restart, got %d", len(first.Keys()), len(restarted.Keys()))
+	}
+	if _, err := restarted.Lookup(leaked.Kid); !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("Expected the revocation to survive a restart, got %v", err)
+	}
+}
+
+func TestLoadKeys_KeyDirectoryWithKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	_, err := LoadKeys(Options{KeyDirectory: dir, KeyFile: filepath.Join(dir, "signing.pem")})
+	if err == nil {
+		t.Error("Expected an error for a key file together with a key directory")
+	}
+}
+
+func TestDirectoryStore_Lock(t *testing.T) {
+	defer func(timeout time.Duration) { lockTimeout = timeout }(lockTimeout)
+	lockTimeout = 200 * time.Millisecond
+
+	dir := t.TempDir()
+	first, _ := NewDirectoryStore(dir, nil)
+	second, _ := NewDirectoryStore(dir, nil)
+	if err := first.Lock(); err != nil {
+		t.Fatalf("Failed to take the lock: %v", err)
+	}
+	if err := second.Lock(); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked while another instance holds the lock, got %v", err)
+	}
+	if err := first.Unlock(); err != nil {
+		t.Fatalf("Failed to release the lock: %v", err)
+	}
+	if err := second.Lock(); err != nil {
+		t.Fatalf("Expected the lock to be free again: %v", err)
+	}
+
+	// The lock of a crashed instance is taken over once it is stale.
+	stale := time.Now().Add(-2 * staleLockAge)
+	if err := os.Chtimes(filepath.Join(dir, directoryLockFile), stale, stale); err != nil {
+		t.Fatalf("Failed to age the lock file: %v", err)
+	}
+	if err := first.Lock(); err != nil {
+		t.Errorf("Expected a stale lock to be taken over: %v", err)
+	}
+	if err := second.Unlock(); err == nil {
+		t.Error("Expected unlocking a lock taken over by another instance to fail")
+	}
+}
@@ -0,0 +1,72 @@
+package keys
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// DefaultJWKSMaxAge is how long the JWKS may be cached when keys are not rotated.
+const DefaultJWKSMaxAge = 5 * time.Minute
+
+// JWKSDocument is the encoded JWK set of a ring together with what HTTP
+// caches need to know about it.
+type JWKSDocument struct {
+	Body []byte
+	// ETag is a strong entity tag derived from Body.
+	ETag string
+	// LastModified is when the published keys last changed.
+	LastModified time.Time
+	// MaxAge is how long caches may keep the document. With rotation it is
+	// half the pre-publication period, so a cached copy always includes the
+	// next key before it starts signing.
+	MaxAge time.Duration
+}
+
+// JWKS returns the encoded JWK set of the ring. It is encoded once whenever
+// the published keys change, not for every request.
+func (r *KeyRing) JWKS() (JWKSDocument, error) {
+	if r == nil {
+		return JWKSDocument{}, errors.New("key ring is nil")
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.jwks, r.jwksErr
+}
+
+// publish encodes the JWKS after the published keys changed. LastModified
+// only moves when the encoded set actually differs. r.mu must be held.
+func (r *KeyRing) publish(now time.Time) {
+	keys := make([]Key, 0, len(r.keys))
+	for _, k := range r.keys {
+		keys = append(keys, *k)
+	}
+	jwks, err := publishedJWKs(keys)
+	if err != nil {
+		r.jwksErr = err
+		return
+	}
+	body, err := json.Marshal(map[string]any{"keys": jwks})
+	if err != nil {
+		r.jwksErr = err
+		return
+	}
+	r.jwksErr = nil
+
+	sum := sha256.Sum256(body)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:]) + `"`
+	if etag == r.jwks.ETag {
+		return
+	}
+	r.jwks = JWKSDocument{Body: body, ETag: etag, LastModified: now, MaxAge: r.jwksMaxAge()}
+}
+
+// jwksMaxAge returns how long the JWKS may be cached.
+func (r *KeyRing) jwksMaxAge() time.Duration {
+	if r.rotationInterval > 0 {
+		return r.prePublish / 2
+	}
+	return DefaultJWKSMaxAge
+}
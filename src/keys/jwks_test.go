@@ -0,0 +1,58 @@
+package keys
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestKeyRing_JWKSChangesWithPublishedKeys(t *testing.T) {
+	start := time.Now()
+	ring := newTestRing(t, Options{RotationInterval: 24 * time.Hour, PrePublish: 2 * time.Hour}, start)
+	first, err := ring.JWKS()
+	if err != nil {
+		t.Fatalf("Unexpected error getting JWKS: %v", err)
+	}
+	if !first.LastModified.Equal(start) {
+		t.Errorf("Expected Last-Modified %s, got %s", start, first.LastModified)
+	}
+	if first.MaxAge != time.Hour {
+		t.Errorf("Expected max-age of half the pre-publication period, got %s", first.MaxAge)
+	}
+
+	// Nothing is published or dropped yet.
+	if err := ring.Rotate(start.Add(time.Hour)); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	if same, _ := ring.JWKS(); same.ETag != first.ETag || !same.LastModified.Equal(start) {
+		t.Error("Expected the JWKS to stay the same without key changes")
+	}
+
+	prePublished := start.Add(22 * time.Hour)
+	if err := ring.Rotate(prePublished); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	next, _ := ring.JWKS()
+	if next.ETag == first.ETag || !next.LastModified.Equal(prePublished) {
+		t.Error("Expected a new ETag and Last-Modified once the next key is published")
+	}
+
+	// Promoting the key changes what signs, not what is published.
+	if err := ring.Rotate(start.Add(24 * time.Hour)); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	if promoted, _ := ring.JWKS(); promoted.ETag != next.ETag || !promoted.LastModified.Equal(prePublished) {
+		t.Error("Expected the JWKS to stay the same when a published key is promoted")
+	}
+}
+
+func TestPublishedJWKs_Empty(t *testing.T) {
+	jwks, err := publishedJWKs([]Key{{Status: StatusRevoked}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	body, _ := json.Marshal(map[string]any{"keys": jwks})
+	if string(body) != `{"keys":[]}` {
+		t.Errorf("Expected an empty key list, got %s", body)
+	}
+}
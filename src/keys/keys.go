@@ -1,72 +1,261 @@
 package keys
 
 import (
-	"crypto/rand"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"log"
 	"math/big"
+	"slices"
+	"sync"
+	"time"
 )
 
 var (
-	PrivateKey *rsa.PrivateKey
-	PublicKey  *rsa.PublicKey
+	publicKeyPolicyMu sync.RWMutex
+	// minPublicRSABits and publicAlgorithms restrict the keys JWK.PublicKey accepts.
+	minPublicRSABits = MinRSAKeyBits
+	publicAlgorithms []string
 )
 
-func InitializeKeys() {
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
+// InitializeKeys creates a key ring around an ephemeral in-memory key, e.g. for tests.
+// Use LoadKeys to load persistent keys.
+func InitializeKeys() *KeyRing {
+	ring, err := NewKeyRing(NewMemoryProvider(nil, KeyPolicy{}), Options{}, time.Now())
 	if err != nil {
-		log.Fatalf("Error generating RSA key: %v", err)
+		log.Fatalf("Error creating key ring: %v", err)
 	}
-	PrivateKey = key
-	PublicKey = &key.PublicKey
+	return ring
 }
 
-func ExportPublicKeyPEM() []byte {
-	pubASN1, err := x509.MarshalPKIXPublicKey(PublicKey)
+// LookupPublicKey returns the public key published under kid together with the
+// algorithm it is registered for. It matches the signature of jwt.KeyLookup.
+func (r *KeyRing) LookupPublicKey(kid string) (interface{}, string, error) {
+	if r == nil {
+		return nil, "", errors.New("key ring is nil")
+	}
+	key, err := r.Lookup(kid)
 	if err != nil {
-		log.Fatalf("Error marshaling public key: %v", err)
+		return nil, "", err
+	}
+	return key.Public(), key.Alg, nil
+}
+
+// ExportPublicKeyPEM returns the published key kid as a PEM encoded
+// SubjectPublicKeyInfo, whatever its type. With an empty kid it returns every
+// published key, each block preceded by its kid and alg as explanatory text
+// (RFC 7468, section 2), which PEM parsers skip.
+func (r *KeyRing) ExportPublicKeyPEM(kid string) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("key ring is nil")
 	}
-	pemBlock := &pem.Block{
-		Type:  "PUBLIC KEY",
-		Bytes: pubASN1,
+	if kid != "" {
+		key, err := r.Lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		return publicKeyPEM(key.Public())
+	}
+
+	var out []byte
+	for _, key := range r.Keys() {
+		if key.Status == StatusRevoked {
+			continue
+		}
+		block, err := publicKeyPEM(key.Public())
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", key.Kid, err)
+		}
+		out = fmt.Appendf(out, "kid: %s\nalg: %s\n", key.Kid, key.Alg)
+		out = append(out, block...)
+	}
+	return out, nil
+}
+
+func publicKeyPEM(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling public key: %w", err)
 	}
-	return pem.EncodeToMemory(pemBlock)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
 }
 
-// for verifing JWT signatures with a rsa key, the fields are enough. No need for optional fields.
+// JWK is a public key as published in the JWKS (RFC 7517, RFC 7518 section 6, RFC 8037).
+// RSA keys use n and e, EC keys crv, x and y, OKP keys crv and x; the other members stay empty.
+// Keys with a certificate carry its chain in x5c and the SHA-256 thumbprint of the leaf in x5t#S256.
 type JWK struct {
-	Kty string `json:"kty"`
-	Use string `json:"use"`
-	Kid string `json:"kid"`
-	Alg string `json:"alg"`
-	N   string `json:"n"`
-	E   string `json:"e"`
+	Kty     string   `json:"kty"`
+	Use     string   `json:"use"`
+	Kid     string   `json:"kid"`
+	Alg     string   `json:"alg"`
+	N       string   `json:"n,omitempty"`
+	E       string   `json:"e,omitempty"`
+	Crv     string   `json:"crv,omitempty"`
+	X       string   `json:"x,omitempty"`
+	Y       string   `json:"y,omitempty"`
+	X5c     []string `json:"x5c,omitempty"`
+	X5tS256 string   `json:"x5t#S256,omitempty"`
 }
 
-func GetJWK() (map[string]interface{}, error) {
-	if PublicKey == nil {
-		return nil, errors.New("public key is nil, did you call InitializeKeys?")
+// GetJWK returns every published key of the ring (pending, active and retiring) as a JWK set.
+// Revoked keys are left out.
+func (r *KeyRing) GetJWK() (map[string]interface{}, error) {
+	if r == nil {
+		return nil, errors.New("key ring is nil")
+	}
+	jwks, err := publishedJWKs(r.Keys())
+	if err != nil {
+		return nil, err
 	}
+	return map[string]any{
+		"keys": jwks,
+	}, nil
+}
 
-	n := base64.RawURLEncoding.EncodeToString(PublicKey.N.Bytes())
+// publishedJWKs returns the JWKs of keys, leaving out revoked ones.
+func publishedJWKs(keys []Key) ([]JWK, error) {
+	// Never nil, so a ring without published keys serves {"keys":[]}.
+	jwks := []JWK{}
+	for _, key := range keys {
+		if key.Status == StatusRevoked {
+			continue
+		}
+		jwk, err := publicJWK(key.Public())
+		if err != nil {
+			return nil, err
+		}
+		jwk.Use = "sig"
+		jwk.Kid = key.Kid
+		jwk.Alg = key.Alg
+		if len(key.Certificates) > 0 {
+			jwk.X5c, jwk.X5tS256 = certificateMembers(key.Certificates)
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks, nil
+}
+
+// publicJWK returns the key type and key parameters of pub as a JWK.
+func publicJWK(pub crypto.PublicKey) (JWK, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		if _, ok := curveAlgorithms[pub.Curve]; !ok {
+			return JWK{}, fmt.Errorf("unsupported EC curve %s", pub.Curve.Params().Name)
+		}
+		// Coordinates are padded to the full size of the curve (RFC 7518, section 6.2.1.2).
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			Kty: "EC",
+			Crv: pub.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}, nil
+	default:
+		return JWK{}, fmt.Errorf("unsupported key type %T", pub)
+	}
+}
 
-	eBytes := big.NewInt(int64(PublicKey.E)).Bytes()
-	e := base64.RawURLEncoding.EncodeToString(eBytes)
+// SetPublicKeyPolicy restricts the keys JWK.PublicKey accepts, e.g. to those
+// a crypto policy approves: RSA keys need at least minRSABits, and every key
+// has to be able to sign with one of algs. No algs allows every algorithm.
+func SetPublicKeyPolicy(minRSABits int, algs []string) {
+	publicKeyPolicyMu.Lock()
+	defer publicKeyPolicyMu.Unlock()
+	minPublicRSABits = max(minRSABits, MinRSAKeyBits)
+	publicAlgorithms = slices.Clone(algs)
+}
 
-	jwk := JWK{
-		Kty: "RSA",
-		Use: "sig",
-		Kid: "default", //this is for demo, originally it will be auto generated
-		Alg: "RS256",
-		N:   n,
-		E:   e,
+// checkPublicKeyPolicy returns an error if pub is not allowed by SetPublicKeyPolicy.
+func checkPublicKeyPolicy(pub crypto.PublicKey) error {
+	publicKeyPolicyMu.RLock()
+	defer publicKeyPolicyMu.RUnlock()
+	if pub, ok := pub.(*rsa.PublicKey); ok {
+		if bits := pub.N.BitLen(); bits < minPublicRSABits {
+			return fmt.Errorf("RSA key is too weak: %d bits, need at least %d", bits, minPublicRSABits)
+		}
+	}
+	if len(publicAlgorithms) > 0 && !slices.ContainsFunc(publicAlgorithms, func(alg string) bool { return CheckAlgorithm(pub, alg) == nil }) {
+		return fmt.Errorf("key cannot sign with any of the allowed algorithms %v", publicAlgorithms)
 	}
+	return nil
+}
 
-	return map[string]any{
-		"keys": []JWK{jwk},
-	}, nil
+// PublicKey returns the public key described by the JWK, e.g. one a client
+// registered. RSA keys need at least MinRSAKeyBits, EC keys a supported curve,
+// and the key has to be allowed by SetPublicKeyPolicy.
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	pub, err := j.publicKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPublicKeyPolicy(pub); err != nil {
+		return nil, err
+	}
+	return pub, nil
+}
+
+func (j JWK) publicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil || len(n) == 0 {
+			return nil, errors.New("invalid RSA modulus")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		for c := range curveAlgorithms {
+			if c.Params().Name == j.Crv {
+				curve = c
+			}
+		}
+		if curve == nil {
+			return nil, fmt.Errorf("unsupported EC curve %q", j.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(j.X)
+		y, errY := base64.RawURLEncoding.DecodeString(j.Y)
+		if errX != nil || errY != nil {
+			return nil, errors.New("invalid EC coordinates")
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		// Converting to ECDH rejects points that are not on the curve.
+		if _, err := pub.ECDH(); err != nil {
+			return nil, fmt.Errorf("invalid EC key: %w", err)
+		}
+		return pub, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", j.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
 }
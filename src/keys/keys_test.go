@@ -1,27 +1,41 @@
 package keys
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
 	"testing"
+	"time"
 )
 
 func TestInitializeKeys(t *testing.T) {
-	InitializeKeys()
+	ring := InitializeKeys()
 
-	if PrivateKey == nil {
-		t.Fatal("Expected PrivateKey to be initialized, got nil")
+	if ring == nil {
+		t.Fatal("Expected the key ring to be initialized, got nil")
 	}
-	if PublicKey == nil {
-		t.Fatal("Expected PublicKey to be initialized, got nil")
+	key, err := ring.ActiveKey("")
+	if err != nil {
+		t.Fatalf("Expected an active key, got error: %v", err)
+	}
+	if key.Signer == nil {
+		t.Fatal("Expected the active key to hold a private key, got nil")
 	}
 }
 
 func TestExportPublicKeyPEM(t *testing.T) {
-	InitializeKeys()
+	ring := InitializeKeys()
+	active, _ := ring.ActiveKey("")
 
-	pemData := ExportPublicKeyPEM()
+	pemData, err := ring.ExportPublicKeyPEM(active.Kid)
+	if err != nil {
+		t.Fatalf("Unexpected error exporting key: %v", err)
+	}
 	if len(pemData) == 0 {
 		t.Fatal("Expected non-empty PEM data, got empty")
 	}
@@ -41,23 +55,63 @@ func TestExportPublicKeyPEM(t *testing.T) {
 	if pubKey == nil {
 		t.Fatalf("Expected valid public key, got nil")
 	}
+
+	if _, err := ring.ExportPublicKeyPEM("unknown"); err == nil {
+		t.Error("Expected an error for an unknown kid, but got nil")
+	}
+	var nilRing *KeyRing
+	if _, err := nilRing.ExportPublicKeyPEM(""); err == nil {
+		t.Error("Expected an error when key ring is nil, but got nil error")
+	}
 }
-func TestGetJWK_NilPublicKey(t *testing.T) {
-	PublicKey = nil
 
-	jwkMap, err := GetJWK()
+func TestExportPublicKeyPEM_AllKeyTypes(t *testing.T) {
+	provider := NewMemoryProvider(nil, KeyPolicy{})
+	ring, err := NewKeyRing(provider, Options{
+		AdditionalKeyFiles: map[string]string{"ES384": "", "EdDSA": ""},
+	}, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to create key ring: %v", err)
+	}
+
+	pemData, err := ring.ExportPublicKeyPEM("")
+	if err != nil {
+		t.Fatalf("Unexpected error exporting keys: %v", err)
+	}
+	var types []string
+	for rest := pemData; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			t.Fatalf("Failed to parse public key: %v", err)
+		}
+		alg, _ := Algorithm(pub)
+		types = append(types, alg)
+	}
+	if len(types) != 3 {
+		t.Errorf("Expected an RSA, an EC and an Ed25519 key, got %v", types)
+	}
+}
+
+func TestGetJWK_NilRing(t *testing.T) {
+	var ring *KeyRing
+
+	jwkMap, err := ring.GetJWK()
 	if err == nil {
-		t.Error("Expected error when public key is nil, but got nil error")
+		t.Error("Expected error when key ring is nil, but got nil error")
 	}
 	if jwkMap != nil {
-		t.Errorf("Expected returned map to be nil when public key is nil, but got: %v", jwkMap)
+		t.Errorf("Expected returned map to be nil when key ring is nil, but got: %v", jwkMap)
 	}
 }
 
 func TestGetJWK_Valid(t *testing.T) {
-	InitializeKeys()
+	ring := InitializeKeys()
 
-	jwkMap, err := GetJWK()
+	jwkMap, err := ring.GetJWK()
 	if err != nil {
 		t.Fatalf("Unexpected error when getting JWK: %v", err)
 	}
@@ -82,8 +136,16 @@ func TestGetJWK_Valid(t *testing.T) {
 	if jwk.Use != "sig" {
 		t.Errorf("Expected Use to be 'sig', got '%s'", jwk.Use)
 	}
-	if jwk.Kid != "default" {
-		t.Errorf("Expected Kid to be 'default', got '%s'", jwk.Kid)
+	active, err := ring.ActiveKey("")
+	if err != nil {
+		t.Fatalf("Unexpected error getting active key: %v", err)
+	}
+	thumbprint, err := Thumbprint(active.Public())
+	if err != nil {
+		t.Fatalf("Unexpected error computing thumbprint: %v", err)
+	}
+	if jwk.Kid != thumbprint {
+		t.Errorf("Expected Kid to be the JWK thumbprint '%s', got '%s'", thumbprint, jwk.Kid)
 	}
 	if jwk.Alg != "RS256" {
 		t.Errorf("Expected Alg to be 'RS256', got '%s'", jwk.Alg)
@@ -102,3 +164,138 @@ func TestGetJWK_Valid(t *testing.T) {
 		t.Errorf("Failed to decode exponent E: %v", err)
 	}
 }
+
+func TestGetJWK_EC(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	ring, err := NewKeyRing(NewMemoryProvider(map[string]crypto.Signer{"ES256": key}, KeyPolicy{}), Options{Algorithm: "ES256"}, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to create key ring: %v", err)
+	}
+
+	jwkMap, err := ring.GetJWK()
+	if err != nil {
+		t.Fatalf("Unexpected error when getting JWK: %v", err)
+	}
+	jwk := jwkMap["keys"].([]JWK)[0]
+
+	if jwk.Kty != "EC" {
+		t.Errorf("Expected Kty to be 'EC', got '%s'", jwk.Kty)
+	}
+	if jwk.Alg != "ES256" {
+		t.Errorf("Expected Alg to be 'ES256', got '%s'", jwk.Alg)
+	}
+	if jwk.Crv != "P-256" {
+		t.Errorf("Expected Crv to be 'P-256', got '%s'", jwk.Crv)
+	}
+	if jwk.N != "" || jwk.E != "" {
+		t.Error("Expected no RSA parameters on an EC key")
+	}
+	for name, value := range map[string]string{"x": jwk.X, "y": jwk.Y} {
+		decoded, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			t.Errorf("Failed to decode %s: %v", name, err)
+		}
+		if len(decoded) != 32 {
+			t.Errorf("Expected %s to be 32 bytes, got %d", name, len(decoded))
+		}
+	}
+}
+
+func TestGetJWK_Ed25519(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+	ring, err := NewKeyRing(NewMemoryProvider(map[string]crypto.Signer{"EdDSA": key}, KeyPolicy{}), Options{Algorithm: "EdDSA"}, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to create key ring: %v", err)
+	}
+
+	jwkMap, err := ring.GetJWK()
+	if err != nil {
+		t.Fatalf("Unexpected error when getting JWK: %v", err)
+	}
+	jwk := jwkMap["keys"].([]JWK)[0]
+
+	if jwk.Kty != "OKP" {
+		t.Errorf("Expected Kty to be 'OKP', got '%s'", jwk.Kty)
+	}
+	if jwk.Crv != "Ed25519" {
+		t.Errorf("Expected Crv to be 'Ed25519', got '%s'", jwk.Crv)
+	}
+	if jwk.Alg != "EdDSA" {
+		t.Errorf("Expected Alg to be 'EdDSA', got '%s'", jwk.Alg)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil || len(x) != ed25519.PublicKeySize {
+		t.Errorf("Expected x to be a %d byte public key, got %q", ed25519.PublicKeySize, jwk.X)
+	}
+	if jwk.Y != "" {
+		t.Error("Expected no y coordinate on an OKP key")
+	}
+}
+
+func TestJWK_PublicKey(t *testing.T) {
+	for _, alg := range []string{"RS256", "ES384", "EdDSA"} {
+		key, err := GenerateKey(alg)
+		if err != nil {
+			t.Fatalf("Failed to generate %s key: %v", alg, err)
+		}
+		jwk, err := publicJWK(key.Public())
+		if err != nil {
+			t.Fatalf("Failed to encode %s key: %v", alg, err)
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatalf("Failed to decode %s JWK: %v", alg, err)
+		}
+		if !pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()) {
+			t.Errorf("Expected the %s JWK to decode to the original key", alg)
+		}
+	}
+
+	key, _ := GenerateKey("ES256")
+	jwk, _ := publicJWK(key.Public())
+	jwk.Y = jwk.X
+	if _, err := jwk.PublicKey(); err == nil {
+		t.Error("Expected a point that is not on the curve to be rejected")
+	}
+	if _, err := (JWK{Kty: "oct"}).PublicKey(); err == nil {
+		t.Error("Expected a symmetric key to be rejected")
+	}
+}
+
+func TestJWK_PublicKeyPolicy(t *testing.T) {
+	SetPublicKeyPolicy(3072, []string{"RS256", "PS256", "ES256", "ES384"})
+	defer SetPublicKeyPolicy(0, nil)
+
+	rsa3072, err := KeyPolicy{RSABits: 3072}.GenerateKey("RS256")
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	allowed := map[string]bool{"RS256": false, "ES256": true, "ES512": false, "EdDSA": false}
+	for alg, expected := range allowed {
+		key, err := GenerateKey(alg)
+		if err != nil {
+			t.Fatalf("Failed to generate %s key: %v", alg, err)
+		}
+		jwk, _ := publicJWK(key.Public())
+		if _, err := jwk.PublicKey(); (err == nil) != expected {
+			t.Errorf("Expected a %s key to be accepted: %v, got %v", alg, expected, err)
+		}
+	}
+	jwk, _ := publicJWK(rsa3072.Public())
+	if _, err := jwk.PublicKey(); err != nil {
+		t.Errorf("Expected a 3072 bit RSA key to be accepted, got %v", err)
+	}
+
+	SetPublicKeyPolicy(0, nil)
+	key, _ := GenerateKey("EdDSA")
+	jwk, _ = publicJWK(key.Public())
+	if _, err := jwk.PublicKey(); err != nil {
+		t.Errorf("Expected an EdDSA key to be accepted without a policy, got %v", err)
+	}
+}
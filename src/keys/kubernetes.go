@@ -0,0 +1,374 @@
+package keys
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serviceAccountDir is where Kubernetes mounts the credentials of the pod's service account.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+const (
+	// secretLockOwner is the annotation naming the instance that changes the ring.
+	secretLockOwner = "oauth2-server/lock-owner"
+	// secretLockedAt is the annotation with the time the lock was taken.
+	secretLockedAt = "oauth2-server/locked-at"
+)
+
+// SecretOptions configures keeping the keys in a Kubernetes Secret. Only Name
+// is needed inside a cluster, everything else defaults to the pod's service
+// account.
+type SecretOptions struct {
+	// Name is the name of the Secret.
+	Name string
+	// Namespace is the namespace of the Secret, the service account's if empty.
+	Namespace string
+	// APIServer is the base URL of the Kubernetes API, the in-cluster address
+	// from KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT if empty.
+	APIServer string
+	// TokenFile holds the bearer token, the service account token if empty.
+	// It is read for every request, as Kubernetes rotates the token.
+	TokenFile string
+	// CAFile verifies the API server, the service account's CA if empty.
+	CAFile string
+}
+
+// SecretStore is a KeyStore in a Kubernetes Secret, read and updated through
+// the Kubernetes API. The Secret holds the same entries as a DirectoryStore:
+// ring.json, <kid>.pem and <kid>.crt. The lock is a pair of annotations on
+// the Secret, taken with the Secret's resourceVersion as precondition so only
+// one instance gets it.
+type SecretStore struct {
+	url        string
+	collection string
+	name       string
+	namespace  string
+	tokenFile  string
+	passphrase []byte
+	owner      string
+	client     *http.Client
+
+	mu      sync.Mutex
+	signers map[string]crypto.Signer
+}
+
+// NewSecretStore creates a store in the Secret described by opts. Private
+// keys are encrypted with passphrase unless it is empty.
+func NewSecretStore(opts SecretOptions, passphrase []byte) (*SecretStore, error) {
+	if opts.Name == "" {
+		return nil, errors.New("no key Secret configured")
+	}
+	if opts.APIServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("no Kubernetes API server configured and not running in a cluster")
+		}
+		opts.APIServer = "https://" + net.JoinHostPort(host, port)
+	}
+	if opts.TokenFile == "" {
+		opts.TokenFile = filepath.Join(serviceAccountDir, "token")
+	}
+	if opts.Namespace == "" {
+		namespace, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("reading service account namespace: %w", err)
+		}
+		opts.Namespace = strings.TrimSpace(string(namespace))
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if strings.HasPrefix(opts.APIServer, "https://") {
+		if opts.CAFile == "" {
+			opts.CAFile = filepath.Join(serviceAccountDir, "ca.crt")
+		}
+		ca, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading Kubernetes CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	collection := strings.TrimRight(opts.APIServer, "/") + "/api/v1/namespaces/" + url.PathEscape(opts.Namespace) + "/secrets"
+	return &SecretStore{
+		url:        collection + "/" + url.PathEscape(opts.Name),
+		collection: collection,
+		name:       opts.Name,
+		namespace:  opts.Namespace,
+		tokenFile:  opts.TokenFile,
+		passphrase: passphrase,
+		owner:      lockOwner(),
+		client:     &http.Client{Timeout: 10 * time.Second, Transport: transport},
+		signers:    map[string]crypto.Signer{},
+	}, nil
+}
+
+// secret is the part of a Kubernetes Secret the store uses.
+type secret struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   secretMetadata    `json:"metadata"`
+	Type       string            `json:"type,omitempty"`
+	Data       map[string][]byte `json:"data,omitempty"`
+}
+
+type secretMetadata struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+}
+
+// apiStatus is the error a Kubernetes API server responds with.
+type apiStatus struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (e *apiStatus) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Kubernetes API: %d %s", e.Code, http.StatusText(e.Code))
+	}
+	return fmt.Sprintf("Kubernetes API: %d %s", e.Code, e.Message)
+}
+
+// hasStatus reports whether err is a Kubernetes API error with status code.
+func hasStatus(err error, code int) bool {
+	var status *apiStatus
+	return errors.As(err, &status) && status.Code == code
+}
+
+func (s *SecretStore) Load() ([]Key, string, error) {
+	sec, err := s.get()
+	if hasStatus(err, http.StatusNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	ring, ok := sec.Data[storedRingFile]
+	if !ok {
+		return nil, "", nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, err := loadStoredKeys(ring, s.passphrase, s.signers, func(name string) ([]byte, error) {
+		data, ok := sec.Data[name]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("key Secret %s/%s: %w", s.namespace, s.name, err)
+	}
+	return keys, dataVersion(ring), nil
+}
+
+// Version is derived from ring.json rather than the resourceVersion, which
+// also changes with the lock.
+func (s *SecretStore) Version() (string, error) {
+	sec, err := s.get()
+	if hasStatus(err, http.StatusNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	ring, ok := sec.Data[storedRingFile]
+	if !ok {
+		return "", nil
+	}
+	return dataVersion(ring), nil
+}
+
+// Save updates the entries of the Secret in one request, which only succeeds
+// while this instance still holds the lock.
+func (s *SecretStore) Save(keys []Key) (string, error) {
+	sec, err := s.get()
+	if err != nil {
+		return "", err
+	}
+	if sec.Metadata.Annotations[secretLockOwner] != s.owner {
+		return "", errors.New("lock of the key Secret has been taken over by another instance")
+	}
+	files, kept, err := storedKeyFiles(keys, s.passphrase, func(name string) ([]byte, bool) {
+		data, ok := sec.Data[name]
+		return data, ok
+	})
+	if err != nil {
+		return "", err
+	}
+	ring, err := marshalRing(keys)
+	if err != nil {
+		return "", err
+	}
+
+	// A JSON merge patch removes entries set to null.
+	data := map[string]interface{}{storedRingFile: ring}
+	for name, file := range files {
+		data[name] = file
+	}
+	for name := range sec.Data {
+		if isKeyFile(name) && !kept[name] {
+			data[name] = nil
+		}
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{"resourceVersion": sec.Metadata.ResourceVersion},
+		"data":     data,
+	}
+	if err := s.do(http.MethodPatch, s.url, patch, nil); err != nil {
+		return "", fmt.Errorf("updating key Secret %s/%s: %w", s.namespace, s.name, err)
+	}
+	return dataVersion(ring), nil
+}
+
+// Lock annotates the Secret as locked by this instance, creating it if it does
+// not exist yet. A lock older than staleLockAge was left behind by a crashed
+// instance and is taken over.
+func (s *SecretStore) Lock() error {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		err := s.tryLock()
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrLocked) && !hasStatus(err, http.StatusConflict) {
+			return fmt.Errorf("locking key Secret %s/%s: %w", s.namespace, s.name, err)
+		}
+		if time.Now().After(deadline) {
+			return ErrLocked
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// tryLock takes the lock once. It fails with ErrLocked if another instance
+// holds it, or with a conflict if the Secret changed in the meantime.
+func (s *SecretStore) tryLock() error {
+	annotations := map[string]string{
+		secretLockOwner: s.owner,
+		secretLockedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+	sec, err := s.get()
+	if hasStatus(err, http.StatusNotFound) {
+		created := secret{
+			APIVersion: "v1",
+			Kind:       "Secret",
+			Metadata:   secretMetadata{Name: s.name, Namespace: s.namespace, Annotations: annotations},
+			Type:       "Opaque",
+		}
+		return s.do(http.MethodPost, s.collection, created, nil)
+	}
+	if err != nil {
+		return err
+	}
+	if owner := sec.Metadata.Annotations[secretLockOwner]; owner != "" && owner != s.owner {
+		lockedAt, err := time.Parse(time.RFC3339, sec.Metadata.Annotations[secretLockedAt])
+		if err != nil || time.Since(lockedAt) <= staleLockAge {
+			return ErrLocked
+		}
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": sec.Metadata.ResourceVersion,
+			"annotations":     annotations,
+		},
+	}
+	return s.do(http.MethodPatch, s.url, patch, nil)
+}
+
+// Unlock removes the lock annotations unless another instance has taken the lock over.
+func (s *SecretStore) Unlock() error {
+	sec, err := s.get()
+	if err != nil {
+		return err
+	}
+	if sec.Metadata.Annotations[secretLockOwner] != s.owner {
+		return errors.New("lock of the key Secret has been taken over by another instance")
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": sec.Metadata.ResourceVersion,
+			"annotations":     map[string]interface{}{secretLockOwner: nil, secretLockedAt: nil},
+		},
+	}
+	return s.do(http.MethodPatch, s.url, patch, nil)
+}
+
+func (s *SecretStore) get() (*secret, error) {
+	var sec secret
+	if err := s.do(http.MethodGet, s.url, nil, &sec); err != nil {
+		return nil, err
+	}
+	return &sec, nil
+}
+
+// do calls the Kubernetes API with the service account token and decodes the
+// JSON response into out. PATCH bodies are sent as JSON merge patches.
+func (s *SecretStore) do(method, target string, body, out interface{}) error {
+	token, err := os.ReadFile(s.tokenFile)
+	if err != nil {
+		return fmt.Errorf("reading service account token: %w", err)
+	}
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, target, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	switch {
+	case method == http.MethodPatch:
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+	case body != nil:
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		status := &apiStatus{}
+		json.Unmarshal(data, status)
+		status.Code = resp.StatusCode
+		return status
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
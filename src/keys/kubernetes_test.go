@@ -0,0 +1,138 @@
+package keys
+
+import (
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"oauth-basic/src/keys/kubetest"
+)
+
+func newKubernetesServer(t *testing.T) (*kubetest.Server, SecretOptions) {
+	t.Helper()
+	server := kubetest.NewServer("test-token")
+	t.Cleanup(server.Close)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte(server.Token+"\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write token: %v", err)
+	}
+	return server, SecretOptions{Name: "signing-keys", Namespace: "oauth2", APIServer: server.URL, TokenFile: tokenFile}
+}
+
+func TestLoadKeys_KeySecretShared(t *testing.T) {
+	server, secret := newKubernetesServer(t)
+	opts := Options{
+		KeySecret:        &secret,
+		Passphrase:       []byte("test-passphrase"),
+		RotationInterval: 24 * time.Hour,
+		PrePublish:       2 * time.Hour,
+	}
+	first, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+	second, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Failed to load keys of a second instance: %v", err)
+	}
+	active, _ := first.ActiveKey("")
+	if other, _ := second.ActiveKey(""); other.Kid != active.Kid {
+		t.Errorf("Expected both instances to sign with %s, got %s", active.Kid, other.Kid)
+	}
+
+	data := server.SecretData("oauth2", "signing-keys")
+	if _, ok := data["ring.json"]; !ok {
+		t.Error("Expected the key ring to be stored in the Secret")
+	}
+	if block, _ := pem.Decode(data[active.Kid+".pem"]); block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		t.Error("Expected the private key to be stored encrypted in the Secret")
+	}
+
+	// A rotation by one instance is picked up by the other.
+	if err := first.Rotate(time.Now().Add(22 * time.Hour)); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	if err := second.Sync(time.Now()); err != nil {
+		t.Fatalf("Unexpected sync error: %v", err)
+	}
+	if len(second.Keys()) != 2 {
+		t.Errorf("Expected the pending key to be published by the other instance, got %d keys", len(second.Keys()))
+	}
+	if _, err := second.Revoke(active.Kid, time.Now()); err != nil {
+		t.Fatalf("Unexpected error revoking the active key: %v", err)
+	}
+	if err := first.Sync(time.Now()); err != nil {
+		t.Fatalf("Unexpected sync error: %v", err)
+	}
+	if _, err := first.Lookup(active.Kid); !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("Expected the other instance to reject the revoked key, got %v", err)
+	}
+
+	if annotations := server.SecretAnnotations("oauth2", "signing-keys"); annotations[secretLockOwner] != "" {
+		t.Errorf("Expected the lock to be released, got owner %q", annotations[secretLockOwner])
+	}
+}
+
+func TestRevokeKey_KeySecret(t *testing.T) {
+	_, secret := newKubernetesServer(t)
+	opts := Options{KeySecret: &secret}
+	ring, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+	leaked, _ := ring.ActiveKey("")
+
+	if err := RevokeKey(opts, leaked.Kid, time.Now()); err != nil {
+		t.Fatalf("Unexpected error revoking a key in the Secret: %v", err)
+	}
+	if err := ring.Sync(time.Now()); err != nil {
+		t.Fatalf("Unexpected sync error: %v", err)
+	}
+	if _, err := ring.Lookup(leaked.Kid); !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("Expected the running instance to reject the revoked key, got %v", err)
+	}
+	if active, err := ring.ActiveKey(""); err != nil || active.Kid == leaked.Kid {
+		t.Errorf("Expected a replacement key to be active, got %v", err)
+	}
+
+	if err := RevokeKey(opts, "unknown", time.Now()); err == nil {
+		t.Error("Expected an error for a key that is not in the Secret")
+	}
+}
+
+func TestSecretStore_Lock(t *testing.T) {
+	defer func(timeout time.Duration) { lockTimeout = timeout }(lockTimeout)
+	lockTimeout = 200 * time.Millisecond
+
+	_, opts := newKubernetesServer(t)
+	first, _ := NewSecretStore(opts, nil)
+	second, _ := NewSecretStore(opts, nil)
+	if err := first.Lock(); err != nil {
+		t.Fatalf("Failed to take the lock: %v", err)
+	}
+	if err := second.Lock(); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked while another instance holds the lock, got %v", err)
+	}
+	if _, err := second.Save(nil); err == nil {
+		t.Error("Expected saving without holding the lock to fail")
+	}
+	if err := first.Unlock(); err != nil {
+		t.Fatalf("Failed to release the lock: %v", err)
+	}
+	if err := second.Lock(); err != nil {
+		t.Errorf("Expected the lock to be free again: %v", err)
+	}
+}
+
+func TestLoadKeys_KeySecretUnauthorized(t *testing.T) {
+	_, opts := newKubernetesServer(t)
+	if err := os.WriteFile(opts.TokenFile, []byte("wrong-token"), 0o600); err != nil {
+		t.Fatalf("Failed to write token: %v", err)
+	}
+	if _, err := LoadKeys(Options{KeySecret: &opts}); !hasStatus(err, 401) {
+		t.Errorf("Expected the API server to reject the token, got %v", err)
+	}
+}
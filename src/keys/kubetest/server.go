@@ -0,0 +1,195 @@
+// Package kubetest provides a fake Kubernetes API server for Secrets, so the
+// Secret key store can be tested without a cluster.
+//
+// It implements the parts of the API the server uses: reading a Secret
+// (GET /api/v1/namespaces/<namespace>/secrets/<name>), creating one
+// (POST /api/v1/namespaces/<namespace>/secrets) and updating it with a JSON
+// merge patch (PATCH /api/v1/namespaces/<namespace>/secrets/<name>). Like a
+// real API server it rejects a patch whose metadata.resourceVersion is not
+// the current one with 409 Conflict.
+package kubetest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+)
+
+// Server is a fake Kubernetes API server.
+type Server struct {
+	*httptest.Server
+	// Token is the only bearer token the server accepts.
+	Token string
+
+	mu      sync.Mutex
+	secrets map[string]map[string]interface{}
+	version int
+}
+
+// NewServer starts a fake Kubernetes API server accepting token.
+func NewServer(token string) *Server {
+	s := &Server{Token: token, secrets: map[string]map[string]interface{}{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/namespaces/{namespace}/secrets/{name}", s.getSecret)
+	mux.HandleFunc("POST /api/v1/namespaces/{namespace}/secrets", s.createSecret)
+	mux.HandleFunc("PATCH /api/v1/namespaces/{namespace}/secrets/{name}", s.patchSecret)
+	s.Server = httptest.NewServer(s.authenticate(mux))
+	return s
+}
+
+// SecretData returns the decoded data of a Secret, nil if it does not exist.
+func (s *Server) SecretData(namespace, name string) map[string][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret := s.secrets[namespace+"/"+name]
+	if secret == nil {
+		return nil
+	}
+	data := map[string][]byte{}
+	entries, _ := secret["data"].(map[string]interface{})
+	for key, value := range entries {
+		encoded, _ := value.(string)
+		data[key], _ = base64.StdEncoding.DecodeString(encoded)
+	}
+	return data
+}
+
+// SecretAnnotations returns the annotations of a Secret, nil if it does not exist.
+func (s *Server) SecretAnnotations(namespace, name string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret := s.secrets[namespace+"/"+name]
+	if secret == nil {
+		return nil
+	}
+	annotations := map[string]string{}
+	entries, _ := secret["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+	for key, value := range entries {
+		annotations[key], _ = value.(string)
+	}
+	return annotations
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+s.Token {
+			writeStatus(w, http.StatusUnauthorized, "Unauthorized", "Unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) getSecret(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret := s.secrets[r.PathValue("namespace")+"/"+r.PathValue("name")]
+	if secret == nil {
+		writeStatus(w, http.StatusNotFound, "NotFound", `secrets "`+r.PathValue("name")+`" not found`)
+		return
+	}
+	writeJSON(w, http.StatusOK, secret)
+}
+
+func (s *Server) createSecret(w http.ResponseWriter, r *http.Request) {
+	var secret map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&secret); err != nil {
+		writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+	metadata, _ := secret["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	if name == "" {
+		writeStatus(w, http.StatusUnprocessableEntity, "Invalid", "metadata.name: Required value")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := r.PathValue("namespace") + "/" + name
+	if s.secrets[key] != nil {
+		writeStatus(w, http.StatusConflict, "AlreadyExists", `secrets "`+name+`" already exists`)
+		return
+	}
+	metadata["namespace"] = r.PathValue("namespace")
+	s.store(key, secret)
+	writeJSON(w, http.StatusCreated, secret)
+}
+
+func (s *Server) patchSecret(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+		writeStatus(w, http.StatusUnsupportedMediaType, "UnsupportedMediaType", "only JSON merge patches are supported")
+		return
+	}
+	var patch map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := r.PathValue("namespace") + "/" + r.PathValue("name")
+	secret := s.secrets[key]
+	if secret == nil {
+		writeStatus(w, http.StatusNotFound, "NotFound", `secrets "`+r.PathValue("name")+`" not found`)
+		return
+	}
+	current := secret["metadata"].(map[string]interface{})["resourceVersion"]
+	if metadata, ok := patch["metadata"].(map[string]interface{}); ok {
+		if version, ok := metadata["resourceVersion"]; ok && version != current {
+			writeStatus(w, http.StatusConflict, "Conflict", "the object has been modified; please apply your changes to the latest version and try again")
+			return
+		}
+	}
+	secret = mergePatch(secret, patch).(map[string]interface{})
+	s.store(key, secret)
+	writeJSON(w, http.StatusOK, secret)
+}
+
+// store saves secret with a new resourceVersion. s.mu must be held.
+func (s *Server) store(key string, secret map[string]interface{}) {
+	s.version++
+	secret["metadata"].(map[string]interface{})["resourceVersion"] = strconv.Itoa(s.version)
+	s.secrets[key] = secret
+}
+
+// mergePatch applies an RFC 7386 JSON merge patch to target.
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = mergePatch(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason, message string) {
+	writeJSON(w, code, map[string]interface{}{
+		"kind":       "Status",
+		"apiVersion": "v1",
+		"status":     "Failure",
+		"message":    message,
+		"reason":     reason,
+		"code":       code,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
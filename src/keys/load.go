@@ -0,0 +1,336 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// MinRSAKeyBits is the smallest RSA modulus accepted for a signing key.
+const MinRSAKeyBits = 2048
+
+// Options describes where the signing keys are loaded from and how they are rotated.
+type Options struct {
+	// KeyFile is the PEM file (PKCS#1, SEC 1, PKCS#8 or encrypted PKCS#8) holding
+	// the private signing key, usually a mounted Kubernetes secret.
+	KeyFile string
+	// Algorithm is the JWS algorithm to sign with, DefaultAlgorithm if empty.
+	// It decides which kind of key is generated; a loaded key has to match it.
+	// RSA keys can be used with RS256 or PS256/PS384/PS512.
+	Algorithm string
+	// AdditionalKeyFiles maps further JWS algorithms to the key files they sign
+	// with, so several algorithms can be active at once, e.g. while clients
+	// migrate from RS256 to ES256. Clients pick one via their token_signing_alg.
+	AdditionalKeyFiles map[string]string
+	// GenerateKey allows creating and persisting missing key files.
+	// It is meant as an explicit fallback, e.g. for local development.
+	GenerateKey bool
+	// Passphrase decrypts encrypted PKCS#8 key files. When it is set, every key
+	// file has to be encrypted and generated keys are written encrypted.
+	Passphrase []byte
+	// CertificateFiles are PEM files with X.509 certificate chains for the
+	// signing keys, each matched to its key by the public key. The chains are
+	// published as x5c and x5t#S256 in the JWKS.
+	CertificateFiles []string
+	// SelfIssueCertificates publishes a self-signed certificate for keys without
+	// a loaded certificate chain, including rotated keys.
+	SelfIssueCertificates bool
+	// Transit, if set, signs with keys of a Vault Transit compatible backend
+	// instead of the key files.
+	Transit *TransitOptions
+	// KeyDirectory, if set, keeps the keys in a directory shared by all
+	// instances instead of the key files, see DirectoryStore. RevocationFile
+	// defaults to revoked.json in it.
+	KeyDirectory string
+	// KeySecret, if set, keeps the keys in a Kubernetes Secret shared by all
+	// instances instead of the key files, see SecretStore.
+	KeySecret *SecretOptions
+	// AdditionalAlgorithms are further algorithms with their own active key
+	// when keys are kept in KeyDirectory or KeySecret.
+	AdditionalAlgorithms []string
+	// RevocationFile is a JSON file listing revoked keys. Revocations are
+	// written to it, so they survive restarts, and keys listed in it are never
+	// loaded or published again. It has to be on persistent, writable storage.
+	// It defaults to revoked.json next to KeyFile or in KeyDirectory; with
+	// Transit signing, keys can only be revoked if it is set.
+	RevocationFile string
+
+	// RotationInterval is how long a key signs tokens before the next one takes
+	// over. Zero disables rotation.
+	RotationInterval time.Duration
+	// PrePublish is how long the next key is published in the JWKS before it
+	// starts signing. It defaults to half the rotation interval, at most
+	// DefaultPrePublish. With Transit it applies to the versions of the
+	// Transit keys and defaults to DefaultPrePublish.
+	PrePublish time.Duration
+	// TokenLifetime is the longest lifetime of an issued token. Retired keys stay
+	// published for this long.
+	TokenLifetime time.Duration
+
+	// Policy decides the type and size of generated keys and the minimum
+	// strength of every key in the ring.
+	Policy KeyPolicy
+}
+
+// LoadKeys creates a key ring from the key files, shared key storage or
+// Transit keys of opts. Missing files are only generated with GenerateKey.
+func LoadKeys(opts Options) (*KeyRing, error) {
+	if err := opts.Policy.Validate(); err != nil {
+		return nil, fmt.Errorf("key policy: %w", err)
+	}
+	alg, err := opts.Policy.Algorithm(opts.Algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("key policy: %w", err)
+	}
+	opts.Algorithm = alg
+	opts.RevocationFile = opts.revocationPath()
+
+	if opts.Transit != nil {
+		if opts.KeyDirectory != "" || opts.KeySecret != nil {
+			return nil, errors.New("Transit signing and shared key storage cannot be used together")
+		}
+		if opts.Transit.Key == "" {
+			return nil, errors.New("no Transit signing key configured")
+		}
+		if opts.RotationInterval != 0 {
+			// Every instance would rotate the Transit key on its own.
+			return nil, errors.New("key rotation cannot be used with Transit signing, rotate the Transit key in the backend instead")
+		}
+		keys, err := keysPerAlgorithm(opts.Algorithm, opts.Transit.Key, opts.Transit.AdditionalKeys)
+		if err != nil {
+			return nil, err
+		}
+		provider, err := NewTransitProvider(*opts.Transit, keys)
+		if err != nil {
+			return nil, err
+		}
+		if opts.PrePublish != 0 {
+			if opts.PrePublish < DefaultJWKSMaxAge {
+				return nil, fmt.Errorf("key pre-publication period %s must be at least the JWKS max-age %s", opts.PrePublish, DefaultJWKSMaxAge)
+			}
+			provider.activationDelay = opts.PrePublish
+		}
+		return NewKeyRing(provider, opts, time.Now())
+	}
+
+	store, err := opts.sharedStore()
+	if err != nil {
+		return nil, err
+	}
+	if store != nil {
+		return NewSharedKeyRing(store, opts, time.Now())
+	}
+
+	if opts.KeyFile == "" {
+		return nil, errors.New("no signing key file configured")
+	}
+	files, err := keysPerAlgorithm(opts.Algorithm, opts.KeyFile, opts.AdditionalKeyFiles)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyRing(NewFileProvider(files, opts.Passphrase, opts.GenerateKey, opts.Policy), opts, time.Now())
+}
+
+// sharedStore returns the store of KeySecret or KeyDirectory, or nil if the
+// keys are not shared.
+func (o Options) sharedStore() (KeyStore, error) {
+	if o.KeySecret != nil {
+		if o.KeyFile != "" || o.KeyDirectory != "" {
+			return nil, errors.New("a key Secret cannot be used together with a signing key file or key directory")
+		}
+		return NewSecretStore(*o.KeySecret, o.Passphrase)
+	}
+	if o.KeyDirectory != "" {
+		if o.KeyFile != "" {
+			return nil, errors.New("a signing key file and a key directory cannot be used together")
+		}
+		return NewDirectoryStore(o.KeyDirectory, o.Passphrase)
+	}
+	return nil, nil
+}
+
+// keysPerAlgorithm merges the key for the default algorithm with the additional ones.
+func keysPerAlgorithm(alg, key string, additional map[string]string) (map[string]string, error) {
+	keys := map[string]string{alg: key}
+	for alg, key := range additional {
+		if _, ok := keys[alg]; ok {
+			return nil, fmt.Errorf("more than one signing key configured for %s", alg)
+		}
+		keys[alg] = key
+	}
+	return keys, nil
+}
+
+// revocationPath returns RevocationFile, or revoked.json in KeyDirectory or
+// next to KeyFile if it is not set. Keys in KeySecret are revoked in the
+// Secret, Transit keys have no place for a default.
+func (o Options) revocationPath() string {
+	switch {
+	case o.RevocationFile != "" || o.Transit != nil || o.KeySecret != nil:
+		return o.RevocationFile
+	case o.KeyDirectory != "":
+		return filepath.Join(o.KeyDirectory, "revoked.json")
+	case o.KeyFile != "":
+		return filepath.Join(filepath.Dir(o.KeyFile), "revoked.json")
+	}
+	return ""
+}
+
+// additionalAlgorithms returns the algorithms besides Algorithm that have their own key.
+func (o Options) additionalAlgorithms() []string {
+	additional := o.AdditionalKeyFiles
+	if o.Transit != nil {
+		additional = o.Transit.AdditionalKeys
+	} else if o.KeyDirectory != "" || o.KeySecret != nil {
+		return o.AdditionalAlgorithms
+	}
+	var algs []string
+	for alg := range additional {
+		algs = append(algs, alg)
+	}
+	sort.Strings(algs)
+	return algs
+}
+
+// LoadPrivateKeyFile reads and validates a PEM encoded RSA, EC or Ed25519 private key.
+// Encrypted keys are decrypted with passphrase.
+func LoadPrivateKeyFile(path string, passphrase []byte) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading signing key: %w", err)
+	}
+	key, err := ParsePrivateKeyPEM(data, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// ParsePrivateKeyPEM decodes a PKCS#1 ("RSA PRIVATE KEY"), SEC 1 ("EC PRIVATE KEY"),
+// PKCS#8 ("PRIVATE KEY") or encrypted PKCS#8 ("ENCRYPTED PRIVATE KEY") block and
+// checks that the key is usable for signing. With a passphrase, only encrypted
+// keys are accepted.
+func ParsePrivateKeyPEM(data, passphrase []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if _, ok := block.Headers["DEK-Info"]; ok {
+		return nil, errors.New("legacy PEM encryption is not supported, convert the key with `openssl pkcs8 -topk8 -v2 aes-256-cbc`")
+	}
+
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		if len(passphrase) == 0 {
+			return nil, errors.New("key is encrypted, but no passphrase is configured")
+		}
+		der, err := decryptPKCS8(block.Bytes, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := x509.ParsePKCS8PrivateKey(der); err != nil {
+			// The padding matched by chance, the content did not.
+			return nil, ErrWrongPassphrase
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	} else if len(passphrase) > 0 {
+		return nil, errors.New("key is not encrypted, but a passphrase is configured")
+	}
+
+	var key crypto.Signer
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing PKCS#1 key: %w", err)
+		}
+		key = k
+	case "EC PRIVATE KEY":
+		k, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing SEC 1 key: %w", err)
+		}
+		key = k
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing PKCS#8 key: %w", err)
+		}
+		switch k := k.(type) {
+		case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+			key = k.(crypto.Signer)
+		default:
+			return nil, fmt.Errorf("unsupported key type %T", k)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WritePrivateKeyFile persists key as PKCS#8 PEM readable only by the owner,
+// encrypted with passphrase unless it is empty. An existing file is never overwritten.
+func WritePrivateKeyFile(path string, key crypto.Signer, passphrase []byte) error {
+	data, err := MarshalPrivateKeyPEM(key, passphrase)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("creating key directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("writing signing key: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("writing signing key: %w", err)
+	}
+	return f.Close()
+}
+
+// MarshalPrivateKeyPEM encodes key as PKCS#8 PEM, encrypted with passphrase
+// unless it is empty.
+func MarshalPrivateKeyPEM(key crypto.Signer, passphrase []byte) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling signing key: %w", err)
+	}
+	block := &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	if len(passphrase) > 0 {
+		if block.Bytes, err = encryptPKCS8(der, passphrase); err != nil {
+			return nil, fmt.Errorf("encrypting signing key: %w", err)
+		}
+		block.Type = "ENCRYPTED PRIVATE KEY"
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+func validateKey(key crypto.Signer) error {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if bits := key.N.BitLen(); bits < MinRSAKeyBits {
+			return fmt.Errorf("RSA key is too weak: %d bits, need at least %d", bits, MinRSAKeyBits)
+		}
+		if err := key.Validate(); err != nil {
+			return fmt.Errorf("invalid RSA key: %w", err)
+		}
+	case *ecdsa.PrivateKey:
+		if _, ok := curveAlgorithms[key.Curve]; !ok {
+			return fmt.Errorf("unsupported EC curve %s", key.Curve.Params().Name)
+		}
+	}
+	return nil
+}
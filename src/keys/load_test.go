@@ -0,0 +1,294 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	return path
+}
+
+func TestLoadKeys_PKCS1(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	path := writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+
+	ring, err := LoadKeys(Options{KeyFile: path})
+	if err != nil {
+		t.Fatalf("Unexpected error loading PKCS#1 key: %v", err)
+	}
+	active, err := ring.ActiveKey("")
+	if err != nil {
+		t.Fatalf("Expected an active key after loading: %v", err)
+	}
+	if !key.Equal(active.Signer) {
+		t.Error("Expected the active key to match the key on disk")
+	}
+	if !key.PublicKey.Equal(active.Public()) {
+		t.Error("Expected the public key to be the public half of the loaded key")
+	}
+}
+
+func TestLoadKeys_PKCS8(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	path := writePEM(t, "PRIVATE KEY", der)
+
+	ring, err := LoadKeys(Options{KeyFile: path})
+	if err != nil {
+		t.Fatalf("Unexpected error loading PKCS#8 key: %v", err)
+	}
+	active, err := ring.ActiveKey("")
+	if err != nil {
+		t.Fatalf("Expected an active key after loading: %v", err)
+	}
+	if !key.Equal(active.Signer) {
+		t.Error("Expected the active key to match the key on disk")
+	}
+}
+
+func TestLoadKeys_WeakKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	path := writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+
+	if _, err := LoadKeys(Options{KeyFile: path, GenerateKey: true}); err == nil {
+		t.Error("Expected an error for a 1024-bit key, but got nil")
+	}
+}
+
+func TestLoadKeys_Malformed(t *testing.T) {
+	path := writePEM(t, "RSA PRIVATE KEY", []byte("not a key"))
+
+	if _, err := LoadKeys(Options{KeyFile: path, GenerateKey: true}); err == nil {
+		t.Error("Expected an error for a malformed key, but got nil")
+	}
+}
+
+func TestLoadKeys_EC(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	path := writePEM(t, "EC PRIVATE KEY", der)
+
+	ring, err := LoadKeys(Options{KeyFile: path, Algorithm: "ES384"})
+	if err != nil {
+		t.Fatalf("Unexpected error loading SEC 1 key: %v", err)
+	}
+	active, err := ring.ActiveKey("")
+	if err != nil {
+		t.Fatalf("Expected an active key after loading: %v", err)
+	}
+	if active.Alg != "ES384" {
+		t.Errorf("Expected alg ES384 for a P-384 key, got %s", active.Alg)
+	}
+	if !key.Equal(active.Signer) {
+		t.Error("Expected the active key to match the key on disk")
+	}
+}
+
+func TestLoadKeys_AlgorithmMismatch(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	path := writePEM(t, "PRIVATE KEY", der)
+
+	if _, err := LoadKeys(Options{KeyFile: path}); err == nil {
+		t.Error("Expected an error for a P-256 key when RS256 is configured, but got nil")
+	}
+}
+
+func TestLoadKeys_UnsupportedCurve(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	path := writePEM(t, "PRIVATE KEY", der)
+
+	if _, err := LoadKeys(Options{KeyFile: path}); err == nil {
+		t.Error("Expected an error for a P-224 key, but got nil")
+	}
+}
+
+func TestLoadKeys_GenerateEC(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing.pem")
+
+	ring, err := LoadKeys(Options{KeyFile: path, Algorithm: "ES256", GenerateKey: true})
+	if err != nil {
+		t.Fatalf("Unexpected error generating key: %v", err)
+	}
+	active, err := ring.ActiveKey("")
+	if err != nil {
+		t.Fatalf("Expected an active key after generating: %v", err)
+	}
+	if _, ok := active.Signer.(*ecdsa.PrivateKey); !ok {
+		t.Errorf("Expected an EC key for ES256, got %T", active.Signer)
+	}
+}
+
+func TestLoadKeys_MissingWithoutGenerate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.pem")
+
+	if _, err := LoadKeys(Options{KeyFile: path}); err == nil {
+		t.Error("Expected an error for a missing key file, but got nil")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected no key file to be created without GenerateKey")
+	}
+}
+
+func TestLoadKeys_GenerateAndPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "signing.pem")
+
+	ring, err := LoadKeys(Options{KeyFile: path, GenerateKey: true})
+	if err != nil {
+		t.Fatalf("Unexpected error generating key: %v", err)
+	}
+	generated, err := ring.ActiveKey("")
+	if err != nil {
+		t.Fatalf("Expected an active key after generating: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected generated key to be persisted: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Expected key file mode 0600, got %o", perm)
+	}
+
+	// A second start must reuse the persisted key instead of generating another one.
+	ring, err = LoadKeys(Options{KeyFile: path, GenerateKey: true})
+	if err != nil {
+		t.Fatalf("Unexpected error reloading key: %v", err)
+	}
+	reloaded, err := ring.ActiveKey("")
+	if err != nil {
+		t.Fatalf("Expected an active key after reloading: %v", err)
+	}
+	if reloaded.Kid != generated.Kid {
+		t.Error("Expected the persisted key to be loaded on the second start")
+	}
+}
+
+func TestLoadKeys_NoFileConfigured(t *testing.T) {
+	if _, err := LoadKeys(Options{GenerateKey: true}); err == nil {
+		t.Error("Expected an error when no key file is configured, but got nil")
+	}
+}
+
+func TestLoadKeys_Ed25519(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	path := writePEM(t, "PRIVATE KEY", der)
+
+	ring, err := LoadKeys(Options{KeyFile: path, Algorithm: "EdDSA"})
+	if err != nil {
+		t.Fatalf("Unexpected error loading Ed25519 key: %v", err)
+	}
+	active, err := ring.ActiveKey("")
+	if err != nil {
+		t.Fatalf("Expected an active key after loading: %v", err)
+	}
+	if active.Alg != "EdDSA" {
+		t.Errorf("Expected alg EdDSA for an Ed25519 key, got %s", active.Alg)
+	}
+	if !key.Equal(active.Signer) {
+		t.Error("Expected the active key to match the key on disk")
+	}
+}
+
+func TestLoadKeys_RSAPSS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	path := writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+
+	ring, err := LoadKeys(Options{KeyFile: path, Algorithm: "PS384"})
+	if err != nil {
+		t.Fatalf("Unexpected error loading RSA key for PS384: %v", err)
+	}
+	active, err := ring.ActiveKey("")
+	if err != nil {
+		t.Fatalf("Expected an active key after loading: %v", err)
+	}
+	if active.Alg != "PS384" {
+		t.Errorf("Expected the key to be registered for PS384, got %s", active.Alg)
+	}
+
+	jwkMap, err := ring.GetJWK()
+	if err != nil {
+		t.Fatalf("Unexpected error when getting JWK: %v", err)
+	}
+	if alg := jwkMap["keys"].([]JWK)[0].Alg; alg != "PS384" {
+		t.Errorf("Expected JWK alg PS384, got %s", alg)
+	}
+}
+
+func TestLoadKeys_AdditionalKeyFiles(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{
+		KeyFile:            filepath.Join(dir, "rs256.pem"),
+		AdditionalKeyFiles: map[string]string{"ES256": filepath.Join(dir, "es256.pem")},
+		GenerateKey:        true,
+	}
+	ring, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Unexpected error loading keys: %v", err)
+	}
+
+	for _, alg := range []string{"RS256", "ES256"} {
+		if _, err := ring.ActiveKey(alg); err != nil {
+			t.Errorf("Expected an active %s key: %v", alg, err)
+		}
+	}
+	if _, err := os.Stat(opts.AdditionalKeyFiles["ES256"]); err != nil {
+		t.Errorf("Expected the ES256 key to be persisted: %v", err)
+	}
+}
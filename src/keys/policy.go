@@ -0,0 +1,268 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"slices"
+)
+
+// Key types of a KeyPolicy.
+const (
+	KeyTypeRSA     = "RSA"
+	KeyTypeEC      = "EC"
+	KeyTypeEd25519 = "Ed25519"
+)
+
+const (
+	// DefaultRSAKeyBits is the modulus size of generated RSA keys unless the key policy sets one.
+	DefaultRSAKeyBits = 2048
+	// DefaultMinStrength is the security strength in bits every key needs
+	// unless the key policy sets one. It is the strength of 2048 bit RSA keys.
+	DefaultMinStrength = 112
+)
+
+// rsaKeySizes are the RSA modulus sizes keys are generated with.
+var rsaKeySizes = []int{2048, 3072, 4096}
+
+// strengths are the security strengths a key policy can require.
+var strengths = []int{112, 128, 192, 256}
+
+// curves maps the names of the supported EC curves to the curves.
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// KeyPolicy decides which keys are generated and which keys are accepted,
+// whether they are loaded, generated or shared by other instances. The zero
+// value generates 2048 bit RSA keys and accepts keys of at least 112 bits of
+// security strength.
+type KeyPolicy struct {
+	// KeyType is the type of the key for the default algorithm when
+	// Options.Algorithm is empty: KeyTypeRSA, KeyTypeEC or KeyTypeEd25519.
+	// If Options.Algorithm is set, it has to sign with this type of key.
+	KeyType string
+	// RSABits is the modulus size of generated RSA keys: 2048, 3072 or 4096.
+	RSABits int
+	// Curve is the EC curve of the key for the default algorithm, "P-256",
+	// "P-384" or "P-521". It requires KeyType KeyTypeEC.
+	Curve string
+	// MinStrength is the security strength in bits (NIST SP 800-57) a key
+	// needs to be accepted: 112, 128, 192 or 256. See Strength.
+	MinStrength int
+	// Algorithms are the JWS algorithms keys may sign with, all supported
+	// ones if empty.
+	Algorithms []string
+}
+
+// Validate checks that the policy only names supported key types, sizes,
+// curves, strengths and algorithms.
+func (p KeyPolicy) Validate() error {
+	switch p.KeyType {
+	case "", KeyTypeRSA, KeyTypeEC, KeyTypeEd25519:
+	default:
+		return fmt.Errorf("unsupported key type %q, use %s, %s or %s", p.KeyType, KeyTypeRSA, KeyTypeEC, KeyTypeEd25519)
+	}
+	if p.RSABits != 0 && !slices.Contains(rsaKeySizes, p.RSABits) {
+		return fmt.Errorf("unsupported RSA key size %d, use 2048, 3072 or 4096", p.RSABits)
+	}
+	if p.Curve != "" {
+		if _, ok := curves[p.Curve]; !ok {
+			return fmt.Errorf("unsupported EC curve %q, use P-256, P-384 or P-521", p.Curve)
+		}
+		if p.KeyType != KeyTypeEC {
+			return fmt.Errorf("an EC curve requires key type %s", KeyTypeEC)
+		}
+	}
+	if p.MinStrength != 0 && !slices.Contains(strengths, p.MinStrength) {
+		return fmt.Errorf("unsupported minimum key strength %d, use 112, 128, 192 or 256", p.MinStrength)
+	}
+	for _, alg := range p.Algorithms {
+		if keyType(alg) == "" {
+			return fmt.Errorf("unsupported signing algorithm %q", alg)
+		}
+	}
+	return nil
+}
+
+// Algorithm returns the default algorithm for the configured alg: alg itself
+// if it is set, otherwise the algorithm for KeyType and Curve.
+func (p KeyPolicy) Algorithm(alg string) (string, error) {
+	alg, err := p.algorithm(alg)
+	if err != nil {
+		return "", err
+	}
+	if err := p.checkAllowed(alg); err != nil {
+		return "", err
+	}
+	return alg, nil
+}
+
+func (p KeyPolicy) algorithm(alg string) (string, error) {
+	if alg == "" {
+		switch p.KeyType {
+		case "", KeyTypeRSA:
+			return DefaultAlgorithm, nil
+		case KeyTypeEd25519:
+			return "EdDSA", nil
+		case KeyTypeEC:
+			if p.Curve == "" {
+				return "ES256", nil
+			}
+			return curveAlgorithms[curves[p.Curve]], nil
+		}
+		return "", fmt.Errorf("unsupported key type %q", p.KeyType)
+	}
+
+	if p.KeyType != "" && keyType(alg) != p.KeyType {
+		return "", fmt.Errorf("signing algorithm %s does not use the key type %s of the key policy", alg, p.KeyType)
+	}
+	if p.Curve != "" && curveAlgorithms[curves[p.Curve]] != alg {
+		return "", fmt.Errorf("signing algorithm %s does not use the curve %s of the key policy", alg, p.Curve)
+	}
+	return alg, nil
+}
+
+// CheckGenerated returns an error unless the keys generated for alg meet the
+// policy, so a misconfiguration shows at startup rather than at rotation.
+func (p KeyPolicy) CheckGenerated(alg string) error {
+	if err := p.checkAllowed(alg); err != nil {
+		return err
+	}
+	var strength int
+	switch keyType(alg) {
+	case KeyTypeRSA:
+		strength = rsaStrength(p.rsaBits())
+	case KeyTypeEC:
+		strength = curveStrength(algorithmCurves[alg])
+	case KeyTypeEd25519:
+		strength = 128
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if strength < p.minStrength() {
+		return fmt.Errorf("%s keys have a strength of %d bits, the key policy requires %d", alg, strength, p.minStrength())
+	}
+	return nil
+}
+
+// Check returns an error unless the private half of pub meets the minimum
+// strength and alg is allowed.
+func (p KeyPolicy) Check(pub crypto.PublicKey, alg string) error {
+	if err := p.checkAllowed(alg); err != nil {
+		return err
+	}
+	strength, err := Strength(pub)
+	if err != nil {
+		return err
+	}
+	if strength < p.minStrength() {
+		return fmt.Errorf("key is too weak: strength of %d bits, the key policy requires %d", strength, p.minStrength())
+	}
+	return nil
+}
+
+// GenerateKey creates a new private key for the JWS algorithm alg.
+func (p KeyPolicy) GenerateKey(alg string) (crypto.Signer, error) {
+	switch keyType(alg) {
+	case KeyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, p.rsaBits())
+	case KeyTypeEC:
+		return ecdsa.GenerateKey(algorithmCurves[alg], rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+}
+
+func (p KeyPolicy) checkAllowed(alg string) error {
+	if len(p.Algorithms) > 0 && !slices.Contains(p.Algorithms, alg) {
+		return fmt.Errorf("signing algorithm %s is not allowed by the key policy", alg)
+	}
+	return nil
+}
+
+func (p KeyPolicy) rsaBits() int {
+	if p.RSABits == 0 {
+		return DefaultRSAKeyBits
+	}
+	return p.RSABits
+}
+
+func (p KeyPolicy) minStrength() int {
+	if p.MinStrength == 0 {
+		return DefaultMinStrength
+	}
+	return p.MinStrength
+}
+
+// Strength returns the security strength in bits of the private half of pub,
+// following NIST SP 800-57 Part 1, table 2: 112 for 2048 bit RSA keys, 128 for
+// 3072 bit RSA keys, P-256 and Ed25519, 192 for P-384 and 256 for P-521.
+func Strength(pub crypto.PublicKey) (int, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return rsaStrength(pub.N.BitLen()), nil
+	case *ecdsa.PublicKey:
+		if strength := curveStrength(pub.Curve); strength > 0 {
+			return strength, nil
+		}
+		return 0, fmt.Errorf("unsupported EC curve %s", pub.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return 128, nil
+	default:
+		return 0, fmt.Errorf("unsupported key type %T", pub)
+	}
+}
+
+func rsaStrength(bits int) int {
+	switch {
+	case bits >= 15360:
+		return 256
+	case bits >= 7680:
+		return 192
+	case bits >= 3072:
+		return 128
+	case bits >= 2048:
+		return 112
+	case bits >= 1024:
+		return 80
+	default:
+		return 0
+	}
+}
+
+func curveStrength(curve elliptic.Curve) int {
+	switch curve {
+	case elliptic.P256():
+		return 128
+	case elliptic.P384():
+		return 192
+	case elliptic.P521():
+		return 256
+	default:
+		return 0
+	}
+}
+
+// keyType returns the type of key the JWS algorithm alg signs with.
+func keyType(alg string) string {
+	switch {
+	case rsaAlgorithms[alg]:
+		return KeyTypeRSA
+	case algorithmCurves[alg] != nil:
+		return KeyTypeEC
+	case alg == "EdDSA":
+		return KeyTypeEd25519
+	default:
+		return ""
+	}
+}
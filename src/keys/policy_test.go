@@ -0,0 +1,109 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyPolicy_Algorithm(t *testing.T) {
+	for _, tc := range []struct {
+		policy   KeyPolicy
+		alg      string
+		expected string
+	}{
+		{KeyPolicy{}, "", "RS256"},
+		{KeyPolicy{KeyType: KeyTypeRSA}, "PS256", "PS256"},
+		{KeyPolicy{KeyType: KeyTypeEC}, "", "ES256"},
+		{KeyPolicy{KeyType: KeyTypeEC, Curve: "P-384"}, "", "ES384"},
+		{KeyPolicy{KeyType: KeyTypeEd25519}, "", "EdDSA"},
+		{KeyPolicy{KeyType: KeyTypeEC}, "RS256", ""},
+		{KeyPolicy{KeyType: KeyTypeEC, Curve: "P-256"}, "ES384", ""},
+	} {
+		alg, err := tc.policy.Algorithm(tc.alg)
+		if tc.expected == "" {
+			if err == nil {
+				t.Errorf("%+v: expected an error for %s, got %s", tc.policy, tc.alg, alg)
+			}
+			continue
+		}
+		if err != nil || alg != tc.expected {
+			t.Errorf("%+v: expected %s for %q, got %s (%v)", tc.policy, tc.expected, tc.alg, alg, err)
+		}
+	}
+}
+
+func TestKeyPolicy_Validate(t *testing.T) {
+	for _, policy := range []KeyPolicy{
+		{KeyType: "DSA"},
+		{RSABits: 1024},
+		{RSABits: 8192},
+		{Curve: "P-384"},
+		{KeyType: KeyTypeEC, Curve: "P-224"},
+		{MinStrength: 100},
+	} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", policy)
+		}
+	}
+	if err := (KeyPolicy{KeyType: KeyTypeRSA, RSABits: 3072, MinStrength: 128}).Validate(); err != nil {
+		t.Errorf("Unexpected error for a valid policy: %v", err)
+	}
+}
+
+func TestLoadKeys_PolicyGeneratesKeys(t *testing.T) {
+	dir := t.TempDir()
+	ring, err := LoadKeys(Options{
+		KeyFile:            filepath.Join(dir, "signing.pem"),
+		AdditionalKeyFiles: map[string]string{"ES384": filepath.Join(dir, "es384.pem")},
+		GenerateKey:        true,
+		Policy:             KeyPolicy{RSABits: 3072, MinStrength: 128},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error generating keys: %v", err)
+	}
+	active, _ := ring.ActiveKey("RS256")
+	if bits := active.Public().(*rsa.PublicKey).N.BitLen(); bits != 3072 {
+		t.Errorf("Expected a 3072 bit RSA key, got %d bits", bits)
+	}
+	if es384, err := ring.ActiveKey("ES384"); err != nil || es384.Public().(*ecdsa.PublicKey).Curve != elliptic.P384() {
+		t.Error("Expected an active ES384 key on P-384")
+	}
+
+	ring, err = LoadKeys(Options{
+		KeyFile:     filepath.Join(dir, "ec.pem"),
+		GenerateKey: true,
+		Policy:      KeyPolicy{KeyType: KeyTypeEC, Curve: "P-384"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error generating EC key: %v", err)
+	}
+	active, _ = ring.ActiveKey("")
+	if pub, ok := active.Public().(*ecdsa.PublicKey); !ok || pub.Curve != elliptic.P384() || active.Alg != "ES384" {
+		t.Errorf("Expected an ES384 key on P-384, got %s", active.Alg)
+	}
+}
+
+func TestLoadKeys_PolicyRejectsWeakKeys(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "signing.pem")
+	if _, err := LoadKeys(Options{KeyFile: keyFile, GenerateKey: true}); err != nil {
+		t.Fatalf("Unexpected error generating key: %v", err)
+	}
+
+	// The 2048 bit key only has a strength of 112 bits.
+	if _, err := LoadKeys(Options{KeyFile: keyFile, Policy: KeyPolicy{MinStrength: 128}}); err == nil {
+		t.Error("Expected a key below the minimum strength to be rejected")
+	}
+	// Generated keys have to meet the minimum strength too.
+	if _, err := LoadKeys(Options{
+		KeyFile:     filepath.Join(dir, "es256.pem"),
+		Algorithm:   "ES256",
+		GenerateKey: true,
+		Policy:      KeyPolicy{MinStrength: 192},
+	}); err == nil {
+		t.Error("Expected a policy generating keys below its minimum strength to be rejected")
+	}
+}
@@ -0,0 +1,302 @@
+package keys
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "oauth-basic/src/utils"
+)
+
+// KeyProvider is where signing keys live. The ring and the token code only ever
+// see a crypto.Signer, so the private key can stay in process memory, on disk or
+// in an external signing service without them knowing.
+type KeyProvider interface {
+	// LoadKey returns the signing key the provider holds for alg.
+	LoadKey(alg string) (crypto.Signer, error)
+	// GenerateKey creates a new signing key for alg, e.g. when the ring rotates.
+	GenerateKey(alg string) (crypto.Signer, error)
+}
+
+// KeyLister is implemented by providers that hold more keys for an algorithm
+// than the active one, e.g. the pending and retiring keys a FileProvider keeps
+// next to its key file. The ring loads all of them instead of only LoadKey's.
+type KeyLister interface {
+	// ListKeys returns the keys for alg with their lifecycle, exactly one of
+	// them active. Providers that cannot keep a lifecycle return keys without
+	// a status instead, scheduled by their ActivatedAt.
+	ListKeys(alg string) ([]Key, error)
+}
+
+// KeySaver is implemented by providers that keep the lifecycle of their keys
+// across restarts. The ring hands them all of its keys after every change.
+type KeySaver interface {
+	SaveKeys(keys []Key) error
+}
+
+// MemoryProvider keeps generated keys in process memory only. Every process
+// gets its own keys, which is fine for tests and single instance setups.
+type MemoryProvider struct {
+	policy KeyPolicy
+
+	mu   sync.Mutex
+	keys map[string]crypto.Signer
+}
+
+// NewMemoryProvider creates a provider holding the given keys per algorithm.
+// Algorithms without a key get a freshly generated one on first use. Keys are
+// generated as policy demands.
+func NewMemoryProvider(keys map[string]crypto.Signer, policy KeyPolicy) *MemoryProvider {
+	p := &MemoryProvider{policy: policy, keys: map[string]crypto.Signer{}}
+	for alg, key := range keys {
+		p.keys[alg] = key
+	}
+	return p
+}
+
+func (p *MemoryProvider) LoadKey(alg string) (crypto.Signer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[alg]; ok {
+		return key, nil
+	}
+	key, err := p.policy.GenerateKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	p.keys[alg] = key
+	return key, nil
+}
+
+func (p *MemoryProvider) GenerateKey(alg string) (crypto.Signer, error) {
+	return p.policy.GenerateKey(alg)
+}
+
+// FileProvider reads one PEM key file per algorithm, usually from a mounted
+// Kubernetes secret.
+type FileProvider struct {
+	files      map[string]string
+	passphrase []byte
+	generate   bool
+	policy     KeyPolicy
+}
+
+// NewFileProvider creates a provider for the key files per algorithm. Encrypted
+// files are decrypted with passphrase. With generate set, missing files are
+// created and the ring's keys are saved, so a restart continues with the same
+// keys: the active key in the file of its algorithm, pending and retiring keys
+// next to it, see SaveKeys; all encrypted with passphrase if set. Otherwise the
+// files are only read and generated keys live in memory. Keys are generated as
+// policy demands.
+func NewFileProvider(files map[string]string, passphrase []byte, generate bool, policy KeyPolicy) *FileProvider {
+	return &FileProvider{files: files, passphrase: passphrase, generate: generate, policy: policy}
+}
+
+func (p *FileProvider) LoadKey(alg string) (crypto.Signer, error) {
+	path, ok := p.files[alg]
+	if !ok {
+		return nil, fmt.Errorf("no key file configured for %s", alg)
+	}
+
+	key, err := LoadPrivateKeyFile(path, p.passphrase)
+	if errors.Is(err, fs.ErrNotExist) && p.generate {
+		Logger.Printf("Signing key %s not found, generating a new %s key", path, alg)
+		key, err = p.policy.GenerateKey(alg)
+		if err != nil {
+			return nil, fmt.Errorf("generating signing key: %w", err)
+		}
+		if err := WritePrivateKeyFile(path, key, p.passphrase); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := CheckAlgorithm(key.Public(), alg); err != nil {
+		return nil, fmt.Errorf("signing key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// GenerateKey only generates the key. It is written once the ring saves it,
+// so a key the ring refuses never ends up on disk.
+func (p *FileProvider) GenerateKey(alg string) (crypto.Signer, error) {
+	return p.policy.GenerateKey(alg)
+}
+
+// ListKeys returns the key in the file of alg as the active key, together with
+// the pending and retiring keys SaveKeys kept next to it. A key saved as
+// active that is not in the key file, because the server stopped while
+// promoting it, is pending again.
+func (p *FileProvider) ListKeys(alg string) ([]Key, error) {
+	signer, err := p.LoadKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := Thumbprint(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	keys := []Key{{Kid: kid, Alg: alg, Signer: signer, Status: StatusActive}}
+
+	path := p.files[alg]
+	data, err := os.ReadFile(lifecycleFile(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading signing key lifecycle: %w", err)
+	}
+	stored, err := unmarshalRing(data)
+	if err != nil {
+		return nil, fmt.Errorf("signing key lifecycle %s: %w", lifecycleFile(path), err)
+	}
+	for _, k := range stored {
+		if k.Kid == kid {
+			keys[0].CreatedAt, keys[0].ActivatedAt = k.CreatedAt, k.ActivatedAt
+			continue
+		}
+		if !kidPattern.MatchString(k.Kid) || k.Alg != alg {
+			return nil, fmt.Errorf("signing key lifecycle %s: invalid key %q", lifecycleFile(path), k.Kid)
+		}
+		signer, err := LoadPrivateKeyFile(sideKeyFile(path, k.Kid), p.passphrase)
+		if err != nil {
+			return nil, err
+		}
+		if k.Status == StatusActive {
+			k.Status, k.ActivatedAt = StatusPending, time.Time{}
+		}
+		key, err := k.key(signer, nil)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// SaveKeys keeps the keys of the ring when generate is set. For the key file
+// signing.pem of an algorithm:
+//
+//	signing.pem            the active key
+//	signing.<kid>.pem      the pending and retiring keys
+//	signing.keys.json      the lifecycle of these keys
+//
+// The active key only replaces the key file once it is promoted, and the
+// files of revoked and dropped keys are removed.
+func (p *FileProvider) SaveKeys(keys []Key) error {
+	if !p.generate {
+		return nil
+	}
+	for alg, path := range p.files {
+		var active *Key
+		var kept []Key
+		for _, k := range keys {
+			if k.Alg != alg {
+				continue
+			}
+			switch k.Status {
+			case StatusActive:
+				active = &k
+				kept = append(kept, k)
+			case StatusPending, StatusRetiring:
+				kept = append(kept, k)
+			}
+		}
+		if err := p.saveKeys(path, active, kept); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveKeys saves the keys of one key file. Files are written before they are
+// referenced and removed after, so a crash in between leaves loadable keys.
+func (p *FileProvider) saveKeys(path string, active *Key, kept []Key) error {
+	sideKeys := map[string]bool{}
+	for _, k := range kept {
+		if k.Status == StatusActive {
+			continue
+		}
+		sideKeys[filepath.Base(sideKeyFile(path, k.Kid))] = true
+		if _, err := os.Stat(sideKeyFile(path, k.Kid)); err == nil {
+			continue
+		}
+		if err := WritePrivateKeyFile(sideKeyFile(path, k.Kid), k.Signer, p.passphrase); err != nil {
+			return err
+		}
+	}
+
+	data, err := marshalRing(kept)
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(lifecycleFile(path), data); err != nil {
+		return err
+	}
+
+	if active != nil {
+		current, err := LoadPrivateKeyFile(path, p.passphrase)
+		var currentKid string
+		if err == nil {
+			currentKid, _ = Thumbprint(current.Public())
+		}
+		if currentKid != active.Kid {
+			data, err := MarshalPrivateKeyPEM(active.Signer, p.passphrase)
+			if err != nil {
+				return err
+			}
+			if err := replaceFile(path, data); err != nil {
+				return err
+			}
+		}
+	}
+
+	stem := keyFileStem(path)
+	matches, err := filepath.Glob(stem + ".*.pem")
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		kid := strings.TrimSuffix(strings.TrimPrefix(match, stem+"."), ".pem")
+		if kidPattern.MatchString(kid) && !sideKeys[filepath.Base(match)] {
+			os.Remove(match)
+		}
+	}
+	return nil
+}
+
+// keyFileStem is the key file path without extension, which the files kept
+// next to it start with.
+func keyFileStem(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+// sideKeyFile is where a pending or retiring key is kept next to the key file path.
+func sideKeyFile(path, kid string) string {
+	return keyFileStem(path) + "." + kid + ".pem"
+}
+
+// lifecycleFile is where the lifecycle of the keys of the key file path is kept.
+func lifecycleFile(path string) string {
+	return keyFileStem(path) + ".keys.json"
+}
+
+// replaceFile writes data next to path first and renames it, so path is never
+// half written. The file is readable only by the owner.
+func replaceFile(path string, data []byte) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".new")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replacing %s: %w", path, err)
+	}
+	return nil
+}
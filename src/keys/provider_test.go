@@ -0,0 +1,150 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryProvider(t *testing.T) {
+	key, err := GenerateKey("ES256")
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	provider := NewMemoryProvider(map[string]crypto.Signer{"ES256": key}, KeyPolicy{})
+
+	loaded, err := provider.LoadKey("ES256")
+	if err != nil {
+		t.Fatalf("Unexpected error loading key: %v", err)
+	}
+	if !key.(*ecdsa.PrivateKey).Equal(loaded) {
+		t.Error("Expected the provider to return the key it was created with")
+	}
+
+	generated, err := provider.LoadKey("RS256")
+	if err != nil {
+		t.Fatalf("Unexpected error loading missing key: %v", err)
+	}
+	again, err := provider.LoadKey("RS256")
+	if err != nil {
+		t.Fatalf("Unexpected error loading key: %v", err)
+	}
+	if generated != again {
+		t.Error("Expected a generated key to be kept for the next load")
+	}
+}
+
+func TestFileProvider_KeepsGeneratedKeysUntilPromoted(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{
+		KeyFile:          filepath.Join(dir, "signing.pem"),
+		GenerateKey:      true,
+		RotationInterval: 24 * time.Hour,
+		PrePublish:       time.Hour,
+		RevocationFile:   filepath.Join(dir, "revoked.json"),
+	}
+	ring, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+	first, _ := ring.ActiveKey("")
+	pending, err := ring.Generate("", time.Now())
+	if err != nil {
+		t.Fatalf("Unexpected error generating key: %v", err)
+	}
+	if _, err := ring.Generate("", time.Now()); err == nil {
+		t.Error("Expected an error generating a second pending key, but got nil")
+	}
+	if kid := keyFileKid(t, opts.KeyFile); kid != first.Kid {
+		t.Error("Expected the key file to keep the active key until the pending key is promoted")
+	}
+
+	// A restart during pre-publication keeps both keys as they were.
+	restarted, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Failed to load keys after a restart: %v", err)
+	}
+	if got := statuses(restarted); got[first.Kid] != StatusActive || got[pending.Kid] != StatusPending || len(got) != 2 {
+		t.Errorf("Expected the active and the pending key after a restart, got %v", got)
+	}
+
+	if _, err := restarted.Promote(pending.Kid, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Unexpected error promoting key: %v", err)
+	}
+	if kid := keyFileKid(t, opts.KeyFile); kid != pending.Kid {
+		t.Error("Expected the promoted key to replace the key file")
+	}
+	restarted, err = LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Failed to load keys after a restart: %v", err)
+	}
+	if got := statuses(restarted); got[pending.Kid] != StatusActive || got[first.Kid] != StatusRetiring {
+		t.Errorf("Expected the promoted key to be active and the old one retiring after a restart, got %v", got)
+	}
+
+	// A revoked pending key does not come back either.
+	revoked, err := restarted.Generate("", time.Now())
+	if err != nil {
+		t.Fatalf("Unexpected error generating key: %v", err)
+	}
+	if _, err := restarted.Revoke(revoked.Kid, time.Now()); err != nil {
+		t.Fatalf("Unexpected error revoking key: %v", err)
+	}
+	restarted, err = LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Failed to load keys after revoking the pending key: %v", err)
+	}
+	if _, ok := statuses(restarted)[revoked.Kid]; ok {
+		t.Error("Expected the revoked key not to be loaded after a restart")
+	}
+}
+
+// keyFileKid returns the kid of the key in the file at path.
+func keyFileKid(t *testing.T, path string) string {
+	t.Helper()
+	key, err := LoadPrivateKeyFile(path, nil)
+	if err != nil {
+		t.Fatalf("Expected the key file to be readable: %v", err)
+	}
+	kid, _ := Thumbprint(key.Public())
+	return kid
+}
+
+func TestFileProvider_ReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	if _, err := NewFileProvider(map[string]string{"RS256": path}, nil, false, KeyPolicy{}).LoadKey("RS256"); err == nil {
+		t.Error("Expected an error for a missing key file, but got nil")
+	}
+	if _, err := NewFileProvider(nil, nil, false, KeyPolicy{}).LoadKey("RS256"); err == nil {
+		t.Error("Expected an error for an algorithm without key file, but got nil")
+	}
+}
+
+// countingProvider records how often the ring asks for new keys.
+type countingProvider struct {
+	*MemoryProvider
+	generated int
+}
+
+func (p *countingProvider) GenerateKey(alg string) (crypto.Signer, error) {
+	p.generated++
+	return p.MemoryProvider.GenerateKey(alg)
+}
+
+func TestKeyRing_RotationUsesProvider(t *testing.T) {
+	start := time.Now()
+	provider := &countingProvider{MemoryProvider: NewMemoryProvider(nil, KeyPolicy{})}
+	ring, err := NewKeyRing(provider, Options{RotationInterval: 24 * time.Hour, PrePublish: time.Hour}, start)
+	if err != nil {
+		t.Fatalf("Failed to create key ring: %v", err)
+	}
+
+	if err := ring.Rotate(start.Add(23 * time.Hour)); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	if provider.generated != 1 {
+		t.Errorf("Expected the rotated key to come from the provider, got %d generated keys", provider.generated)
+	}
+}
@@ -0,0 +1,101 @@
+package keys
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// ErrKeyRevoked is returned when looking up a key that has been revoked.
+var ErrKeyRevoked = errors.New("signing key has been revoked")
+
+// Revocation records that a key was revoked, so it stays revoked across restarts.
+type Revocation struct {
+	Kid       string    `json:"kid"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// LoadRevocations reads the revocation list at path. A missing file is an
+// empty list.
+func LoadRevocations(path string) ([]Revocation, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading revocation list: %w", err)
+	}
+	var list []Revocation
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parsing revocation list %s: %w", path, err)
+	}
+	return list, nil
+}
+
+// RevokeKey revokes kid where the servers configured by opts look for
+// revocations: in the shared key storage of KeySecret or KeyDirectory if it
+// holds the key, replacing it there if it is active, otherwise in the
+// revocation file, found like LoadKeys does. It is what the revoke-key
+// command uses; running servers pick the change up on their next check.
+func RevokeKey(opts Options, kid string, now time.Time) error {
+	store, err := opts.sharedStore()
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		stored, _, err := store.Load()
+		if err != nil {
+			return err
+		}
+		if slices.ContainsFunc(stored, func(key Key) bool { return key.Kid == kid }) {
+			ring, err := LoadKeys(opts)
+			if err != nil {
+				return err
+			}
+			_, err = ring.Revoke(kid, now)
+			return err
+		}
+	}
+
+	path := opts.revocationPath()
+	if path == "" {
+		return errors.New("no revocation file configured")
+	}
+	return RevokeKid(path, kid, now)
+}
+
+// RevokeKid adds kid to the revocation list at path, creating the file if it
+// does not exist.
+func RevokeKid(path, kid string, now time.Time) error {
+	list, err := LoadRevocations(path)
+	if err != nil {
+		return err
+	}
+	for _, revoked := range list {
+		if revoked.Kid == kid {
+			return nil
+		}
+	}
+	return writeRevocations(path, append(list, Revocation{Kid: kid, RevokedAt: now}))
+}
+
+// writeRevocations replaces the revocation list at path, so it is never half written.
+func writeRevocations(path string, list []Revocation) error {
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".new")
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("writing revocation list: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replacing revocation list: %w", err)
+	}
+	return nil
+}
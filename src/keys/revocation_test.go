@@ -0,0 +1,185 @@
+package keys
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyRing_RevokeActiveKey(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{
+		KeyFile:        filepath.Join(dir, "signing.pem"),
+		GenerateKey:    true,
+		RevocationFile: filepath.Join(dir, "revoked.json"),
+	}
+	ring, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+	leaked, _ := ring.ActiveKey("")
+
+	if _, err := ring.Revoke(leaked.Kid, time.Now()); err != nil {
+		t.Fatalf("Unexpected error revoking the active key: %v", err)
+	}
+	replacement, err := ring.ActiveKey("")
+	if err != nil {
+		t.Fatalf("Expected a replacement key to be active: %v", err)
+	}
+	if replacement.Kid == leaked.Kid {
+		t.Error("Expected issuance to switch to a new key")
+	}
+	if _, err := ring.Lookup(leaked.Kid); !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("Expected ErrKeyRevoked for the revoked key, got %v", err)
+	}
+	if _, _, err := ring.LookupPublicKey(replacement.Kid); err != nil {
+		t.Errorf("Expected the replacement key to be published: %v", err)
+	}
+
+	// After a restart the replacement is loaded from the key file and the
+	// revoked kid is still rejected.
+	restarted, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Failed to load keys after revocation: %v", err)
+	}
+	if active, _ := restarted.ActiveKey(""); active.Kid != replacement.Kid {
+		t.Error("Expected the replacement key to be active after a restart")
+	}
+	if _, err := restarted.Lookup(leaked.Kid); !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("Expected the revocation to survive a restart, got %v", err)
+	}
+}
+
+func TestKeyRing_RevocationSurvivesRestartByDefault(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "signing.pem")
+	key, err := GenerateKey("RS256")
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if err := WritePrivateKeyFile(keyFile, key, nil); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	ring, err := LoadKeys(Options{KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+	leaked, _ := ring.ActiveKey("")
+	if _, err := ring.Revoke(leaked.Kid, time.Now()); err != nil {
+		t.Fatalf("Unexpected error revoking the active key: %v", err)
+	}
+
+	// Without KEY_REVOCATION_FILE the revocation is kept next to the key file,
+	// so the leaked key is not loaded again after a restart.
+	if _, err := LoadKeys(Options{KeyFile: keyFile}); !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("Expected the revoked key file to be refused after a restart, got %v", err)
+	}
+	if revocations, _ := LoadRevocations(filepath.Join(dir, "revoked.json")); len(revocations) != 1 || revocations[0].Kid != leaked.Kid {
+		t.Errorf("Expected the revocation next to the key file, got %v", revocations)
+	}
+}
+
+func TestKeyRing_RevokeWithoutRevocationFile(t *testing.T) {
+	server := newTransitServer(t)
+	if err := server.CreateKey("signing", "ecdsa-p256"); err != nil {
+		t.Fatalf("Failed to create Transit key: %v", err)
+	}
+	ring, err := LoadKeys(Options{
+		Algorithm: "ES256",
+		Transit:   &TransitOptions{Address: server.URL, Token: server.Token, Key: "signing"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+	active, _ := ring.ActiveKey("")
+
+	if _, err := ring.Revoke(active.Kid, time.Now()); err == nil {
+		t.Error("Expected a revocation that would not survive a restart to be refused")
+	}
+	if still, _ := ring.ActiveKey(""); still.Kid != active.Kid {
+		t.Error("Expected the key to stay active after the refused revocation")
+	}
+}
+
+func TestLoadKeys_RevokedKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "signing.pem")
+	key, err := GenerateKey("RS256")
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if err := WritePrivateKeyFile(keyFile, key, nil); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	kid, _ := Thumbprint(key.Public())
+	revocationFile := filepath.Join(dir, "revoked.json")
+	if err := RevokeKid(revocationFile, kid, time.Now()); err != nil {
+		t.Fatalf("Failed to revoke key: %v", err)
+	}
+
+	if _, err := LoadKeys(Options{KeyFile: keyFile, RevocationFile: revocationFile}); !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("Expected a revoked key file to be refused, got %v", err)
+	}
+}
+
+func TestKeyRing_ReloadRevocations(t *testing.T) {
+	revocationFile := filepath.Join(t.TempDir(), "revoked.json")
+	ring := newTestRing(t, Options{RevocationFile: revocationFile}, time.Now())
+	leaked, _ := ring.ActiveKey("")
+
+	if err := RevokeKid(revocationFile, leaked.Kid, time.Now()); err != nil {
+		t.Fatalf("Failed to revoke key: %v", err)
+	}
+	if err := RevokeKid(revocationFile, "not-loaded", time.Now()); err != nil {
+		t.Fatalf("Failed to revoke key: %v", err)
+	}
+	if err := ring.ReloadRevocations(time.Now()); err != nil {
+		t.Fatalf("Unexpected error reloading revocations: %v", err)
+	}
+
+	if active, _ := ring.ActiveKey(""); active.Kid == leaked.Kid {
+		t.Error("Expected a revocation from the file to replace the active key")
+	}
+	if _, err := ring.Lookup("not-loaded"); !errors.Is(err, ErrKeyRevoked) {
+		t.Errorf("Expected ErrKeyRevoked for a revoked kid not in the ring, got %v", err)
+	}
+	revocations, err := LoadRevocations(revocationFile)
+	if err != nil {
+		t.Fatalf("Failed to load revocations: %v", err)
+	}
+	if len(revocations) != 2 {
+		t.Errorf("Expected 2 revocations, got %d", len(revocations))
+	}
+}
+
+func TestRevokeKey_DefaultFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{"key file", Options{KeyFile: filepath.Join(dir, "keys", "signing.pem")}, filepath.Join(dir, "keys", "revoked.json")},
+		{"key directory", Options{KeyDirectory: filepath.Join(dir, "ring")}, filepath.Join(dir, "ring", "revoked.json")},
+		{"explicit", Options{KeyFile: filepath.Join(dir, "signing.pem"), RevocationFile: filepath.Join(dir, "list.json")}, filepath.Join(dir, "list.json")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.MkdirAll(filepath.Dir(tt.expected), 0o700); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			if err := RevokeKey(tt.opts, "leaked", time.Now()); err != nil {
+				t.Fatalf("Unexpected error revoking key: %v", err)
+			}
+			if revocations, _ := LoadRevocations(tt.expected); len(revocations) != 1 || revocations[0].Kid != "leaked" {
+				t.Errorf("Expected the revocation in %s, got %v", tt.expected, revocations)
+			}
+		})
+	}
+
+	if err := RevokeKey(Options{Transit: &TransitOptions{Key: "signing"}}, "leaked", time.Now()); err == nil {
+		t.Error("Expected an error without a revocation file")
+	}
+}
@@ -0,0 +1,904 @@
+package keys
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	. "oauth-basic/src/utils"
+)
+
+// Status describes where a key is in its lifecycle.
+type Status string
+
+const (
+	// StatusPending keys are already published in the JWKS but do not sign yet,
+	// so verifiers can pick them up before the first token is signed with them.
+	StatusPending Status = "pending"
+	// StatusActive is the key currently used to sign tokens.
+	StatusActive Status = "active"
+	// StatusRetiring keys no longer sign but stay published until every token
+	// they signed has expired.
+	StatusRetiring Status = "retiring"
+	// StatusRevoked keys are no longer published and tokens they signed are
+	// rejected, e.g. after the key was compromised.
+	StatusRevoked Status = "revoked"
+)
+
+const (
+	// DefaultTokenLifetime is used when Options.TokenLifetime is not set.
+	DefaultTokenLifetime = time.Hour
+	// DefaultPrePublish is the upper bound for the pre-publication period when
+	// Options.PrePublish is not set.
+	DefaultPrePublish = time.Hour
+	// rotationCheckInterval is how often Run checks whether the schedule is due.
+	rotationCheckInterval = time.Minute
+)
+
+var (
+	// ErrUnknownKey is returned for a kid that is not in the ring.
+	ErrUnknownKey = errors.New("unknown key id")
+	// ErrTokensOutstanding is returned when unpublishing a key whose tokens may not have expired yet.
+	ErrTokensOutstanding = errors.New("key has unexpired tokens")
+	// ErrNotYetPublished is returned when promoting a key that cached copies of the JWKS may not contain yet.
+	ErrNotYetPublished = errors.New("key has not been published for the JWKS max-age")
+)
+
+// Key is a signing key held by the KeyRing.
+type Key struct {
+	Kid string
+	// Alg is the JWS algorithm the key signs with.
+	Alg string
+	// Signer signs with the private key, which may live outside the process.
+	Signer    crypto.Signer
+	Status    Status
+	CreatedAt time.Time
+	// ActivatedAt is when the key started signing, or for pending keys when it will.
+	ActivatedAt time.Time
+	// RetiredAt is when the key stopped signing.
+	RetiredAt time.Time
+	// RevokedAt is when the key was revoked.
+	RevokedAt time.Time
+	// IssuedTokens counts the tokens signed with the key since the server started.
+	IssuedTokens int64
+	// LastIssuedAt is when the key last signed a token.
+	LastIssuedAt time.Time
+	// MaxTokenLifetime is the longest lifetime of a token the key signed.
+	MaxTokenLifetime time.Duration
+	// Certificates is the X.509 chain of the key, leaf first, if it has one.
+	Certificates []*x509.Certificate
+}
+
+// Public returns the public half of the key.
+func (k Key) Public() crypto.PublicKey {
+	return k.Signer.Public()
+}
+
+// KeyRing holds one active signing key per algorithm together with the keys
+// published next to them and rotates them on a fixed schedule.
+type KeyRing struct {
+	mu       sync.RWMutex
+	keys     []*Key
+	provider KeyProvider
+
+	defaultAlg       string
+	rotationInterval time.Duration
+	prePublish       time.Duration
+	tokenLifetime    time.Duration
+	// policy is checked for every key entering the ring.
+	policy KeyPolicy
+
+	// certificates are the loaded certificate chains, matched to keys by their public key.
+	certificates [][]*x509.Certificate
+	selfIssue    bool
+
+	// revoked holds every revoked kid, including ones no longer in the ring.
+	revoked        map[string]time.Time
+	revocationFile string
+
+	// jwks is the encoded JWKS, updated whenever the published keys change.
+	jwks    JWKSDocument
+	jwksErr error
+
+	// store, if set, shares the keys with other instances; storeVersion is
+	// the version of the stored keys this ring holds.
+	store        KeyStore
+	storeVersion string
+	// scheduled is set when the provider lists keys without a status, e.g.
+	// Transit versions. Their lifecycle follows their activation times, see
+	// scheduleKeys, and Sync lists them again to pick up new ones.
+	scheduled bool
+}
+
+// NewKeyRing creates a ring whose keys come from provider: the active key for
+// opts.Algorithm, which is the ring's default algorithm, and one for each
+// additional algorithm of opts.AdditionalKeyFiles or opts.Transit, together
+// with their pending and retiring keys if the provider is a KeyLister. Rotated
+// keys are generated by the provider too, and saved by it if it is a
+// KeySaver. Rotation is disabled when opts.RotationInterval is zero. Keys
+// listed in opts.RevocationFile are rejected.
+func NewKeyRing(provider KeyProvider, opts Options, now time.Time) (*KeyRing, error) {
+	r, err := newKeyRing(provider, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, alg := range r.algorithms(opts) {
+		if err := r.loadKeys(alg, now); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.checkCertificatesUsed(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// newKeyRing creates an empty ring configured by opts.
+func newKeyRing(provider KeyProvider, opts Options) (*KeyRing, error) {
+	r := &KeyRing{
+		provider:         provider,
+		rotationInterval: opts.RotationInterval,
+		prePublish:       opts.PrePublish,
+		tokenLifetime:    opts.TokenLifetime,
+		selfIssue:        opts.SelfIssueCertificates,
+		policy:           opts.Policy,
+		revoked:          map[string]time.Time{},
+		revocationFile:   opts.RevocationFile,
+	}
+	if r.tokenLifetime <= 0 {
+		r.tokenLifetime = DefaultTokenLifetime
+	}
+	if r.rotationInterval < 0 {
+		return nil, errors.New("key rotation interval must not be negative")
+	}
+	if r.rotationInterval > 0 {
+		if r.prePublish == 0 {
+			r.prePublish = min(DefaultPrePublish, r.rotationInterval/2)
+		}
+		if r.prePublish <= 0 || r.prePublish >= r.rotationInterval {
+			return nil, fmt.Errorf("key pre-publication period %s must be between 0 and the rotation interval %s", r.prePublish, r.rotationInterval)
+		}
+	}
+
+	if r.revocationFile != "" {
+		revocations, err := LoadRevocations(r.revocationFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, revoked := range revocations {
+			r.revoked[revoked.Kid] = revoked.RevokedAt
+		}
+	}
+
+	for _, path := range opts.CertificateFiles {
+		chain, err := LoadCertificateChain(path)
+		if err != nil {
+			return nil, err
+		}
+		r.certificates = append(r.certificates, chain)
+	}
+
+	if err := r.policy.Validate(); err != nil {
+		return nil, fmt.Errorf("key policy: %w", err)
+	}
+	alg, err := r.policy.Algorithm(opts.Algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("key policy: %w", err)
+	}
+	r.defaultAlg = alg
+	// Transit generates keys itself, they are checked once loaded.
+	if opts.Transit == nil {
+		for _, alg := range r.algorithms(opts) {
+			if err := r.policy.CheckGenerated(alg); err != nil {
+				return nil, fmt.Errorf("key policy: %w", err)
+			}
+		}
+	}
+	return r, nil
+}
+
+// algorithms returns the default algorithm followed by the additional ones.
+func (r *KeyRing) algorithms(opts Options) []string {
+	return append([]string{r.defaultAlg}, opts.additionalAlgorithms()...)
+}
+
+// loadKeys adds the keys the provider holds for alg: all it lists if it is a
+// KeyLister, otherwise the one LoadKey returns. Revoked pending and retiring
+// keys are left out, a revoked active key is refused.
+func (r *KeyRing) loadKeys(alg string, now time.Time) error {
+	lister, ok := r.provider.(KeyLister)
+	if !ok {
+		key, err := r.provider.LoadKey(alg)
+		if err != nil {
+			return err
+		}
+		if err := r.AddKey(key, alg, now); err != nil {
+			return fmt.Errorf("%s signing key: %w", alg, err)
+		}
+		return nil
+	}
+
+	listed, err := lister.ListKeys(alg)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(listed, func(key Key) bool { return key.Status == "" }) {
+		r.scheduled = true
+		r.mu.RLock()
+		listed, err = r.scheduleKeys(listed, now)
+		r.mu.RUnlock()
+		if err != nil {
+			return fmt.Errorf("%s signing key: %w", alg, err)
+		}
+	}
+	for _, key := range listed {
+		if key.Alg != alg {
+			return fmt.Errorf("%s signing key %s is listed for %s", alg, key.Kid, key.Alg)
+		}
+		if r.scheduled && r.expired(key, now) {
+			continue
+		}
+		err := r.addKey(key, now)
+		if errors.Is(err, ErrKeyRevoked) && key.Status != StatusActive {
+			Logger.Printf("Not loading revoked signing key %s", key.Kid)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s signing key: %w", alg, err)
+		}
+	}
+	if r.find(alg, StatusActive) == nil {
+		return fmt.Errorf("no active %s signing key", alg)
+	}
+	return nil
+}
+
+// AddKey registers key as the active key for alg, which must not have an
+// active key yet. Revoked keys and keys violating the key policy are refused.
+func (r *KeyRing) AddKey(key crypto.Signer, alg string, now time.Time) error {
+	return r.addKey(Key{Alg: alg, Signer: key, Status: StatusActive}, now)
+}
+
+// addKey adds key with the status and times it has; missing creation,
+// activation and retirement times are now. Revoked keys, keys violating the
+// key policy and a second active or pending key for an algorithm are refused.
+func (r *KeyRing) addKey(key Key, now time.Time) error {
+	prepared, err := r.prepareKey(key, now)
+	if err != nil {
+		return err
+	}
+	kid := prepared.Kid
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.revoked[kid]; ok {
+		return fmt.Errorf("key %s: %w", kid, ErrKeyRevoked)
+	}
+	// Scheduled keys may have several pending keys, each waiting for its turn.
+	single := key.Status == StatusActive || key.Status == StatusPending && !r.scheduled
+	if single && r.find(key.Alg, key.Status) != nil {
+		return fmt.Errorf("there already is an %s %s key", key.Status, key.Alg)
+	}
+	for _, k := range r.keys {
+		if k.Kid == kid {
+			return fmt.Errorf("key %s is already in the ring", kid)
+		}
+	}
+	r.keys = append(r.keys, prepared)
+	r.publish(now)
+	return nil
+}
+
+// prepareKey checks key against the key policy and fills in its kid,
+// certificate chain and missing times.
+func (r *KeyRing) prepareKey(key Key, now time.Time) (*Key, error) {
+	if err := CheckAlgorithm(key.Public(), key.Alg); err != nil {
+		return nil, err
+	}
+	if err := r.policy.Check(key.Public(), key.Alg); err != nil {
+		return nil, err
+	}
+	kid, err := Thumbprint(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	chain, err := r.certificateChain(key.Signer, kid, now)
+	if err != nil {
+		return nil, err
+	}
+	key.Kid, key.Certificates = kid, chain
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = now
+	}
+	if key.Status == StatusActive && key.ActivatedAt.IsZero() {
+		key.ActivatedAt = now
+	}
+	if key.Status == StatusRetiring && key.RetiredAt.IsZero() {
+		key.RetiredAt = now
+	}
+	return &key, nil
+}
+
+// Algorithms returns the algorithms the ring has an active key for, the
+// default algorithm first.
+func (r *KeyRing) Algorithms() []string {
+	algs := []string{r.defaultAlg}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, k := range r.keys {
+		if k.Status == StatusActive && !slices.Contains(algs, k.Alg) {
+			algs = append(algs, k.Alg)
+		}
+	}
+	return algs
+}
+
+// DefaultAlgorithm returns the algorithm tokens are signed with unless a client asks for another one.
+func (r *KeyRing) DefaultAlgorithm() string {
+	return r.defaultAlg
+}
+
+// ActiveKey returns the key tokens for alg should currently be signed with.
+// An empty alg selects the ring's default algorithm.
+func (r *KeyRing) ActiveKey(alg string) (Key, error) {
+	if alg == "" {
+		alg = r.defaultAlg
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if k := r.find(alg, StatusActive); k != nil {
+		return *k, nil
+	}
+	return Key{}, fmt.Errorf("no active %s signing key", alg)
+}
+
+// Lookup returns the published key with the given kid. Revoked keys are not published.
+func (r *KeyRing) Lookup(kid string) (Key, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.revoked[kid]; ok {
+		return Key{}, fmt.Errorf("key %s: %w", kid, ErrKeyRevoked)
+	}
+	if k := r.findKid(kid); k != nil {
+		return *k, nil
+	}
+	return Key{}, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+}
+
+// Keys returns every key of the ring: the published pending, active and
+// retiring keys as well as revoked ones.
+func (r *KeyRing) Keys() []Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]Key, 0, len(r.keys))
+	for _, k := range r.keys {
+		keys = append(keys, *k)
+	}
+	return keys
+}
+
+// Rotate advances the rotation schedule of every algorithm to now: it
+// pre-publishes the next key once the active key is within the pre-publication
+// period of its rotation, promotes pending keys when they are due, drops
+// retiring keys whose tokens have all expired and renews self-issued
+// certificates before they expire.
+func (r *KeyRing) Rotate(now time.Time) error {
+	return r.update(now, func() (bool, error) {
+		return r.rotate(now)
+	})
+}
+
+// rotate does the work of Rotate and reports whether the keys changed.
+func (r *KeyRing) rotate(now time.Time) (bool, error) {
+	// Key generation and certificates are slow, so they happen before taking the write lock.
+	var next []*Key
+	for _, alg := range r.prePublishDue(now) {
+		key, err := r.newKey(alg, now)
+		if err != nil {
+			return false, err
+		}
+		next = append(next, key)
+	}
+	renewed := map[string][]*x509.Certificate{}
+	for _, key := range r.certificatesDue(now) {
+		chain, err := r.certificateChain(key.Signer, key.Kid, now)
+		if err != nil {
+			return false, err
+		}
+		renewed[key.Kid] = chain
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	published, promoted := false, false
+	for kid, chain := range renewed {
+		if key := r.findKid(kid); key != nil && key.Status != StatusRevoked {
+			key.Certificates = chain
+			published = true
+			Logger.Printf("Renewed the certificate of signing key %s until %s", kid, chain[0].NotAfter.Format(time.RFC3339))
+		}
+	}
+	for _, pending := range next {
+		active := r.find(pending.Alg, StatusActive)
+		if active == nil || r.find(pending.Alg, StatusPending) != nil {
+			continue
+		}
+		// Never start signing before the key has been published for the
+		// full pre-publication period.
+		pending.ActivatedAt = active.ActivatedAt.Add(r.rotationInterval)
+		if earliest := now.Add(r.prePublish); pending.ActivatedAt.Before(earliest) {
+			pending.ActivatedAt = earliest
+		}
+		r.keys = append(r.keys, pending)
+		published = true
+		Logger.Printf("Published %s signing key %s, active from %s", pending.Alg, pending.Kid, pending.ActivatedAt.Format(time.RFC3339))
+	}
+
+	for _, pending := range r.keys {
+		// Pending keys without activation time wait for Promote.
+		if pending.Status != StatusPending || pending.ActivatedAt.IsZero() || now.Before(pending.ActivatedAt) {
+			continue
+		}
+		if active := r.find(pending.Alg, StatusActive); active != nil {
+			active.Status = StatusRetiring
+			active.RetiredAt = now
+		}
+		pending.Status = StatusActive
+		pending.ActivatedAt = now
+		promoted = true
+		Logger.Printf("%s signing key %s is now active", pending.Alg, pending.Kid)
+	}
+
+	kept := r.keys[:0]
+	for _, k := range r.keys {
+		if k.Status == StatusRetiring && !now.Before(r.safeToUnpublishAt(k)) {
+			Logger.Printf("Unpublished retired signing key %s", k.Kid)
+			continue
+		}
+		// Tokens of revoked keys are rejected anyway, the entry only remains
+		// so it can be listed until they would have expired.
+		if k.Status == StatusRevoked && !now.Before(k.RevokedAt.Add(r.tokenLifetime)) {
+			continue
+		}
+		kept = append(kept, k)
+	}
+	dropped := len(kept) < len(r.keys)
+	r.keys = kept
+	if published || dropped {
+		r.publish(now)
+	}
+	return published || promoted || dropped, nil
+}
+
+// Generate creates a new pending key for alg, which must already have an
+// active key; an empty alg selects the default algorithm. With rotation
+// enabled it is scheduled like a rotated key, otherwise it waits for Promote.
+// Keys of a provider that schedules them, e.g. Transit, follow its schedule.
+func (r *KeyRing) Generate(alg string, now time.Time) (key Key, err error) {
+	err = r.update(now, func() (bool, error) {
+		key, err = r.generate(alg, now)
+		return err == nil, err
+	})
+	return key, err
+}
+
+func (r *KeyRing) generate(alg string, now time.Time) (Key, error) {
+	if alg == "" {
+		alg = r.defaultAlg
+	}
+	// Checked before the provider generates a key, e.g. rotates a Transit key,
+	// and again once the key is there.
+	r.mu.RLock()
+	_, err := r.generateFor(alg)
+	r.mu.RUnlock()
+	if err != nil {
+		return Key{}, err
+	}
+	if r.scheduled {
+		return r.generateScheduled(alg, now)
+	}
+	key, err := r.newKey(alg, now)
+	if err != nil {
+		return Key{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	active, err := r.generateFor(alg)
+	if err != nil {
+		return Key{}, err
+	}
+	if r.rotationInterval > 0 {
+		key.ActivatedAt = active.ActivatedAt.Add(r.rotationInterval)
+		if earliest := now.Add(r.prePublish); key.ActivatedAt.Before(earliest) {
+			key.ActivatedAt = earliest
+		}
+	}
+	r.keys = append(r.keys, key)
+	r.publish(now)
+	Logger.Printf("Published %s signing key %s", alg, key.Kid)
+	return *key, nil
+}
+
+// generateFor returns the active key of alg, or an error if alg has no active
+// key or already has a pending one. r.mu must be held.
+func (r *KeyRing) generateFor(alg string) (*Key, error) {
+	active := r.find(alg, StatusActive)
+	if active == nil {
+		return nil, fmt.Errorf("no active %s signing key", alg)
+	}
+	if r.find(alg, StatusPending) != nil {
+		return nil, fmt.Errorf("there already is a pending %s key", alg)
+	}
+	return active, nil
+}
+
+// Promote makes the pending key kid the active key of its algorithm right
+// away. The previously active key retires. It refuses to until the key has
+// been published for the JWKS max-age, so cached copies of the JWKS hold it.
+func (r *KeyRing) Promote(kid string, now time.Time) (key Key, err error) {
+	err = r.update(now, func() (bool, error) {
+		key, err = r.promote(kid, now)
+		return err == nil, err
+	})
+	return key, err
+}
+
+func (r *KeyRing) promote(kid string, now time.Time) (Key, error) {
+	if r.scheduled {
+		return Key{}, errScheduled
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := r.findKid(kid)
+	if key == nil {
+		return Key{}, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	if key.Status != StatusPending {
+		return Key{}, fmt.Errorf("key %s is %s, only pending keys can be promoted", kid, key.Status)
+	}
+	if ready := key.CreatedAt.Add(r.jwksMaxAge()); now.Before(ready) {
+		return Key{}, fmt.Errorf("%w: key %s can be promoted from %s", ErrNotYetPublished, kid, ready.Format(time.RFC3339))
+	}
+	if active := r.find(key.Alg, StatusActive); active != nil {
+		active.Status = StatusRetiring
+		active.RetiredAt = now
+	}
+	key.Status = StatusActive
+	key.ActivatedAt = now
+	Logger.Printf("%s signing key %s is now active", key.Alg, kid)
+	return *key, nil
+}
+
+// Retire stops publishing the key kid once every token it signed has expired.
+// The active key cannot be retired, promote its successor instead.
+func (r *KeyRing) Retire(kid string, now time.Time) (key Key, err error) {
+	err = r.update(now, func() (bool, error) {
+		key, err = r.retire(kid, now)
+		return err == nil, err
+	})
+	return key, err
+}
+
+func (r *KeyRing) retire(kid string, now time.Time) (Key, error) {
+	if r.scheduled {
+		return Key{}, errScheduled
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := r.findKid(kid)
+	if key == nil {
+		return Key{}, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	switch key.Status {
+	case StatusActive:
+		return Key{}, fmt.Errorf("key %s is active, promote another %s key first", kid, key.Alg)
+	case StatusPending:
+		key.Status = StatusRetiring
+		key.RetiredAt = now
+		Logger.Printf("Retired signing key %s", kid)
+	case StatusRetiring:
+	default:
+		return Key{}, fmt.Errorf("key %s is %s and cannot be retired", kid, key.Status)
+	}
+	return *key, nil
+}
+
+// Revoke unpublishes the key kid immediately, so tokens it signed are no
+// longer accepted, and records it in the revocation file or the shared store
+// so it stays revoked after a restart; without either, only keys of a
+// MemoryProvider can be revoked. Revoking the active key, e.g. because it
+// leaked, switches its algorithm to a freshly generated key in the same step.
+func (r *KeyRing) Revoke(kid string, now time.Time) (key Key, err error) {
+	err = r.update(now, func() (bool, error) {
+		key, err = r.revokeKey(kid, now)
+		return err == nil, err
+	})
+	return key, err
+}
+
+func (r *KeyRing) revokeKey(kid string, now time.Time) (Key, error) {
+	r.mu.RLock()
+	key := r.findKid(kid)
+	var alg string
+	var active bool
+	if key != nil {
+		alg, active = key.Alg, key.Status == StatusActive
+	}
+	r.mu.RUnlock()
+	if key == nil {
+		return Key{}, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	if _, inMemory := r.provider.(*MemoryProvider); r.revocationFile == "" && r.store == nil && !inMemory {
+		// The provider would load the key again after a restart.
+		return Key{}, fmt.Errorf("cannot revoke key %s: no revocation file configured", kid)
+	}
+
+	if active && r.scheduled {
+		// Another instance revoking the key may already have added the
+		// replacement, which revoke then activates as the pending key.
+		if err := r.syncScheduled(now); err != nil {
+			return Key{}, err
+		}
+		r.mu.RLock()
+		active = r.find(alg, StatusPending) == nil
+		r.mu.RUnlock()
+	}
+	var replacement *Key
+	if active {
+		// Generating is slow, so it happens without holding the lock.
+		var err error
+		if replacement, err = r.newKey(alg, now); err != nil {
+			return Key{}, fmt.Errorf("replacing key %s: %w", kid, err)
+		}
+	}
+	if r.revocationFile != "" {
+		if err := RevokeKid(r.revocationFile, kid, now); err != nil {
+			return Key{}, err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revoke(key, replacement, now)
+	return *key, nil
+}
+
+// ReloadRevocations revokes the keys added to the revocation file since it was
+// last read, e.g. by the revoke-key command.
+func (r *KeyRing) ReloadRevocations(now time.Time) error {
+	if r.revocationFile == "" {
+		return nil
+	}
+	revocations, err := LoadRevocations(r.revocationFile)
+	if err != nil {
+		return err
+	}
+	for _, revoked := range revocations {
+		r.mu.Lock()
+		key := r.findKid(revoked.Kid)
+		if _, known := r.revoked[revoked.Kid]; !known && key == nil {
+			// Remembered in case the key shows up later, e.g. from the provider.
+			r.revoked[revoked.Kid] = revoked.RevokedAt
+		}
+		inUse := key != nil && key.Status != StatusRevoked
+		r.mu.Unlock()
+		if !inUse {
+			continue
+		}
+		if _, err := r.Revoke(revoked.Kid, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revoke marks key as revoked and activates replacement in its place if key
+// is active. r.mu must be held.
+func (r *KeyRing) revoke(key, replacement *Key, now time.Time) {
+	if _, ok := r.revoked[key.Kid]; !ok {
+		r.revoked[key.Kid] = now
+	}
+	if key.Status == StatusRevoked {
+		return
+	}
+	if key.Status == StatusActive {
+		if replacement == nil {
+			// The key became active while the replacement was generated for
+			// another one. Revoking wins over having a key to sign with.
+			replacement = r.find(key.Alg, StatusPending)
+		}
+		if replacement != nil {
+			replacement.Status = StatusActive
+			replacement.ActivatedAt = now
+			if r.findKid(replacement.Kid) == nil {
+				r.keys = append(r.keys, replacement)
+			}
+			Logger.Printf("%s signing key %s is now active", replacement.Alg, replacement.Kid)
+		} else {
+			Logger.Printf("No %s signing key left after revoking %s", key.Alg, key.Kid)
+		}
+	}
+	key.Status = StatusRevoked
+	key.RevokedAt = now
+	r.publish(now)
+	Logger.Printf("Revoked signing key %s", key.Kid)
+}
+
+// RecordIssued notes that the key kid signed a token valid for lifetime, so
+// the key stays published until that token has expired.
+func (r *KeyRing) RecordIssued(kid string, issuedAt time.Time, lifetime time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := r.findKid(kid)
+	if key == nil {
+		return
+	}
+	key.IssuedTokens++
+	if issuedAt.After(key.LastIssuedAt) {
+		key.LastIssuedAt = issuedAt
+	}
+	key.MaxTokenLifetime = max(key.MaxTokenLifetime, lifetime)
+}
+
+// SafeToUnpublishAt returns when every token signed by a retiring key has
+// expired. It is the zero time for other keys: pending and active keys are
+// never unpublished, revoked ones already are.
+func (r *KeyRing) SafeToUnpublishAt(key Key) time.Time {
+	if key.Status != StatusRetiring {
+		return time.Time{}
+	}
+	return r.safeToUnpublishAt(&key)
+}
+
+// Unpublish removes the retiring key kid from the ring and the JWKS. Unless
+// force is set, it refuses to while tokens signed by the key may still be valid.
+func (r *KeyRing) Unpublish(kid string, force bool, now time.Time) (key Key, err error) {
+	err = r.update(now, func() (bool, error) {
+		key, err = r.unpublish(kid, force, now)
+		return err == nil, err
+	})
+	return key, err
+}
+
+func (r *KeyRing) unpublish(kid string, force bool, now time.Time) (Key, error) {
+	if r.scheduled {
+		return Key{}, errScheduled
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := r.findKid(kid)
+	if key == nil {
+		return Key{}, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	if key.Status != StatusRetiring {
+		return Key{}, fmt.Errorf("key %s is %s, only retiring keys can be unpublished", kid, key.Status)
+	}
+	if safe := r.safeToUnpublishAt(key); now.Before(safe) && !force {
+		return Key{}, fmt.Errorf("%w: key %s may have signed tokens valid until %s", ErrTokensOutstanding, kid, safe.Format(time.RFC3339))
+	}
+	kept := r.keys[:0]
+	for _, k := range r.keys {
+		if k != key {
+			kept = append(kept, k)
+		}
+	}
+	r.keys = kept
+	r.publish(now)
+	Logger.Printf("Unpublished retired signing key %s", kid)
+	return *key, nil
+}
+
+// safeToUnpublishAt returns when every token signed by key has expired: the
+// tracked issuance, and since tracking starts with the server, at least the
+// token lifetime after the key retired.
+func (r *KeyRing) safeToUnpublishAt(key *Key) time.Time {
+	safe := key.RetiredAt.Add(r.tokenLifetime)
+	if tracked := key.LastIssuedAt.Add(key.MaxTokenLifetime); tracked.After(safe) {
+		safe = tracked
+	}
+	return safe
+}
+
+// Run rotates the ring and picks up new revocations until ctx is done. With a
+// shared store it also picks up the changes of other instances, and with a
+// provider that schedules its keys the keys added to its backend.
+func (r *KeyRing) Run(ctx context.Context) {
+	rotateEvery := rotationCheckInterval
+	if r.rotationInterval > 0 {
+		rotateEvery = max(min(rotateEvery, r.prePublish/2), time.Second)
+	}
+	interval := rotateEvery
+	if r.store != nil {
+		interval = min(interval, storeSyncInterval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastRotation time.Time
+	for {
+		now := time.Now()
+		if err := r.Sync(now); err != nil {
+			Logger.Printf("Error loading shared signing keys: %v", err)
+		}
+		if now.Sub(lastRotation) >= rotateEvery {
+			if err := r.ReloadRevocations(now); err != nil {
+				Logger.Printf("Error reloading revoked signing keys: %v", err)
+			}
+			if err := r.Rotate(now); err != nil {
+				Logger.Printf("Error rotating signing keys: %v", err)
+			}
+			lastRotation = now
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prePublishDue returns the algorithms whose next key has to be published now.
+func (r *KeyRing) prePublishDue(now time.Time) []string {
+	if r.rotationInterval == 0 {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var due []string
+	for _, active := range r.keys {
+		if active.Status != StatusActive || r.find(active.Alg, StatusPending) != nil {
+			continue
+		}
+		if !now.Before(active.ActivatedAt.Add(r.rotationInterval - r.prePublish)) {
+			due = append(due, active.Alg)
+		}
+	}
+	return due
+}
+
+// newKey has the provider generate a key for alg and returns it as a pending key.
+func (r *KeyRing) newKey(alg string, now time.Time) (*Key, error) {
+	signer, err := r.provider.GenerateKey(alg)
+	if err != nil {
+		return nil, fmt.Errorf("generating %s signing key: %w", alg, err)
+	}
+	if err := CheckAlgorithm(signer.Public(), alg); err != nil {
+		return nil, err
+	}
+	if err := r.policy.Check(signer.Public(), alg); err != nil {
+		return nil, fmt.Errorf("generated %s signing key: %w", alg, err)
+	}
+	kid, err := Thumbprint(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	chain, err := r.certificateChain(signer, kid, now)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{Kid: kid, Alg: alg, Signer: signer, Status: StatusPending, CreatedAt: now, Certificates: chain}, nil
+}
+
+func (r *KeyRing) findKid(kid string) *Key {
+	for _, k := range r.keys {
+		if k.Kid == kid {
+			return k
+		}
+	}
+	return nil
+}
+
+func (r *KeyRing) find(alg string, status Status) *Key {
+	for _, k := range r.keys {
+		if k.Alg == alg && k.Status == status {
+			return k
+		}
+	}
+	return nil
+}
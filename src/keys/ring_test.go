@@ -0,0 +1,348 @@
+package keys
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestRing(t *testing.T, opts Options, now time.Time) *KeyRing {
+	t.Helper()
+	ring, err := NewKeyRing(NewMemoryProvider(nil, KeyPolicy{}), opts, now)
+	if err != nil {
+		t.Fatalf("Failed to create key ring: %v", err)
+	}
+	return ring
+}
+
+func statuses(ring *KeyRing) map[string]Status {
+	result := map[string]Status{}
+	for _, k := range ring.Keys() {
+		result[k.Kid] = k.Status
+	}
+	return result
+}
+
+func TestKeyRing_RotationSchedule(t *testing.T) {
+	start := time.Now()
+	ring := newTestRing(t, Options{
+		RotationInterval: 24 * time.Hour,
+		PrePublish:       2 * time.Hour,
+		TokenLifetime:    time.Hour,
+	}, start)
+	first, err := ring.ActiveKey("")
+	if err != nil {
+		t.Fatalf("Expected an active key: %v", err)
+	}
+
+	// Nothing happens before the pre-publication period starts.
+	if err := ring.Rotate(start.Add(21 * time.Hour)); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	if n := len(ring.Keys()); n != 1 {
+		t.Fatalf("Expected 1 published key before pre-publication, got %d", n)
+	}
+
+	// The next key is published but does not sign yet.
+	if err := ring.Rotate(start.Add(22 * time.Hour)); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	published := ring.Keys()
+	if len(published) != 2 {
+		t.Fatalf("Expected 2 published keys after pre-publication, got %d", len(published))
+	}
+	next := published[1]
+	if next.Status != StatusPending {
+		t.Errorf("Expected next key to be pending, got %s", next.Status)
+	}
+	if active, _ := ring.ActiveKey(""); active.Kid != first.Kid {
+		t.Errorf("Expected %s to still sign, got %s", first.Kid, active.Kid)
+	}
+
+	// At the rotation time the pending key takes over, the old one keeps being published.
+	if err := ring.Rotate(start.Add(24 * time.Hour)); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	if active, _ := ring.ActiveKey(""); active.Kid != next.Kid {
+		t.Errorf("Expected %s to sign after rotation, got %s", next.Kid, active.Kid)
+	}
+	if status := statuses(ring)[first.Kid]; status != StatusRetiring {
+		t.Errorf("Expected old key to be retiring, got %q", status)
+	}
+	if _, err := ring.Lookup(first.Kid); err != nil {
+		t.Errorf("Expected retiring key to be found: %v", err)
+	}
+
+	// Once every token signed by the old key expired it is no longer published.
+	if err := ring.Rotate(start.Add(25 * time.Hour)); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	if _, ok := statuses(ring)[first.Kid]; ok {
+		t.Error("Expected retired key to be unpublished after the token lifetime")
+	}
+	if _, err := ring.Lookup(first.Kid); err == nil {
+		t.Error("Expected lookup of an unpublished key to fail")
+	}
+}
+
+func TestKeyRing_PendingKeyIsPublishedForFullPeriod(t *testing.T) {
+	start := time.Now()
+	ring := newTestRing(t, Options{
+		RotationInterval: 24 * time.Hour,
+		PrePublish:       2 * time.Hour,
+	}, start)
+
+	// The schedule was missed, e.g. because the server was paused.
+	late := start.Add(30 * time.Hour)
+	if err := ring.Rotate(late); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	var pending Key
+	for _, k := range ring.Keys() {
+		if k.Status == StatusPending {
+			pending = k
+		}
+	}
+	if pending.Kid == "" {
+		t.Fatal("Expected a pending key")
+	}
+	if !pending.ActivatedAt.Equal(late.Add(2 * time.Hour)) {
+		t.Errorf("Expected pending key to activate at %s, got %s", late.Add(2*time.Hour), pending.ActivatedAt)
+	}
+}
+
+func TestKeyRing_RotationDisabled(t *testing.T) {
+	start := time.Now()
+	ring := newTestRing(t, Options{}, start)
+
+	if err := ring.Rotate(start.Add(365 * 24 * time.Hour)); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	if n := len(ring.Keys()); n != 1 {
+		t.Errorf("Expected rotation to be disabled, got %d keys", n)
+	}
+}
+
+func TestNewKeyRing_InvalidPrePublish(t *testing.T) {
+	_, err := NewKeyRing(NewMemoryProvider(nil, KeyPolicy{}), Options{RotationInterval: time.Hour, PrePublish: 2 * time.Hour}, time.Now())
+	if err == nil {
+		t.Error("Expected an error when the pre-publication period exceeds the rotation interval")
+	}
+}
+
+func TestKeyRing_RotationKeepsAlgorithm(t *testing.T) {
+	start := time.Now()
+	ring := newTestRing(t, Options{
+		Algorithm:        "PS256",
+		RotationInterval: 24 * time.Hour,
+		PrePublish:       time.Hour,
+	}, start)
+
+	if err := ring.Rotate(start.Add(24 * time.Hour)); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	for _, k := range ring.Keys() {
+		if k.Alg != "PS256" {
+			t.Errorf("Expected key %s to be registered for PS256, got %s", k.Kid, k.Alg)
+		}
+	}
+}
+
+func TestKeyRing_SeveralAlgorithms(t *testing.T) {
+	start := time.Now()
+	ring := newTestRing(t, Options{RotationInterval: 24 * time.Hour, PrePublish: time.Hour}, start)
+	ecKey, err := GenerateKey("ES256")
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	if err := ring.AddKey(ecKey, "ES256", start); err != nil {
+		t.Fatalf("Unexpected error adding ES256 key: %v", err)
+	}
+	if err := ring.AddKey(ecKey, "ES256", start); err == nil {
+		t.Error("Expected an error adding a second active ES256 key")
+	}
+
+	rsaActive, err := ring.ActiveKey("")
+	if err != nil || rsaActive.Alg != "RS256" {
+		t.Fatalf("Expected the default algorithm to be RS256, got %q (%v)", rsaActive.Alg, err)
+	}
+	ecActive, err := ring.ActiveKey("ES256")
+	if err != nil || ecActive.Alg != "ES256" {
+		t.Fatalf("Expected an active ES256 key, got %q (%v)", ecActive.Alg, err)
+	}
+	if _, err := ring.ActiveKey("EdDSA"); err == nil {
+		t.Error("Expected an error for an algorithm without active key")
+	}
+	if n := len(ring.Keys()); n != 2 {
+		t.Errorf("Expected both active keys to be published, got %d", n)
+	}
+
+	// Each algorithm rotates on its own.
+	for _, at := range []time.Duration{23 * time.Hour, 24 * time.Hour} {
+		if err := ring.Rotate(start.Add(at)); err != nil {
+			t.Fatalf("Unexpected rotation error: %v", err)
+		}
+	}
+	for _, alg := range []string{"RS256", "ES256"} {
+		active, err := ring.ActiveKey(alg)
+		if err != nil {
+			t.Fatalf("Expected an active %s key after rotation: %v", alg, err)
+		}
+		if active.Kid == rsaActive.Kid || active.Kid == ecActive.Kid {
+			t.Errorf("Expected the %s key to be rotated", alg)
+		}
+	}
+	if n := len(ring.Keys()); n != 4 {
+		t.Errorf("Expected 2 active and 2 retiring keys, got %d", n)
+	}
+}
+
+func TestKeyRing_GenerateAndPromote(t *testing.T) {
+	start := time.Now()
+	ring := newTestRing(t, Options{}, start)
+	first, _ := ring.ActiveKey("")
+
+	next, err := ring.Generate("", start)
+	if err != nil {
+		t.Fatalf("Unexpected error generating key: %v", err)
+	}
+	if next.Status != StatusPending || next.Alg != "RS256" {
+		t.Errorf("Expected a pending RS256 key, got %s %s", next.Status, next.Alg)
+	}
+	if _, err := ring.Generate("", start); err == nil {
+		t.Error("Expected an error generating a second pending key")
+	}
+
+	// Without rotation the pending key waits for promotion.
+	if err := ring.Rotate(start.Add(48 * time.Hour)); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	if active, _ := ring.ActiveKey(""); active.Kid != first.Kid {
+		t.Fatal("Expected the pending key not to be activated without promotion")
+	}
+
+	// Cached copies of the JWKS may not have the key before the max-age.
+	if _, err := ring.Promote(next.Kid, start); !errors.Is(err, ErrNotYetPublished) {
+		t.Errorf("Expected promoting a freshly published key to be refused, got %v", err)
+	}
+	if _, err := ring.Promote(next.Kid, start.Add(DefaultJWKSMaxAge)); err != nil {
+		t.Fatalf("Unexpected error promoting key: %v", err)
+	}
+	if active, _ := ring.ActiveKey(""); active.Kid != next.Kid {
+		t.Error("Expected the promoted key to sign")
+	}
+	if got := statuses(ring)[first.Kid]; got != StatusRetiring {
+		t.Errorf("Expected the previous key to retire, got %s", got)
+	}
+	if _, err := ring.Promote(first.Kid, start); err == nil {
+		t.Error("Expected an error promoting a retiring key")
+	}
+}
+
+func TestKeyRing_RetireAndRevoke(t *testing.T) {
+	start := time.Now()
+	ring := newTestRing(t, Options{TokenLifetime: time.Hour}, start)
+	active, _ := ring.ActiveKey("")
+	pending, err := ring.Generate("", start)
+	if err != nil {
+		t.Fatalf("Unexpected error generating key: %v", err)
+	}
+
+	if _, err := ring.Retire(active.Kid, start); err == nil {
+		t.Error("Expected an error retiring the active key")
+	}
+	if _, err := ring.Retire("unknown", start); err == nil {
+		t.Error("Expected an error retiring an unknown key")
+	}
+
+	if _, err := ring.Revoke(pending.Kid, start); err != nil {
+		t.Fatalf("Unexpected error revoking key: %v", err)
+	}
+	if _, err := ring.Lookup(pending.Kid); err == nil {
+		t.Error("Expected a revoked key not to be found for verification")
+	}
+	jwks, err := ring.GetJWK()
+	if err != nil {
+		t.Fatalf("Unexpected error when getting JWK: %v", err)
+	}
+	if n := len(jwks["keys"].([]JWK)); n != 1 {
+		t.Errorf("Expected the revoked key to be unpublished, got %d keys", n)
+	}
+	if _, err := ring.Retire(pending.Kid, start); err == nil {
+		t.Error("Expected an error retiring a revoked key")
+	}
+
+	// The revoked key is still listed until its tokens would have expired.
+	if got := statuses(ring)[pending.Kid]; got != StatusRevoked {
+		t.Errorf("Expected the key to be listed as revoked, got %s", got)
+	}
+	if err := ring.Rotate(start.Add(time.Hour)); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	if _, ok := statuses(ring)[pending.Kid]; ok {
+		t.Error("Expected the revoked key to be dropped after the token lifetime")
+	}
+}
+
+func TestKeyRing_SafeToUnpublishAtRevoked(t *testing.T) {
+	ring := newTestRing(t, Options{}, time.Now())
+	pending, err := ring.Generate("", time.Now())
+	if err != nil {
+		t.Fatalf("Unexpected error generating key: %v", err)
+	}
+	revoked, err := ring.Revoke(pending.Kid, time.Now())
+	if err != nil {
+		t.Fatalf("Unexpected error revoking key: %v", err)
+	}
+	if got := ring.SafeToUnpublishAt(revoked); !got.IsZero() {
+		t.Errorf("Expected no safe_to_unpublish_at for a revoked key that never signed, got %s", got)
+	}
+}
+
+func TestKeyRing_UnpublishWaitsForIssuedTokens(t *testing.T) {
+	start := time.Now()
+	ring := newTestRing(t, Options{TokenLifetime: time.Hour}, start)
+	first, _ := ring.ActiveKey("")
+	// A token that lives longer than the configured lifetime.
+	ring.RecordIssued(first.Kid, start.Add(10*time.Minute), 2*time.Hour)
+
+	next, err := ring.Generate("", start)
+	if err != nil {
+		t.Fatalf("Unexpected error generating key: %v", err)
+	}
+	if _, err := ring.Promote(next.Kid, start.Add(20*time.Minute)); err != nil {
+		t.Fatalf("Unexpected error promoting key: %v", err)
+	}
+
+	retired, err := ring.Lookup(first.Kid)
+	if err != nil {
+		t.Fatalf("Expected the retired key to be published: %v", err)
+	}
+	if retired.IssuedTokens != 1 || retired.MaxTokenLifetime != 2*time.Hour {
+		t.Errorf("Expected 1 tracked token of 2h, got %d of %s", retired.IssuedTokens, retired.MaxTokenLifetime)
+	}
+	safe := start.Add(10*time.Minute + 2*time.Hour)
+	if got := ring.SafeToUnpublishAt(retired); !got.Equal(safe) {
+		t.Errorf("Expected the key to be safe to unpublish at %s, got %s", safe, got)
+	}
+
+	// The token lifetime since retiring has passed, the tracked token has not expired.
+	if err := ring.Rotate(start.Add(90 * time.Minute)); err != nil {
+		t.Fatalf("Unexpected rotation error: %v", err)
+	}
+	if _, ok := statuses(ring)[first.Kid]; !ok {
+		t.Error("Expected the key to stay published while its tokens are valid")
+	}
+	if _, err := ring.Unpublish(first.Kid, false, start.Add(90*time.Minute)); !errors.Is(err, ErrTokensOutstanding) {
+		t.Errorf("Expected ErrTokensOutstanding, got %v", err)
+	}
+	if _, err := ring.Unpublish(next.Kid, true, start.Add(90*time.Minute)); err == nil {
+		t.Error("Expected an error unpublishing the active key")
+	}
+	if _, err := ring.Unpublish(first.Kid, true, start.Add(90*time.Minute)); err != nil {
+		t.Fatalf("Unexpected error force unpublishing: %v", err)
+	}
+	if _, ok := statuses(ring)[first.Kid]; ok {
+		t.Error("Expected the key to be unpublished")
+	}
+}
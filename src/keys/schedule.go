@@ -0,0 +1,180 @@
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	. "oauth-basic/src/utils"
+)
+
+// errScheduled is returned when changing the lifecycle of keys that follow
+// the schedule of their provider.
+var errScheduled = errors.New("the keys follow the schedule of the key provider, rotate them in its backend instead")
+
+// scheduleKeys gives keys listed without a status the one their activation
+// times imply, the same on every instance and after every restart: the last
+// key due is active and earlier keys retired when their successor became due.
+// If the last key due is revoked, the next one that is not signs right away,
+// like the replacement of a revoked active key. Later keys are pending and
+// revoked keys are left out. r.mu must be held.
+func (r *KeyRing) scheduleKeys(listed []Key, now time.Time) ([]Key, error) {
+	keys := slices.Clone(listed)
+	slices.SortStableFunc(keys, func(a, b Key) int { return a.ActivatedAt.Compare(b.ActivatedAt) })
+	due := 0
+	for i, key := range keys {
+		kid, err := Thumbprint(key.Public())
+		if err != nil {
+			return nil, err
+		}
+		keys[i].Kid = kid
+		if !now.Before(key.ActivatedAt) {
+			due = i
+		}
+	}
+	active := -1
+	for i := due; i < len(keys); i++ {
+		if _, revoked := r.revoked[keys[i].Kid]; !revoked {
+			active = i
+			break
+		}
+	}
+	if active < 0 {
+		return nil, errors.New("every key that could sign is revoked")
+	}
+
+	scheduled := make([]Key, 0, len(keys))
+	for i, key := range keys {
+		if _, revoked := r.revoked[key.Kid]; revoked {
+			continue
+		}
+		switch {
+		case i < active:
+			key.Status = StatusRetiring
+			key.RetiredAt = keys[i+1].ActivatedAt
+		case i == active:
+			key.Status = StatusActive
+			if i > due {
+				key.ActivatedAt = r.revoked[keys[i-1].Kid]
+			}
+		default:
+			key.Status = StatusPending
+		}
+		scheduled = append(scheduled, key)
+	}
+	return scheduled, nil
+}
+
+// expired reports whether key retired so long ago that every token it signed
+// has expired, so Rotate would already have unpublished it.
+func (r *KeyRing) expired(key Key, now time.Time) bool {
+	return key.Status == StatusRetiring && !now.Before(key.RetiredAt.Add(r.tokenLifetime))
+}
+
+// syncScheduled lists the keys of a scheduling provider again, so keys added
+// to its backend, e.g. by a rotation there, are published and become active
+// on every instance at the same time. Keys no longer listed are dropped.
+func (r *KeyRing) syncScheduled(now time.Time) error {
+	lister := r.provider.(KeyLister)
+	for _, alg := range r.Algorithms() {
+		listed, err := lister.ListKeys(alg)
+		if err != nil {
+			return err
+		}
+		r.mu.RLock()
+		scheduled, err := r.scheduleKeys(listed, now)
+		var unknown []Key
+		for _, key := range scheduled {
+			if r.findKid(key.Kid) == nil && !r.expired(key, now) {
+				unknown = append(unknown, key)
+			}
+		}
+		r.mu.RUnlock()
+		if err != nil {
+			return fmt.Errorf("%s signing key: %w", alg, err)
+		}
+		// Certificates are slow, so they happen before taking the write lock.
+		added := make([]*Key, 0, len(unknown))
+		for _, key := range unknown {
+			prepared, err := r.prepareKey(key, now)
+			if err != nil {
+				return fmt.Errorf("%s signing key %s: %w", alg, key.Kid, err)
+			}
+			added = append(added, prepared)
+		}
+
+		r.mu.Lock()
+		r.applySchedule(alg, scheduled, added, now)
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+// applySchedule updates the keys of alg to scheduled and adds the prepared
+// keys that are not in the ring yet. r.mu must be held.
+func (r *KeyRing) applySchedule(alg string, scheduled []Key, added []*Key, now time.Time) {
+	byKid := map[string]Key{}
+	for _, key := range scheduled {
+		byKid[key.Kid] = key
+	}
+	changed := false
+	kept := r.keys[:0]
+	for _, k := range r.keys {
+		key, listed := byKid[k.Kid]
+		if k.Alg != alg || k.Status == StatusRevoked {
+			kept = append(kept, k)
+			continue
+		}
+		if !listed {
+			Logger.Printf("Unpublished signing key %s, it is no longer in the key provider", k.Kid)
+			changed = true
+			continue
+		}
+		if k.Status != key.Status {
+			if key.Status == StatusActive {
+				Logger.Printf("%s signing key %s is now active", alg, k.Kid)
+			}
+			k.Status, k.ActivatedAt, k.RetiredAt = key.Status, key.ActivatedAt, key.RetiredAt
+			changed = true
+		}
+		kept = append(kept, k)
+	}
+	r.keys = kept
+	for _, key := range added {
+		if r.findKid(key.Kid) != nil {
+			continue
+		}
+		r.keys = append(r.keys, key)
+		changed = true
+		if key.Status == StatusPending {
+			Logger.Printf("Published %s signing key %s, active from %s", alg, key.Kid, key.ActivatedAt.Format(time.RFC3339))
+		}
+	}
+	if changed {
+		r.publish(now)
+	}
+}
+
+// generateScheduled adds a key to the backend of a scheduling provider and
+// picks it up with its schedule, like every other instance will.
+func (r *KeyRing) generateScheduled(alg string, now time.Time) (Key, error) {
+	signer, err := r.provider.GenerateKey(alg)
+	if err != nil {
+		return Key{}, fmt.Errorf("generating %s signing key: %w", alg, err)
+	}
+	kid, err := Thumbprint(signer.Public())
+	if err != nil {
+		return Key{}, err
+	}
+	if err := r.syncScheduled(now); err != nil {
+		return Key{}, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key := r.findKid(kid)
+	if key == nil {
+		return Key{}, fmt.Errorf("generated %s signing key %s is not listed by the key provider", alg, kid)
+	}
+	return *key, nil
+}
@@ -0,0 +1,154 @@
+package keys
+
+import (
+	"fmt"
+	"time"
+
+	. "oauth-basic/src/utils"
+)
+
+// storeSyncInterval is how often Run checks a shared store for changes of other instances.
+const storeSyncInterval = 10 * time.Second
+
+// NewSharedKeyRing creates a ring whose keys are kept in store and shared with
+// every other instance using it. The first instance generates the active keys
+// for opts.Algorithm and opts.AdditionalAlgorithms, the others load them.
+// Every change, including rotation, is made by one instance at a time while it
+// holds the store's lock, and Run picks up the changes of the others.
+func NewSharedKeyRing(store KeyStore, opts Options, now time.Time) (*KeyRing, error) {
+	r, err := newKeyRing(NewMemoryProvider(nil, opts.Policy), opts)
+	if err != nil {
+		return nil, err
+	}
+	r.store = store
+	err = r.update(now, func() (bool, error) {
+		changed := false
+		for _, alg := range r.algorithms(opts) {
+			if _, err := r.ActiveKey(alg); err == nil {
+				continue
+			}
+			key, err := r.provider.GenerateKey(alg)
+			if err != nil {
+				return false, err
+			}
+			if err := r.AddKey(key, alg, now); err != nil {
+				return false, fmt.Errorf("%s signing key: %w", alg, err)
+			}
+			changed = true
+		}
+		return changed, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkCertificatesUsed(); err != nil {
+		return nil, err
+	}
+	// A stored key may have been revoked while no instance was running.
+	if err := r.ReloadRevocations(now); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Sync loads the keys other instances saved to the shared store. It does
+// nothing without a store or when the stored keys have not changed. Keys of a
+// provider that schedules them are listed again instead.
+func (r *KeyRing) Sync(now time.Time) error {
+	if r.scheduled {
+		return r.syncScheduled(now)
+	}
+	if r.store == nil {
+		return nil
+	}
+	version, err := r.store.Version()
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	current := r.storeVersion
+	r.mu.RUnlock()
+	if version == current {
+		return nil
+	}
+	keys, version, err := r.store.Load()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := r.policy.Check(key.Public(), key.Alg); err != nil {
+			return fmt.Errorf("shared signing key %s: %w", key.Kid, err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	loaded := make([]*Key, 0, len(keys))
+	for _, key := range keys {
+		if old := r.findKid(key.Kid); old != nil {
+			// Issuance is tracked per instance.
+			key.IssuedTokens, key.LastIssuedAt, key.MaxTokenLifetime = old.IssuedTokens, old.LastIssuedAt, old.MaxTokenLifetime
+		}
+		if len(key.Certificates) == 0 {
+			key.Certificates = r.loadedCertificateChain(key.Signer)
+		}
+		if key.Status == StatusRevoked {
+			if _, ok := r.revoked[key.Kid]; !ok {
+				r.revoked[key.Kid] = key.RevokedAt
+			}
+		}
+		loaded = append(loaded, &key)
+	}
+	r.keys = loaded
+	r.storeVersion = version
+	r.publish(now)
+	if current != "" {
+		Logger.Printf("Loaded %d signing keys changed by another instance", len(loaded))
+	}
+	return nil
+}
+
+// update applies a change to the ring. With a shared store, fn runs while
+// holding the store's lock on top of the latest stored keys, and the result
+// is saved if fn reports a change. Otherwise a provider that is a KeySaver
+// saves the keys after a change.
+func (r *KeyRing) update(now time.Time, fn func() (bool, error)) error {
+	if r.store == nil {
+		changed, err := fn()
+		if err != nil || !changed {
+			return err
+		}
+		if saver, ok := r.provider.(KeySaver); ok {
+			if err := saver.SaveKeys(r.Keys()); err != nil {
+				return fmt.Errorf("saving signing keys: %w", err)
+			}
+		}
+		return nil
+	}
+	if err := r.store.Lock(); err != nil {
+		return err
+	}
+	defer func() {
+		if err := r.store.Unlock(); err != nil {
+			Logger.Printf("Error unlocking shared signing keys: %v", err)
+		}
+	}()
+	if err := r.Sync(now); err != nil {
+		return err
+	}
+	changed, err := fn()
+	if err != nil || !changed {
+		return err
+	}
+
+	version, err := r.store.Save(r.Keys())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		// Load the stored keys again on the next sync instead of diverging.
+		r.storeVersion = ""
+		return fmt.Errorf("saving shared signing keys: %w", err)
+	}
+	r.storeVersion = version
+	return nil
+}
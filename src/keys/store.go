@@ -0,0 +1,224 @@
+package keys
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrLocked is returned when the lock of a KeyStore is held by another instance for too long.
+var ErrLocked = errors.New("key store is locked by another instance")
+
+const (
+	// storedRingFile lists the keys of the ring and their lifecycle.
+	storedRingFile = "ring.json"
+	// staleLockAge is when a lock is considered left behind by a crashed instance.
+	staleLockAge = time.Minute
+)
+
+// lockTimeout is how long Lock waits for another instance.
+var lockTimeout = 10 * time.Second
+
+// kidPattern matches the base64url thumbprints used as kids, which are safe file names.
+var kidPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// KeyStore keeps the keys of a ring where several server instances share
+// them, so every replica signs with and publishes the same keys. Changes are
+// serialized between the instances through the store's lock.
+type KeyStore interface {
+	// Load returns the stored keys and the version of the stored state. An
+	// empty store has no keys.
+	Load() ([]Key, string, error)
+	// Version returns the version of the stored state, which changes with
+	// every Save. It is cheap enough to be polled.
+	Version() (string, error)
+	// Save replaces the stored keys and returns the new version.
+	Save(keys []Key) (string, error)
+	// Lock waits until no other instance holds the lock and takes it, or
+	// returns ErrLocked when that takes too long.
+	Lock() error
+	// Unlock releases the lock taken by Lock.
+	Unlock() error
+}
+
+// storedKey is what a KeyStore keeps about the lifecycle of a key, next to
+// the key itself and its certificate chain.
+type storedKey struct {
+	Kid         string    `json:"kid"`
+	Alg         string    `json:"alg"`
+	Status      Status    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	ActivatedAt time.Time `json:"activated_at,omitzero"`
+	RetiredAt   time.Time `json:"retired_at,omitzero"`
+	RevokedAt   time.Time `json:"revoked_at,omitzero"`
+	// CertificateExpiresAt is when the certificate of the key expires, so
+	// renewing it changes the stored ring and other instances load it.
+	CertificateExpiresAt time.Time `json:"certificate_expires_at,omitzero"`
+}
+
+type storedRing struct {
+	Keys []storedKey `json:"keys"`
+}
+
+// marshalRing encodes the lifecycle of keys.
+func marshalRing(keys []Key) ([]byte, error) {
+	ring := storedRing{Keys: []storedKey{}}
+	for _, k := range keys {
+		stored := storedKey{
+			Kid:         k.Kid,
+			Alg:         k.Alg,
+			Status:      k.Status,
+			CreatedAt:   k.CreatedAt,
+			ActivatedAt: k.ActivatedAt,
+			RetiredAt:   k.RetiredAt,
+			RevokedAt:   k.RevokedAt,
+		}
+		if len(k.Certificates) > 0 {
+			stored.CertificateExpiresAt = k.Certificates[0].NotAfter
+		}
+		ring.Keys = append(ring.Keys, stored)
+	}
+	return json.MarshalIndent(ring, "", "  ")
+}
+
+// unmarshalRing decodes what marshalRing encoded.
+func unmarshalRing(data []byte) ([]storedKey, error) {
+	var ring storedRing
+	if err := json.Unmarshal(data, &ring); err != nil {
+		return nil, err
+	}
+	for _, k := range ring.Keys {
+		switch k.Status {
+		case StatusPending, StatusActive, StatusRetiring, StatusRevoked:
+		default:
+			return nil, fmt.Errorf("key %s has unknown status %q", k.Kid, k.Status)
+		}
+	}
+	return ring.Keys, nil
+}
+
+// key combines the stored lifecycle with the key itself, after checking that
+// they belong together.
+func (k storedKey) key(signer crypto.Signer, chain []*x509.Certificate) (Key, error) {
+	if err := CheckAlgorithm(signer.Public(), k.Alg); err != nil {
+		return Key{}, fmt.Errorf("key %s: %w", k.Kid, err)
+	}
+	kid, err := Thumbprint(signer.Public())
+	if err != nil {
+		return Key{}, err
+	}
+	if kid != k.Kid {
+		return Key{}, fmt.Errorf("key stored as %s has kid %s", k.Kid, kid)
+	}
+	return Key{
+		Kid:          k.Kid,
+		Alg:          k.Alg,
+		Signer:       signer,
+		Status:       k.Status,
+		CreatedAt:    k.CreatedAt,
+		ActivatedAt:  k.ActivatedAt,
+		RetiredAt:    k.RetiredAt,
+		RevokedAt:    k.RevokedAt,
+		Certificates: chain,
+	}, nil
+}
+
+// loadStoredKeys combines the lifecycle stored in ring with the files of the
+// keys, <kid>.pem and <kid>.crt, returned by read. read reports missing files
+// with fs.ErrNotExist. Parsed private keys are cached in signers, which only
+// keeps the keys of the ring.
+func loadStoredKeys(ring, passphrase []byte, signers map[string]crypto.Signer, read func(name string) ([]byte, error)) ([]Key, error) {
+	stored, err := unmarshalRing(ring)
+	if err != nil {
+		return nil, err
+	}
+	var keys []Key
+	for _, k := range stored {
+		if !kidPattern.MatchString(k.Kid) {
+			return nil, fmt.Errorf("invalid kid %q", k.Kid)
+		}
+		signer, ok := signers[k.Kid]
+		if !ok {
+			data, err := read(k.Kid + ".pem")
+			if err != nil {
+				return nil, fmt.Errorf("reading key %s: %w", k.Kid, err)
+			}
+			if signer, err = ParsePrivateKeyPEM(data, passphrase); err != nil {
+				return nil, fmt.Errorf("key %s: %w", k.Kid, err)
+			}
+		}
+		var chain []*x509.Certificate
+		data, err := read(k.Kid + ".crt")
+		if err == nil {
+			if chain, err = parseCertificateChain(data); err != nil {
+				return nil, fmt.Errorf("certificate chain of key %s: %w", k.Kid, err)
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("reading certificate chain of key %s: %w", k.Kid, err)
+		}
+		key, err := k.key(signer, chain)
+		if err != nil {
+			return nil, err
+		}
+		signers[k.Kid] = signer
+		keys = append(keys, key)
+	}
+	for kid := range signers {
+		if !slices.ContainsFunc(keys, func(k Key) bool { return k.Kid == kid }) {
+			delete(signers, kid)
+		}
+	}
+	return keys, nil
+}
+
+// storedKeyFiles returns the files keys need next to the stored lifecycle,
+// encoding only those that differ from what current returns: private keys
+// never change once stored, certificate chains when they are renewed. kept
+// lists the names of all of them, so the files of dropped keys can be removed.
+func storedKeyFiles(keys []Key, passphrase []byte, current func(name string) ([]byte, bool)) (files map[string][]byte, kept map[string]bool, err error) {
+	files, kept = map[string][]byte{}, map[string]bool{}
+	for _, k := range keys {
+		kept[k.Kid+".pem"] = true
+		if _, ok := current(k.Kid + ".pem"); !ok {
+			if files[k.Kid+".pem"], err = MarshalPrivateKeyPEM(k.Signer, passphrase); err != nil {
+				return nil, nil, fmt.Errorf("key %s: %w", k.Kid, err)
+			}
+		}
+		if len(k.Certificates) > 0 {
+			kept[k.Kid+".crt"] = true
+			chain := encodeCertificateChain(k.Certificates)
+			if stored, _ := current(k.Kid + ".crt"); !bytes.Equal(stored, chain) {
+				files[k.Kid+".crt"] = chain
+			}
+		}
+	}
+	return files, kept, nil
+}
+
+// isKeyFile reports whether name is one of the files storedKeyFiles returns.
+func isKeyFile(name string) bool {
+	return strings.HasSuffix(name, ".pem") || strings.HasSuffix(name, ".crt")
+}
+
+// lockOwner identifies this instance as the holder of a KeyStore's lock.
+func lockOwner() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d/%d", host, os.Getpid(), time.Now().UnixNano())
+}
+
+// dataVersion derives a store version from the stored lifecycle data.
+func dataVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,39 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Thumbprint computes the RFC 7638 JWK thumbprint of pub: the base64url encoded
+// SHA-256 hash of its required JWK members in lexicographic order. It is used
+// as the kid of every signing key, so a new key always gets a new kid.
+func Thumbprint(pub crypto.PublicKey) (string, error) {
+	jwk, err := publicJWK(pub)
+	if err != nil {
+		return "", err
+	}
+
+	var members map[string]string
+	switch jwk.Kty {
+	case "RSA":
+		members = map[string]string{"e": jwk.E, "kty": jwk.Kty, "n": jwk.N}
+	case "EC":
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X, "y": jwk.Y}
+	case "OKP":
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X}
+	default:
+		return "", fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+
+	// json.Marshal sorts map keys and adds no whitespace, which is the canonical form.
+	canonical, err := json.Marshal(members)
+	if err != nil {
+		return "", fmt.Errorf("encoding JWK thumbprint input: %w", err)
+	}
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
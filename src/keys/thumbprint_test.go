@@ -0,0 +1,66 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+)
+
+// Example from RFC 7638, section 3.1.
+func TestThumbprint_RFC7638Example(t *testing.T) {
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	if err != nil {
+		t.Fatalf("Failed to decode modulus: %v", err)
+	}
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+	thumbprint, err := Thumbprint(pub)
+	if err != nil {
+		t.Fatalf("Unexpected error computing thumbprint: %v", err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; thumbprint != want {
+		t.Errorf("Expected thumbprint %s, got %s", want, thumbprint)
+	}
+}
+
+// Example from RFC 8037, appendix A.3.
+func TestThumbprint_RFC8037Example(t *testing.T) {
+	x, err := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+	if err != nil {
+		t.Fatalf("Failed to decode public key: %v", err)
+	}
+
+	thumbprint, err := Thumbprint(ed25519.PublicKey(x))
+	if err != nil {
+		t.Fatalf("Unexpected error computing thumbprint: %v", err)
+	}
+	if want := "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"; thumbprint != want {
+		t.Errorf("Expected thumbprint %s, got %s", want, thumbprint)
+	}
+}
+
+func TestThumbprint_DiffersPerKey(t *testing.T) {
+	first, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	second, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+
+	a, _ := Thumbprint(&first.PublicKey)
+	b, _ := Thumbprint(&second.PublicKey)
+	if a == b {
+		t.Error("Expected different keys to have different thumbprints")
+	}
+}
+
+func TestThumbprint_UnsupportedKey(t *testing.T) {
+	if _, err := Thumbprint([]byte("secret")); err == nil {
+		t.Error("Expected an error for an unsupported key type, but got nil")
+	}
+}
@@ -0,0 +1,304 @@
+package keys
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTransitMount is where the Transit secrets engine is mounted unless configured otherwise.
+const DefaultTransitMount = "transit"
+
+// TransitOptions configures signing with keys held by a HashiCorp Vault Transit
+// compatible backend. The private keys never leave the backend.
+type TransitOptions struct {
+	// Address is the base URL of the backend, e.g. https://vault.example.com:8200.
+	Address string
+	// Token is sent as X-Vault-Token.
+	Token string
+	// Mount is the path of the Transit engine, DefaultTransitMount if empty.
+	Mount string
+	// Key is the Transit key for Options.Algorithm.
+	Key string
+	// AdditionalKeys maps further JWS algorithms to their Transit keys, like
+	// Options.AdditionalKeyFiles does for key files.
+	AdditionalKeys map[string]string
+}
+
+// TransitProvider signs through the /sign endpoint of a Transit backend and
+// reads the public keys from its /keys endpoint. Rotating a key creates a new
+// key version in the backend; every version has its own kid. A new version is
+// published right away and signs from activationDelay after its creation
+// time, the earlier versions that are not archived stay published. Every
+// instance reading the backend derives the same schedule, so the keys are
+// rotated in the backend, e.g. with its auto_rotate_period, not by the ring.
+type TransitProvider struct {
+	address string
+	token   string
+	mount   string
+	keys    map[string]string
+	client  *http.Client
+	// activationDelay is how long a new version is published before it signs.
+	activationDelay time.Duration
+}
+
+// NewTransitProvider creates a provider for the Transit keys per algorithm on
+// the backend described by opts.
+func NewTransitProvider(opts TransitOptions, keys map[string]string) (*TransitProvider, error) {
+	if opts.Address == "" {
+		return nil, errors.New("no Transit address configured")
+	}
+	if opts.Token == "" {
+		return nil, errors.New("no Transit token configured")
+	}
+	mount := strings.Trim(opts.Mount, "/")
+	if mount == "" {
+		mount = DefaultTransitMount
+	}
+	return &TransitProvider{
+		address:         strings.TrimRight(opts.Address, "/"),
+		token:           opts.Token,
+		mount:           mount,
+		keys:            keys,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		activationDelay: DefaultPrePublish,
+	}, nil
+}
+
+// LoadKey returns a signer for the latest version of the Transit key for alg.
+func (p *TransitProvider) LoadKey(alg string) (crypto.Signer, error) {
+	versions, err := p.readVersions(alg)
+	if err != nil {
+		return nil, err
+	}
+	return versions[len(versions)-1].signer, nil
+}
+
+// ListKeys returns the versions of the Transit key for alg that are not
+// archived without a status, each activated activationDelay after its
+// creation time. The ring derives their status from that schedule.
+func (p *TransitProvider) ListKeys(alg string) ([]Key, error) {
+	versions, err := p.readVersions(alg)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]Key, 0, len(versions))
+	for _, v := range versions {
+		keys = append(keys, Key{Alg: alg, Signer: v.signer, CreatedAt: v.createdAt, ActivatedAt: v.createdAt.Add(p.activationDelay)})
+	}
+	return keys, nil
+}
+
+// transitVersion is one version of a Transit key as read from the backend.
+type transitVersion struct {
+	signer    *transitSigner
+	createdAt time.Time
+}
+
+// readVersions reads the versions of the Transit key for alg from
+// min_decryption_version, below which versions are archived, to the latest,
+// oldest first.
+func (p *TransitProvider) readVersions(alg string) ([]transitVersion, error) {
+	name, ok := p.keys[alg]
+	if !ok {
+		return nil, fmt.Errorf("no Transit key configured for %s", alg)
+	}
+
+	var resp struct {
+		Data struct {
+			Type                 string `json:"type"`
+			LatestVersion        int    `json:"latest_version"`
+			MinDecryptionVersion int    `json:"min_decryption_version"`
+			Keys                 map[string]struct {
+				PublicKey    string    `json:"public_key"`
+				CreationTime time.Time `json:"creation_time"`
+			} `json:"keys"`
+		} `json:"data"`
+	}
+	if err := p.do(http.MethodGet, "keys/"+url.PathEscape(name), nil, &resp); err != nil {
+		return nil, fmt.Errorf("reading Transit key %s: %w", name, err)
+	}
+
+	var versions []transitVersion
+	for version := max(resp.Data.MinDecryptionVersion, 1); version <= resp.Data.LatestVersion; version++ {
+		entry, ok := resp.Data.Keys[strconv.Itoa(version)]
+		if !ok {
+			// Trimmed from the backend.
+			continue
+		}
+		if entry.PublicKey == "" {
+			return nil, fmt.Errorf("Transit key %s has no public key for version %d, is it an asymmetric key?", name, version)
+		}
+		pub, err := parseTransitPublicKey(resp.Data.Type, entry.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("Transit key %s version %d: %w", name, version, err)
+		}
+		if err := CheckAlgorithm(pub, alg); err != nil {
+			return nil, fmt.Errorf("Transit key %s: %w", name, err)
+		}
+		if rsaKey, ok := pub.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < MinRSAKeyBits {
+			return nil, fmt.Errorf("Transit key %s: RSA key is too weak: %d bits, need at least %d", name, rsaKey.N.BitLen(), MinRSAKeyBits)
+		}
+		versions = append(versions, transitVersion{
+			signer:    &transitSigner{provider: p, name: name, version: version, public: pub},
+			createdAt: entry.CreationTime,
+		})
+	}
+	if len(versions) == 0 || versions[len(versions)-1].signer.version != resp.Data.LatestVersion {
+		return nil, fmt.Errorf("Transit key %s has no public key for version %d", name, resp.Data.LatestVersion)
+	}
+	return versions, nil
+}
+
+// GenerateKey rotates the Transit key for alg and returns a signer for the new version.
+func (p *TransitProvider) GenerateKey(alg string) (crypto.Signer, error) {
+	name, ok := p.keys[alg]
+	if !ok {
+		return nil, fmt.Errorf("no Transit key configured for %s", alg)
+	}
+	if err := p.do(http.MethodPost, "keys/"+url.PathEscape(name)+"/rotate", nil, nil); err != nil {
+		return nil, fmt.Errorf("rotating Transit key %s: %w", name, err)
+	}
+	return p.LoadKey(alg)
+}
+
+// do calls the Transit API below the mount and decodes the JSON response into out.
+func (p *TransitProvider) do(method, path string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, p.address+"/v1/"+p.mount+"/"+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(data, &vaultErr) == nil && len(vaultErr.Errors) > 0 {
+			return fmt.Errorf("%s: %s", resp.Status, strings.Join(vaultErr.Errors, "; "))
+		}
+		return errors.New(resp.Status)
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// parseTransitPublicKey decodes a public key as returned by Transit: PEM for
+// RSA and ECDSA keys, standard base64 for Ed25519 keys.
+func parseTransitPublicKey(keyType, encoded string) (crypto.PublicKey, error) {
+	if keyType == "ed25519" {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, errors.New("malformed Ed25519 public key")
+		}
+		return ed25519.PublicKey(raw), nil
+	}
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("no PEM block found in public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+	return pub, nil
+}
+
+// transitSigner is one version of a Transit key.
+type transitSigner struct {
+	provider *TransitProvider
+	name     string
+	version  int
+	public   crypto.PublicKey
+}
+
+func (s *transitSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign asks the backend to sign digest. RSA and ECDSA digests are sent
+// prehashed; Ed25519 signs the message itself, so digest is the full message.
+// ECDSA signatures come back ASN.1 encoded, like from ecdsa.PrivateKey.
+func (s *transitSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	req := map[string]interface{}{
+		"input":       base64.StdEncoding.EncodeToString(digest),
+		"key_version": s.version,
+	}
+	path := "sign/" + url.PathEscape(s.name)
+
+	if _, ok := s.public.(ed25519.PublicKey); !ok {
+		hash, ok := transitHashes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash %v", opts.HashFunc())
+		}
+		path += "/" + hash
+		req["prehashed"] = true
+		req["marshaling_algorithm"] = "asn1"
+	}
+	if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+		if pssOpts.SaltLength != rsa.PSSSaltLengthEqualsHash {
+			return nil, errors.New("only RSA-PSS salts as long as the hash are supported")
+		}
+		req["signature_algorithm"] = "pss"
+		req["salt_length"] = "hash"
+	} else if _, ok := s.public.(*rsa.PublicKey); ok {
+		req["signature_algorithm"] = "pkcs1v15"
+	}
+
+	var resp struct {
+		Data struct {
+			Signature string `json:"signature"`
+		} `json:"data"`
+	}
+	if err := s.provider.do(http.MethodPost, path, req, &resp); err != nil {
+		return nil, fmt.Errorf("signing with Transit key %s: %w", s.name, err)
+	}
+	// Signatures look like vault:v<version>:<base64 signature>.
+	parts := strings.SplitN(resp.Data.Signature, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" || parts[1] != "v"+strconv.Itoa(s.version) {
+		return nil, fmt.Errorf("unexpected signature from Transit key %s", s.name)
+	}
+	return base64.StdEncoding.DecodeString(parts[2])
+}
+
+// transitHashes are the Transit names of the hashes used by the JWS algorithms.
+var transitHashes = map[crypto.Hash]string{
+	crypto.SHA256: "sha2-256",
+	crypto.SHA384: "sha2-384",
+	crypto.SHA512: "sha2-512",
+}
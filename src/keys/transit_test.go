@@ -0,0 +1,272 @@
+package keys
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"oauth-basic/src/jwt"
+	"oauth-basic/src/keys/transittest"
+)
+
+func newTransitServer(t *testing.T) *transittest.Server {
+	t.Helper()
+	server := transittest.NewServer("test-token")
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLoadKeys_Transit(t *testing.T) {
+	server := newTransitServer(t)
+	for alg, keyType := range map[string]string{
+		"RS256": "rsa-2048",
+		"PS384": "rsa-3072",
+		"ES256": "ecdsa-p256",
+		"ES512": "ecdsa-p521",
+		"EdDSA": "ed25519",
+	} {
+		if err := server.CreateKey(alg, keyType); err != nil {
+			t.Fatalf("Failed to create Transit key: %v", err)
+		}
+		ring, err := LoadKeys(Options{
+			Algorithm: alg,
+			Transit:   &TransitOptions{Address: server.URL, Token: server.Token, Key: alg},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error loading %s Transit key: %v", alg, err)
+		}
+		active, err := ring.ActiveKey("")
+		if err != nil {
+			t.Fatalf("Expected an active key: %v", err)
+		}
+
+		signed := server.SignRequests()
+		token, err := jwt.GenerateToken(jwt.Claims{}, active.Signer, active.Alg, active.Kid)
+		if err != nil {
+			t.Fatalf("Failed to sign %s token through Transit: %v", alg, err)
+		}
+		if server.SignRequests() != signed+1 {
+			t.Errorf("Expected the %s token to be signed by the Transit backend", alg)
+		}
+		if _, err := jwt.ParseToken(token, ring.LookupPublicKey); err != nil {
+			t.Errorf("Expected the %s token to verify against the published key: %v", alg, err)
+		}
+
+		jwks, err := ring.GetJWK()
+		if err != nil {
+			t.Fatalf("Unexpected error when getting JWK: %v", err)
+		}
+		if kid := jwks["keys"].([]JWK)[0].Kid; kid != active.Kid {
+			t.Errorf("Expected the JWKS to publish the Transit key %s, got %s", active.Kid, kid)
+		}
+	}
+}
+
+func TestLoadKeys_TransitSchedule(t *testing.T) {
+	server := newTransitServer(t)
+	if err := server.CreateKey("signing", "ecdsa-p256"); err != nil {
+		t.Fatalf("Failed to create Transit key: %v", err)
+	}
+	start := time.Now()
+	if err := server.SetCreationTime("signing", 1, start.Add(-2*time.Hour)); err != nil {
+		t.Fatalf("Failed to set the creation time: %v", err)
+	}
+	opts := Options{
+		Algorithm:  "ES256",
+		PrePublish: time.Hour,
+		Transit:    &TransitOptions{Address: server.URL, Token: server.Token, Key: "signing"},
+	}
+	ring, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Unexpected error loading Transit key: %v", err)
+	}
+	first, _ := Thumbprint(server.PublicKey("signing", 1))
+
+	// Rotated in the backend, e.g. by its auto_rotate_period.
+	if err := server.RotateKey("signing"); err != nil {
+		t.Fatalf("Failed to rotate Transit key: %v", err)
+	}
+	next, _ := Thumbprint(server.PublicKey("signing", 2))
+	if err := ring.Sync(start); err != nil {
+		t.Fatalf("Unexpected error syncing Transit keys: %v", err)
+	}
+	replica, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Unexpected error loading Transit key: %v", err)
+	}
+	for name, r := range map[string]*KeyRing{"synced": ring, "restarted": replica} {
+		status := map[string]Status{}
+		for _, key := range r.Keys() {
+			status[key.Kid] = key.Status
+		}
+		if status[first] != StatusActive || status[next] != StatusPending {
+			t.Errorf("Expected the %s ring to publish the new version before it signs, got %v", name, status)
+		}
+	}
+	if _, err := ring.Promote(next, start); err == nil {
+		t.Error("Expected promoting a Transit version to be refused")
+	}
+
+	later := start.Add(time.Hour + time.Minute)
+	for name, r := range map[string]*KeyRing{"synced": ring, "restarted": replica} {
+		if err := r.Sync(later); err != nil {
+			t.Fatalf("Unexpected error syncing Transit keys: %v", err)
+		}
+		active, _ := r.ActiveKey("")
+		if active.Kid != next {
+			t.Errorf("Expected the %s ring to sign with the new version once it is due", name)
+		}
+		previous, err := r.Lookup(first)
+		if err != nil || previous.Status != StatusRetiring {
+			t.Errorf("Expected the %s ring to keep the previous version published", name)
+		}
+	}
+	active, _ := ring.ActiveKey("")
+	if _, err := jwt.GenerateToken(jwt.Claims{}, active.Signer, active.Alg, active.Kid); err != nil {
+		t.Errorf("Failed to sign with the new version: %v", err)
+	}
+}
+
+func TestKeyRing_TransitRevokeReplicas(t *testing.T) {
+	server := newTransitServer(t)
+	if err := server.CreateKey("signing", "ecdsa-p256"); err != nil {
+		t.Fatalf("Failed to create Transit key: %v", err)
+	}
+	now := time.Now()
+	if err := server.SetCreationTime("signing", 1, now.Add(-2*time.Hour)); err != nil {
+		t.Fatalf("Failed to set the creation time: %v", err)
+	}
+	opts := Options{
+		Algorithm:      "ES256",
+		RevocationFile: filepath.Join(t.TempDir(), "revoked.json"),
+		Transit:        &TransitOptions{Address: server.URL, Token: server.Token, Key: "signing"},
+	}
+	ring, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Unexpected error loading Transit key: %v", err)
+	}
+	replica, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Unexpected error loading Transit key: %v", err)
+	}
+	first, _ := ring.ActiveKey("")
+
+	if _, err := ring.Revoke(first.Kid, now); err != nil {
+		t.Fatalf("Unexpected error revoking the active key: %v", err)
+	}
+	replacement, _ := Thumbprint(server.PublicKey("signing", 2))
+	if active, _ := ring.ActiveKey(""); active.Kid != replacement {
+		t.Fatal("Expected the revoking ring to sign with a new Transit version")
+	}
+	if err := replica.Sync(now); err != nil {
+		t.Fatalf("Unexpected error syncing Transit keys: %v", err)
+	}
+	if err := replica.ReloadRevocations(now); err != nil {
+		t.Fatalf("Unexpected error reloading revocations: %v", err)
+	}
+	if active, _ := replica.ActiveKey(""); active.Kid != replacement {
+		t.Error("Expected the replica to sign with the same replacement")
+	}
+	if server.PublicKey("signing", 3) != nil {
+		t.Error("Expected the replica not to rotate the Transit key again")
+	}
+
+	restarted, err := LoadKeys(opts)
+	if err != nil {
+		t.Fatalf("Unexpected error loading Transit key after the revocation: %v", err)
+	}
+	if active, _ := restarted.ActiveKey(""); active.Kid != replacement {
+		t.Error("Expected the replacement to stay active after a restart")
+	}
+	if err := ring.Sync(now.Add(time.Minute)); err != nil {
+		t.Fatalf("Unexpected error syncing Transit keys: %v", err)
+	}
+	if active, _ := ring.ActiveKey(""); active.Kid != replacement {
+		t.Error("Expected syncing to keep the replacement active")
+	}
+}
+
+func TestLoadKeys_TransitVersions(t *testing.T) {
+	server := newTransitServer(t)
+	if err := server.CreateKey("signing", "ecdsa-p256"); err != nil {
+		t.Fatalf("Failed to create Transit key: %v", err)
+	}
+	now := time.Now()
+	// Version 4 signs since 20 minutes, 2 and 3 retired less than an hour ago.
+	for i, age := range []time.Duration{120, 110, 100, 80} {
+		version := i + 1
+		if version > 1 {
+			if err := server.RotateKey("signing"); err != nil {
+				t.Fatalf("Failed to rotate Transit key: %v", err)
+			}
+		}
+		if err := server.SetCreationTime("signing", version, now.Add(-age*time.Minute)); err != nil {
+			t.Fatalf("Failed to set the creation time: %v", err)
+		}
+	}
+	if err := server.SetMinDecryptionVersion("signing", 2); err != nil {
+		t.Fatalf("Failed to archive Transit key versions: %v", err)
+	}
+	kids := map[int]string{}
+	for version := 1; version <= 4; version++ {
+		kids[version], _ = Thumbprint(server.PublicKey("signing", version))
+	}
+
+	ring, err := LoadKeys(Options{
+		Algorithm: "ES256",
+		Transit:   &TransitOptions{Address: server.URL, Token: server.Token, Key: "signing"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error loading Transit key: %v", err)
+	}
+	active, _ := ring.ActiveKey("")
+	if active.Kid != kids[4] {
+		t.Error("Expected the latest version of the Transit key to be active")
+	}
+	jwks, err := publishedJWKs(ring.Keys())
+	if err != nil {
+		t.Fatalf("Unexpected error when getting JWK: %v", err)
+	}
+	published := map[string]bool{}
+	for _, jwk := range jwks {
+		published[jwk.Kid] = true
+	}
+	for version, kid := range kids {
+		if expected := version >= 2; published[kid] != expected {
+			t.Errorf("Expected version %d to be published: %v, got %v", version, expected, published[kid])
+		}
+	}
+
+	// Tokens signed with an earlier version before a restart still verify.
+	if pub, _, err := ring.LookupPublicKey(kids[3]); pub == nil || err != nil {
+		t.Fatal("Expected the public key of version 3")
+	}
+	status := map[string]Status{}
+	for _, key := range ring.Keys() {
+		status[key.Kid] = key.Status
+	}
+	if status[kids[2]] != StatusRetiring || status[kids[3]] != StatusRetiring {
+		t.Errorf("Expected the earlier versions to be retiring, got %v", status)
+	}
+}
+
+func TestLoadKeys_TransitErrors(t *testing.T) {
+	server := newTransitServer(t)
+	if err := server.CreateKey("signing", "ecdsa-p256"); err != nil {
+		t.Fatalf("Failed to create Transit key: %v", err)
+	}
+
+	for name, opts := range map[string]Options{
+		"wrong token":        {Transit: &TransitOptions{Address: server.URL, Token: "wrong", Key: "signing"}},
+		"unknown key":        {Algorithm: "ES256", Transit: &TransitOptions{Address: server.URL, Token: server.Token, Key: "missing"}},
+		"algorithm mismatch": {Algorithm: "RS256", Transit: &TransitOptions{Address: server.URL, Token: server.Token, Key: "signing"}},
+		"no key":             {Algorithm: "ES256", Transit: &TransitOptions{Address: server.URL, Token: server.Token}},
+		"no token":           {Algorithm: "ES256", Transit: &TransitOptions{Address: server.URL, Key: "signing"}},
+		"rotation":           {Algorithm: "ES256", RotationInterval: 24 * time.Hour, Transit: &TransitOptions{Address: server.URL, Token: server.Token, Key: "signing"}},
+		"short pre-publish":  {Algorithm: "ES256", PrePublish: time.Minute, Transit: &TransitOptions{Address: server.URL, Token: server.Token, Key: "signing"}},
+	} {
+		if _, err := LoadKeys(opts); err == nil {
+			t.Errorf("Expected an error for %s, but got nil", name)
+		}
+	}
+}
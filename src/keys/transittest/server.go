@@ -0,0 +1,336 @@
+// Package transittest provides a fake HashiCorp Vault Transit backend, so
+// Transit signing can be tested without a real Vault.
+//
+// It implements the parts of the API the server uses: reading a key
+// (GET /v1/transit/keys/<name>), rotating it (POST /v1/transit/keys/<name>/rotate)
+// and signing (POST /v1/transit/sign/<name>[/<hash>]).
+package transittest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a fake Transit backend mounted at /v1/transit.
+type Server struct {
+	*httptest.Server
+	// Token is the only X-Vault-Token the server accepts.
+	Token string
+
+	mu           sync.Mutex
+	keys         map[string]*transitKey
+	signRequests int
+}
+
+type transitKey struct {
+	keyType  string
+	versions []crypto.Signer
+	created  []time.Time
+	// minDecryptionVersion is the first version that is not archived.
+	minDecryptionVersion int
+}
+
+// NewServer starts a fake Transit backend accepting token.
+func NewServer(token string) *Server {
+	s := &Server{Token: token, keys: map[string]*transitKey{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// CreateKey creates a key with the Transit key type keyType: rsa-2048, rsa-3072,
+// rsa-4096, ecdsa-p256, ecdsa-p384, ecdsa-p521 or ed25519.
+func (s *Server) CreateKey(name, keyType string) error {
+	key, err := generate(keyType)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[name] = &transitKey{keyType: keyType, versions: []crypto.Signer{key}, created: []time.Time{time.Now()}, minDecryptionVersion: 1}
+	return nil
+}
+
+// RotateKey adds a version to a key, like a rotation by another client.
+func (s *Server) RotateKey(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := s.keys[name]
+	if key == nil {
+		return fmt.Errorf("key %s not found", name)
+	}
+	return key.rotate()
+}
+
+// SetMinDecryptionVersion archives the versions of a key before version.
+func (s *Server) SetMinDecryptionVersion(name string, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := s.keys[name]
+	if key == nil {
+		return fmt.Errorf("key %s not found", name)
+	}
+	if version < 1 || version > len(key.versions) {
+		return fmt.Errorf("invalid key version %d", version)
+	}
+	key.minDecryptionVersion = version
+	return nil
+}
+
+// SetCreationTime changes when the given version of a key was created, e.g.
+// to a time before the test started.
+func (s *Server) SetCreationTime(name string, version int, created time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := s.keys[name]
+	if key == nil {
+		return fmt.Errorf("key %s not found", name)
+	}
+	if version < 1 || version > len(key.versions) {
+		return fmt.Errorf("invalid key version %d", version)
+	}
+	key.created[version-1] = created
+	return nil
+}
+
+// PublicKey returns the public key of the given version of a key.
+func (s *Server) PublicKey(name string, version int) crypto.PublicKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := s.keys[name]
+	if key == nil || version < 1 || version > len(key.versions) {
+		return nil
+	}
+	return key.versions[version-1].Public()
+}
+
+// SignRequests returns how many signatures the server has made, e.g. to check
+// that tokens were signed remotely.
+func (s *Server) SignRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.signRequests
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != s.Token {
+		writeError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+	path, ok := strings.CutPrefix(r.URL.Path, "/v1/transit/")
+	if !ok {
+		writeError(w, http.StatusNotFound, "no handler for route")
+		return
+	}
+	parts := strings.Split(path, "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "keys":
+		s.readKey(w, parts[1])
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "keys" && parts[2] == "rotate":
+		s.rotateKey(w, parts[1])
+	case r.Method == http.MethodPost && (len(parts) == 2 || len(parts) == 3) && parts[0] == "sign":
+		hash := "sha2-256"
+		if len(parts) == 3 {
+			hash = parts[2]
+		}
+		s.sign(w, r, parts[1], hash)
+	default:
+		writeError(w, http.StatusNotFound, "no handler for route")
+	}
+}
+
+func (s *Server) readKey(w http.ResponseWriter, name string) {
+	key := s.keys[name]
+	if key == nil {
+		writeError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	versions := map[string]interface{}{}
+	for i, version := range key.versions {
+		public, err := encodePublicKey(version.Public())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		versions[strconv.Itoa(i+1)] = map[string]interface{}{
+			"public_key":    public,
+			"creation_time": key.created[i].Format(time.RFC3339Nano),
+		}
+	}
+	writeData(w, map[string]interface{}{
+		"name":                   name,
+		"type":                   key.keyType,
+		"latest_version":         len(key.versions),
+		"min_decryption_version": key.minDecryptionVersion,
+		"keys":                   versions,
+	})
+}
+
+func (s *Server) rotateKey(w http.ResponseWriter, name string) {
+	key := s.keys[name]
+	if key == nil {
+		writeError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	if err := key.rotate(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (k *transitKey) rotate() error {
+	next, err := generate(k.keyType)
+	if err != nil {
+		return err
+	}
+	k.versions = append(k.versions, next)
+	k.created = append(k.created, time.Now())
+	return nil
+}
+
+func (s *Server) sign(w http.ResponseWriter, r *http.Request, name, hashName string) {
+	key := s.keys[name]
+	if key == nil {
+		writeError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	var req struct {
+		Input               string `json:"input"`
+		KeyVersion          int    `json:"key_version"`
+		Prehashed           bool   `json:"prehashed"`
+		SignatureAlgorithm  string `json:"signature_algorithm"`
+		MarshalingAlgorithm string `json:"marshaling_algorithm"`
+		SaltLength          string `json:"salt_length"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version := req.KeyVersion
+	if version == 0 {
+		version = len(key.versions)
+	}
+	if version < 1 || version > len(key.versions) {
+		writeError(w, http.StatusBadRequest, "invalid key version")
+		return
+	}
+	input, err := base64.StdEncoding.DecodeString(req.Input)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "unable to decode input as base64")
+		return
+	}
+	hash, ok := map[string]crypto.Hash{
+		"sha2-256": crypto.SHA256,
+		"sha2-384": crypto.SHA384,
+		"sha2-512": crypto.SHA512,
+	}[hashName]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "unsupported hash algorithm")
+		return
+	}
+	if req.MarshalingAlgorithm != "" && req.MarshalingAlgorithm != "asn1" {
+		writeError(w, http.StatusBadRequest, "only asn1 marshaling is supported")
+		return
+	}
+
+	digest := input
+	if _, isEd25519 := key.versions[version-1].(ed25519.PrivateKey); !isEd25519 && !req.Prehashed {
+		h := hash.New()
+		h.Write(input)
+		digest = h.Sum(nil)
+	}
+
+	var sig []byte
+	switch signer := key.versions[version-1].(type) {
+	case *rsa.PrivateKey:
+		switch req.SignatureAlgorithm {
+		case "pss":
+			saltLength := rsa.PSSSaltLengthAuto
+			if req.SaltLength == "hash" {
+				saltLength = rsa.PSSSaltLengthEqualsHash
+			}
+			sig, err = rsa.SignPSS(rand.Reader, signer, hash, digest, &rsa.PSSOptions{SaltLength: saltLength})
+		case "", "pkcs1v15":
+			sig, err = rsa.SignPKCS1v15(rand.Reader, signer, hash, digest)
+		default:
+			writeError(w, http.StatusBadRequest, "unsupported signature algorithm")
+			return
+		}
+	case *ecdsa.PrivateKey:
+		sig, err = ecdsa.SignASN1(rand.Reader, signer, digest)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(signer, input)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.signRequests++
+	writeData(w, map[string]interface{}{
+		"signature":   fmt.Sprintf("vault:v%d:%s", version, base64.StdEncoding.EncodeToString(sig)),
+		"key_version": version,
+	})
+}
+
+func generate(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "rsa-2048":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "rsa-3072":
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case "rsa-4096":
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case "ecdsa-p256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ecdsa-p521":
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", keyType)
+	}
+}
+
+// encodePublicKey encodes pub like Transit: base64 for Ed25519, PEM otherwise.
+func encodePublicKey(pub crypto.PublicKey) (string, error) {
+	if pub, ok := pub.(ed25519.PublicKey); ok {
+		return base64.StdEncoding.EncodeToString(pub), nil
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+func writeData(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{message}})
+}